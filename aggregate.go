@@ -0,0 +1,225 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The aggregation of the entities is in the query string with the names of
+// the fields in json, separated with comma:
+//
+//	/notes/aggregate?group_by=category_id,created_at:month&count=id&sum=words
+//
+// The functions are count, sum, avg, min and max (sum and avg only of
+// numbers, count=* count the rows) and the times of group_by can be grouped
+// by year, month, day or hour. The other params are the filter of the lists.
+// The response has one object for each group:
+//
+//	[{"group": {"category_id": 1, "created_at:month": "2020-01"},
+//	  "count": {"id": 3}, "sum": {"words": 120}}]
+
+// OpAggregate is the operation of the aggregation
+const OpAggregate Operation = "aggregate"
+
+// aggregateFuncs are the functions of the aggregation in their order
+var aggregateFuncs = []string{"count", "sum", "avg", "min", "max"}
+
+// aggregateBuckets are the formats of the times of group_by by dialect
+var aggregateBuckets = map[string]map[string]string{
+	"sqlite3":  {"year": "strftime('%%Y', %s)", "month": "strftime('%%Y-%%m', %s)", "day": "strftime('%%Y-%%m-%%d', %s)", "hour": "strftime('%%Y-%%m-%%dT%%H', %s)"},
+	"postgres": {"year": "to_char(%s, 'YYYY')", "month": "to_char(%s, 'YYYY-MM')", "day": "to_char(%s, 'YYYY-MM-DD')", "hour": `to_char(%s, 'YYYY-MM-DD"T"HH24')`},
+	"mysql":    {"year": "DATE_FORMAT(%s, '%%Y')", "month": "DATE_FORMAT(%s, '%%Y-%%m')", "day": "DATE_FORMAT(%s, '%%Y-%%m-%%d')", "hour": "DATE_FORMAT(%s, '%%Y-%%m-%%dT%%H')"},
+	"mssql":    {"year": "FORMAT(%s, 'yyyy')", "month": "FORMAT(%s, 'yyyy-MM')", "day": "FORMAT(%s, 'yyyy-MM-dd')", "hour": "FORMAT(%s, 'yyyy-MM-ddTHH')"},
+}
+
+// aggregateColumn is one column of the select of the aggregation
+type aggregateColumn struct {
+	kind string
+	name string
+	sql  string
+}
+
+// aggregateField return the property of the field that is not a relation
+func aggregateField(props []resourceProperty, name string) (resourceProperty, bool) {
+	for _, prop := range props {
+		if prop.name == name && !prop.relation {
+			return prop, true
+		}
+	}
+	return resourceProperty{}, false
+}
+
+// isNumber return true if the type is a number
+func isNumber(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseAggregate return the columns of group_by and of the functions of the
+// query
+func parseAggregate(db *gorm.DB, entity interface{}, props []resourceProperty, query url.Values) ([]aggregateColumn, error) {
+	scope := db.NewScope(entity)
+	column := func(prop resourceProperty) string {
+		return scope.QuotedTableName() + "." + scope.Quote(prop.column)
+	}
+	invalid := func(param string, name string) error {
+		return ErrorCrud{Message: "Invalid " + param + " " + name, Code: http.StatusBadRequest}
+	}
+	var columns []aggregateColumn
+	for _, name := range strings.Split(query.Get("group_by"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		field, bucket := name, ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			field, bucket = name[:i], name[i+1:]
+		}
+		prop, ok := aggregateField(props, field)
+		if !ok {
+			return nil, invalid("group_by", name)
+		}
+		sql := column(prop)
+		if bucket != "" {
+			format, ok := aggregateBuckets[db.Dialect().GetName()][bucket]
+			if !ok || baseType(prop.typ) != timeType {
+				return nil, invalid("group_by", name)
+			}
+			sql = fmt.Sprintf(format, sql)
+		}
+		columns = append(columns, aggregateColumn{kind: "group", name: name, sql: sql})
+	}
+	for _, fn := range aggregateFuncs {
+		for _, name := range strings.Split(query.Get(fn), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if fn == "count" && name == "*" {
+				columns = append(columns, aggregateColumn{kind: fn, name: name, sql: "COUNT(*)"})
+				continue
+			}
+			prop, ok := aggregateField(props, name)
+			if !ok || ((fn == "sum" || fn == "avg") && !isNumber(prop.typ)) {
+				return nil, invalid(fn, name)
+			}
+			columns = append(columns, aggregateColumn{kind: fn, name: name, sql: strings.ToUpper(fn) + "(" + column(prop) + ")"})
+		}
+	}
+	if len(columns) == 0 || columns[len(columns)-1].kind == "group" {
+		return nil, ErrorCrud{Message: "Aggregate without functions", Code: http.StatusBadRequest}
+	}
+	return columns, nil
+}
+
+// aggregateValue return the value of the column for json
+func aggregateValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.UTC()
+	}
+	return v
+}
+
+// Aggregate is operation for count, sum, avg, min and max of the entities
+// grouped by fields
+func Aggregate(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		if err := authorize(r.Context(), db, OpAggregate, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		props := resourceProperties(db, reflect.TypeOf(elem), Roles(r.Context()))
+		columns, err := parseAggregate(db, entity, props, r.URL.Query())
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		params := url.Values{}
+		for key, values := range r.URL.Query() {
+			params[key] = values
+		}
+		params.Del("group_by")
+		for _, fn := range aggregateFuncs {
+			params.Del(fn)
+		}
+		filter, err := ParseFilter(r.Context(), db, entity, params)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		var selects, groups []string
+		for _, c := range columns {
+			selects = append(selects, c.sql)
+			if c.kind == "group" {
+				groups = append(groups, c.sql)
+			}
+		}
+		query, err := Trashed(db, r, entity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		query = filter.Apply(ScopeTenant(query, entity), entity).Model(entity).Select(strings.Join(selects, ", "))
+		if len(groups) > 0 {
+			query = query.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+		}
+		rows, err := query.Rows()
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		defer rows.Close()
+		result := []*Object{}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				WriteError(w, err)
+				return
+			}
+			row := &Object{}
+			for i, c := range columns {
+				value, _ := row.Get(c.kind)
+				object, ok := value.(*Object)
+				if !ok {
+					object = &Object{}
+					row.Set(c.kind, object)
+				}
+				object.Set(c.name, aggregateValue(values[i]))
+			}
+			result = append(result, row)
+		}
+		if err := rows.Err(); err != nil {
+			WriteError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,131 @@
+package gormcrud
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func newAggregateMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{}, &Doc{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).Full().
+		NewMap("/member", Member{}, []Member{}).Full().
+		NewMap("/doc", Doc{}, []Doc{}).Full()
+	month := func(m time.Month, day int) time.Time {
+		return time.Date(2020, m, day, 10, 0, 0, 0, time.UTC)
+	}
+	for _, note := range []Note{
+		{Title: "a", Words: 10, CreatedAt: month(1, 5)},
+		{Title: "a", Words: 20, CreatedAt: month(1, 20)},
+		{Title: "b", Words: 5, CreatedAt: month(2, 1)},
+		{Title: "c", Words: 1, CreatedAt: month(3, 1)},
+	} {
+		if err := db.Create(&note).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, r
+}
+
+// getAggregate return the json of the aggregation of the query
+func getAggregate(t *testing.T, h http.Handler, query string) string {
+	t.Helper()
+	w := serve(h, "GET", "/note/aggregate?"+query, "")
+	expectCode(t, w, http.StatusOK)
+	return w.Body.String()
+}
+
+func TestAggregate(t *testing.T) {
+	_, r := newAggregateMux(t)
+	for query, want := range map[string]string{
+		"count=*": `[{"count":{"*":4}}]`,
+		"count=id&sum=words&avg=words&min=words,title&max=words,title": `[{"count":{"id":4},"sum":{"words":36},"avg":{"words":9},` +
+			`"min":{"words":1,"title":"a"},"max":{"words":20,"title":"c"}}]`,
+		"group_by=title&count=*&sum=words": `[{"group":{"title":"a"},"count":{"*":2},"sum":{"words":30}},` +
+			`{"group":{"title":"b"},"count":{"*":1},"sum":{"words":5}},{"group":{"title":"c"},"count":{"*":1},"sum":{"words":1}}]`,
+		"group_by=created_at:month&count=*": `[{"group":{"created_at:month":"2020-01"},"count":{"*":2}},` +
+			`{"group":{"created_at:month":"2020-02"},"count":{"*":1}},{"group":{"created_at:month":"2020-03"},"count":{"*":1}}]`,
+		"group_by=created_at:year&max=words": `[{"group":{"created_at:year":"2020"},"max":{"words":20}}]`,
+		"group_by=created_at:day&count=*&words[gt]=5": `[{"group":{"created_at:day":"2020-01-05"},"count":{"*":1}},` +
+			`{"group":{"created_at:day":"2020-01-20"},"count":{"*":1}}]`,
+		"group_by=title, words&count=*&title=a": `[{"group":{"title":"a","words":10},"count":{"*":1}},` +
+			`{"group":{"title":"a","words":20},"count":{"*":1}}]`,
+		"group_by=title&count=*&title=z": `[]`,
+		"sum=words&title=z":              `[{"sum":{"words":null}}]`,
+	} {
+		if got := getAggregate(t, r, url.PathEscape(query)); got != want+"\n" {
+			t.Errorf("%s: %s", query, got)
+		}
+	}
+}
+
+func TestAggregateRoutes(t *testing.T) {
+	db := openTestDB(t)
+	db.Create(&Note{Title: "a", Words: 2})
+	r := mux.NewRouter()
+	// the aggregation of Full is before Get, Aggregate after Full is not
+	// shadowed by /{id}
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).Full().Aggregate().
+		NewMap("/tag", Tag{}, []Tag{}).Full()
+	if got := getAggregate(t, r, "sum=words"); got != `[{"sum":{"words":2}}]`+"\n" {
+		t.Fatalf("aggregate after Full %s", got)
+	}
+	expectCode(t, serve(r, "GET", "/tag/aggregate?count=*", ""), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/note/1", ""), http.StatusOK)
+}
+
+func TestAggregateTrashed(t *testing.T) {
+	db, r := newAggregateMux(t)
+	db.Delete(&Note{ID: 4})
+	if got := getAggregate(t, r, "count=*"); got != `[{"count":{"*":3}}]`+"\n" {
+		t.Fatalf("without the trashed %s", got)
+	}
+	if got := getAggregate(t, r, "count=*&trashed=with"); got != `[{"count":{"*":4}}]`+"\n" {
+		t.Fatalf("with the trashed %s", got)
+	}
+	if got := getAggregate(t, r, "count=*&trashed=only"); got != `[{"count":{"*":1}}]`+"\n" {
+		t.Fatalf("only the trashed %s", got)
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	db, r := newAggregateMux(t)
+	for _, query := range []string{
+		"",
+		"group_by=title",
+		"count=other",
+		"sum=title",
+		"avg=created_at",
+		"count=tags",
+		"group_by=tags&count=*",
+		"group_by=title:month&count=*",
+		"group_by=created_at:week&count=*",
+		"group_by=other&count=*",
+		"count=*&other=1",
+		"count=*&trashed=x",
+	} {
+		w := serve(r, "GET", "/note/aggregate?"+url.PathEscape(query), "")
+		expectCode(t, w, http.StatusBadRequest)
+		var e ErrorCrud
+		decode(t, w, &e)
+		if e.Code != http.StatusBadRequest || e.Message == "" {
+			t.Errorf("%s: %s", query, w.Body.String())
+		}
+	}
+
+	// the fields hidden by the tag crud can not be aggregated
+	db.Create(&Member{Login: "x", Password: "p", Note: "n"})
+	expectCode(t, serve(r, "GET", "/member/aggregate?max=password", ""), http.StatusBadRequest)
+	expectCode(t, serve(withRoles(r, "guest"), "GET", "/member/aggregate?group_by=note&count=*", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "GET", "/member/aggregate?group_by=note&count=*", ""), http.StatusOK)
+
+	// the aggregation is authorized like the other operations
+	expectCode(t, serve(r, "GET", "/doc/aggregate?count=*", ""), http.StatusForbidden)
+}
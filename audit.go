@@ -0,0 +1,206 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// auditKey is the key of the AuditSink in the settings of gorm.DB
+const auditKey = "gormcrud:audit"
+
+// OpHistory is the operation of the history of the audit
+const OpHistory Operation = "history"
+
+// AuditEntry is one mutation of one entity
+type AuditEntry struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Principal string    `json:"principal"`
+	Resource  string    `json:"resource" gorm:"index:idx_gormcrud_audit_entity"`
+	EntityID  string    `json:"entity_id" gorm:"index:idx_gormcrud_audit_entity"`
+	Operation string    `json:"operation"`
+	Diff      string    `json:"diff" sql:"type:text"`
+}
+
+// TableName is the table of the audit
+func (AuditEntry) TableName() string {
+	return "gormcrud_audit"
+}
+
+// AuditSink store the entries of the audit. Write is called inside the
+// transaction of the operation.
+type AuditSink interface {
+	Write(db *gorm.DB, entry AuditEntry) error
+}
+
+// AuditHistory is the AuditSink that can return the history of one entity
+type AuditHistory interface {
+	History(db *gorm.DB, resource string, id string) ([]AuditEntry, error)
+}
+
+// DBAuditSink store the audit in the table gormcrud_audit of the same db
+type DBAuditSink struct{}
+
+// NewDBAuditSink migrate the table of the audit and return the sink
+func NewDBAuditSink(db *gorm.DB) DBAuditSink {
+	db.AutoMigrate(&AuditEntry{})
+	return DBAuditSink{}
+}
+
+// Write insert the entry
+func (DBAuditSink) Write(db *gorm.DB, entry AuditEntry) error {
+	return db.New().Create(&entry).Error
+}
+
+// History return the entries of the entity, the last first
+func (DBAuditSink) History(db *gorm.DB, resource string, id string) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	ret := db.New().Where("resource = ? AND entity_id = ?", resource, id).Order("id desc").Find(&entries)
+	return entries, ret.Error
+}
+
+// withAudit set the sink in the settings of db
+func withAudit(db *gorm.DB, sink AuditSink) *gorm.DB {
+	if sink == nil {
+		return db
+	}
+	return db.Set(auditKey, sink)
+}
+
+// auditSnapshot return the state saved in the db of the entity, it is nil if
+// the audit is off or the entity is new
+func auditSnapshot(db *gorm.DB, entity interface{}) map[string]json.RawMessage {
+	if _, ok := db.Get(auditKey); !ok {
+		return nil
+	}
+	scope := db.NewScope(entity)
+	if scope.PrimaryKeyZero() {
+		return nil
+	}
+	saved := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+	ret := db.Unscoped().Set("gorm:auto_preload", true).
+		Where(scope.PrimaryKey()+" = ?", scope.PrimaryKeyValue()).First(saved)
+	if ret.RowsAffected == 0 {
+		return nil
+	}
+	return snapshotOf(saved)
+}
+
+// snapshotOf return the fields of the entity in json without the fields
+// hidden by the tag crud
+func snapshotOf(entity interface{}) map[string]json.RawMessage {
+	data, err := json.Marshal(publicValue(context.Background(), entity))
+	if err != nil {
+		return nil
+	}
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	return fields
+}
+
+// auditDiff return the json diff {"field": {"before": x, "after": y}} with
+// the changed fields
+func auditDiff(before, after map[string]json.RawMessage) string {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	type change struct {
+		Before json.RawMessage `json:"before"`
+		After  json.RawMessage `json:"after"`
+	}
+	diff := map[string]change{}
+	for _, k := range names {
+		b, a := before[k], after[k]
+		if b == nil {
+			b = json.RawMessage("null")
+		}
+		if a == nil {
+			a = json.RawMessage("null")
+		}
+		if !bytes.Equal(b, a) {
+			diff[k] = change{Before: b, After: a}
+		}
+	}
+	data, _ := json.Marshal(diff)
+	return string(data)
+}
+
+// writeAudit write the entry of the operation on entity. before is the state
+// before the operation and the state after is read from db (it is nil for
+// delete and purge).
+func writeAudit(db *gorm.DB, operation string, entity interface{}, before map[string]json.RawMessage) error {
+	sink, ok := db.Get(auditKey)
+	if !ok {
+		return nil
+	}
+	var after map[string]json.RawMessage
+	if operation != "delete" && operation != "purge" {
+		after = auditSnapshot(db, entity)
+	}
+	entry := AuditEntry{
+		CreatedAt: time.Now(),
+		Resource:  db.NewScope(entity).TableName(),
+		EntityID:  fmt.Sprint(db.NewScope(entity).PrimaryKeyValue()),
+		Operation: operation,
+		Diff:      auditDiff(before, after),
+	}
+	if principal := PrincipalFromDB(db); principal != nil {
+		entry.Principal = principal.Subject
+	}
+	return sink.(AuditSink).Write(db, entry)
+}
+
+// History return the audit of one entity
+func History(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		sink, _ := db.Get(auditKey)
+		history, ok := sink.(AuditHistory)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorCrud{Message: "Audit Not Found", Code: http.StatusNotFound})
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		ret := ScopeTenant(db.Unscoped(), entity).Where("id = ?", id).First(entity)
+		if ret.RowsAffected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound})
+			return
+		}
+		if err := authorize(r.Context(), db, OpHistory, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		entries, err := history.History(db, db.NewScope(entity).TableName(), id)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}
+}
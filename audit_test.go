@@ -0,0 +1,103 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func newAuditMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	keys := map[string]*Principal{"k1": {Subject: "alice"}}
+	MapMux(r, db).AuthenticateWith(APIKey("X-Api-Key", keys)).AuditTo(NewDBAuditSink(db)).
+		NewMap("/note", Note{}, []Note{}).Full().
+		NewMap("/tag", Tag{}, []Tag{}).Full().
+		NewMap("/member", Member{}, []Member{}).Full()
+	return db, r
+}
+
+func history(t *testing.T, r http.Handler, url string) []AuditEntry {
+	t.Helper()
+	var entries []AuditEntry
+	w := serve(r, "GET", url, "", "X-Api-Key", "k1")
+	expectCode(t, w, http.StatusOK)
+	decode(t, w, &entries)
+	return entries
+}
+
+func TestAuditHistory(t *testing.T) {
+	_, r := newAuditMux(t)
+	key := []string{"X-Api-Key", "k1"}
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`, key...), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/tag", `{"name":"x"}`, key...), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"id":1,"title":"b"}`, key...), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/note/1/link?tags=1", "", key...), http.StatusOK)
+	expectCode(t, serve(r, "DELETE", "/note/1", "", key...), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note/1/restore", "", key...), http.StatusOK)
+	// the failed operations are not in the audit
+	expectCode(t, serve(r, "GET", "/note/1/link?tags=9", "", key...), http.StatusNotFound)
+
+	entries := history(t, r, "/note/1/history")
+	var operations []string
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+		if entry.Principal != "alice" || entry.Resource != "note" || entry.EntityID != "1" {
+			t.Fatalf("entry %+v", entry)
+		}
+	}
+	if !reflect.DeepEqual(operations, []string{"restore", "delete", "link", "update", "create"}) {
+		t.Fatalf("operations %v", operations)
+	}
+
+	var diff map[string]struct{ Before, After json.RawMessage }
+	json.Unmarshal([]byte(entries[3].Diff), &diff)
+	if string(diff["title"].Before) != `"a"` || string(diff["title"].After) != `"b"` {
+		t.Fatalf("diff of update %s", entries[3].Diff)
+	}
+	if _, ok := diff["id"]; ok {
+		t.Fatalf("unchanged field in the diff %s", entries[3].Diff)
+	}
+	json.Unmarshal([]byte(entries[4].Diff), &diff)
+	if string(diff["title"].Before) != "null" {
+		t.Fatalf("diff of create %s", entries[4].Diff)
+	}
+
+	expectCode(t, serve(r, "GET", "/note/9/history", "", key...), http.StatusNotFound)
+}
+
+func TestAuditHiddenFields(t *testing.T) {
+	_, r := newAuditMux(t)
+	expectCode(t, serve(r, "POST", "/member", `{"login":"a","password":"secret"}`, "X-Api-Key", "k1"), http.StatusOK)
+	entries := history(t, r, "/member/1/history")
+	if len(entries) != 1 {
+		t.Fatalf("entries %+v", entries)
+	}
+	var diff map[string]interface{}
+	json.Unmarshal([]byte(entries[0].Diff), &diff)
+	if _, ok := diff["password"]; ok || diff["login"] == nil {
+		t.Fatalf("diff %s", entries[0].Diff)
+	}
+}
+
+func TestAuditDiff(t *testing.T) {
+	before := map[string]json.RawMessage{"a": json.RawMessage("1"), "b": json.RawMessage("2")}
+	after := map[string]json.RawMessage{"a": json.RawMessage("1"), "c": json.RawMessage("3")}
+	want := `{"b":{"before":2,"after":null},"c":{"before":null,"after":3}}`
+	if diff := auditDiff(before, after); diff != want {
+		t.Fatalf("diff %s", diff)
+	}
+	if diff := auditDiff(before, before); diff != "{}" {
+		t.Fatalf("diff without changes %s", diff)
+	}
+}
+
+func TestHistoryWithoutAudit(t *testing.T) {
+	_, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/note/1/history", ""), http.StatusNotFound)
+}
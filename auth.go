@@ -0,0 +1,89 @@
+package gormcrud
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Operation is the name of operation of the api
+type Operation string
+
+// Operations of the api
+const (
+	OpGet     Operation = "get"
+	OpAll     Operation = "all"
+	OpPage    Operation = "page"
+	OpSave    Operation = "save"
+	OpDelete  Operation = "delete"
+	OpRestore Operation = "restore"
+	OpLink    Operation = "link"
+	OpUnlink  Operation = "unlink"
+)
+
+// policyKey is the key of the Policy in the settings of gorm.DB
+const policyKey = "gormcrud:policy"
+
+// Errors for Policy and Authorize
+var (
+	ErrUnauthorized = ErrorCrud{Message: "Unauthorized", Code: http.StatusUnauthorized}
+	ErrForbidden    = ErrorCrud{Message: "Forbidden", Code: http.StatusForbidden}
+)
+
+// Policy is the authorization of one resource. For Get, Save, Delete,
+// Restore, Link and Unlink entity is the entity, for All and Page entity is
+// the pointer to the slice of entities. The update of Save is authorized
+// twice, with the stored entity and with the new entity.
+type Policy func(ctx context.Context, op Operation, entity interface{}) error
+
+// Authorize is interface for authorization implemented by the entity
+type Authorize interface {
+	CrudAuthorize(ctx context.Context, op Operation) error
+}
+
+// withPolicy set the policy in the settings of db
+func withPolicy(db *gorm.DB, policy Policy) *gorm.DB {
+	if policy == nil {
+		return db
+	}
+	return db.Set(policyKey, policy)
+}
+
+// authorize run the policy of the resource and Authorize of the entity (of
+// each entity for slices). The errors that are not ErrorCrud are returned as
+// forbidden.
+func authorize(ctx context.Context, db *gorm.DB, op Operation, entity interface{}) error {
+	err := authorizeEntity(ctx, db, op, entity)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(ErrorCrud); ok {
+		return err
+	}
+	return ErrorCrud{Message: err.Error(), Code: http.StatusForbidden}
+}
+
+func authorizeEntity(ctx context.Context, db *gorm.DB, op Operation, entity interface{}) error {
+	if policy, ok := db.Get(policyKey); ok {
+		if err := policy.(Policy)(ctx, op, entity); err != nil {
+			return err
+		}
+	}
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if v.Kind() != reflect.Slice {
+		if a, ok := entity.(Authorize); ok {
+			return a.CrudAuthorize(ctx, op)
+		}
+		return nil
+	}
+	for i := 0; i < v.Len(); i++ {
+		if a, ok := v.Index(i).Addr().Interface().(Authorize); ok {
+			if err := a.CrudAuthorize(ctx, op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package gormcrud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Doc can be written only by its owner, the user of the test is alice
+type Doc struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Owner string `json:"owner"`
+	Title string `json:"title"`
+}
+
+func (d *Doc) CrudAuthorize(ctx context.Context, op Operation) error {
+	if op != OpGet && op != OpAll && op != OpPage && d.Owner != "alice" {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func newDocMux(t *testing.T, policy Policy) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Doc{})
+	db.Create(&Doc{Owner: "alice", Title: "mine"})
+	db.Create(&Doc{Owner: "bob", Title: "other"})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/doc", Doc{}, []Doc{}).Authorize(policy).Full()
+	return db, r
+}
+
+func TestAuthorizeEntity(t *testing.T) {
+	_, r := newDocMux(t, nil)
+	expectCode(t, serve(r, "GET", "/doc/2", ""), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/doc", `{"owner":"bob"}`), http.StatusForbidden)
+	expectCode(t, serve(r, "POST", "/doc", `{"owner":"alice"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/doc", `{"id":1,"owner":"alice","title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "DELETE", "/doc/2", ""), http.StatusForbidden)
+	expectCode(t, serve(r, "DELETE", "/doc/1", ""), http.StatusOK)
+}
+
+func TestAuthorizeUpdateStoredEntity(t *testing.T) {
+	db, r := newDocMux(t, nil)
+	// the body forges the owner of the doc of bob
+	expectCode(t, serve(r, "POST", "/doc", `{"id":2,"owner":"alice","title":"stolen"}`), http.StatusForbidden)
+	var doc Doc
+	db.First(&doc, 2)
+	if doc.Owner != "bob" || doc.Title != "other" {
+		t.Fatalf("doc updated %+v", doc)
+	}
+	// and the owner can not give its doc
+	expectCode(t, serve(r, "POST", "/doc", `{"id":1,"owner":"bob"}`), http.StatusForbidden)
+
+	// the other protocols save with the same path
+	service := MapMux(mux.NewRouter(), db).NewMap("/doc", Doc{}, []Doc{}).Service()
+	_, err := service.Save(httptest.NewRequest("POST", "/", nil), &Doc{ID: 2, Owner: "alice"})
+	if errCrud, ok := err.(ErrorCrud); !ok || errCrud.Code != http.StatusForbidden {
+		t.Fatalf("error of service %v", err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	var ops []Operation
+	policy := func(ctx context.Context, op Operation, entity interface{}) error {
+		ops = append(ops, op)
+		if doc, ok := entity.(*Doc); ok && doc.Title == "secret" {
+			return ErrForbidden
+		}
+		if _, ok := entity.(*[]Doc); ok && op == OpPage {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+	db, r := newDocMux(t, policy)
+	db.Model(&Doc{}).Where("id = 2").Update("title", "secret")
+
+	expectCode(t, serve(r, "GET", "/doc/1", ""), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/doc/2", ""), http.StatusForbidden)
+	expectCode(t, serve(r, "GET", "/doc", ""), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/doc.page?page=1&limit=5", ""), http.StatusUnauthorized)
+	ops = nil
+	expectCode(t, serve(r, "POST", "/doc", `{"id":1,"owner":"alice","title":"x"}`), http.StatusOK)
+	if !reflect.DeepEqual(ops, []Operation{OpSave, OpSave}) {
+		t.Fatalf("operations of update %v", ops)
+	}
+	ops = nil
+	expectCode(t, serve(r, "POST", "/doc", `{"owner":"alice"}`), http.StatusOK)
+	if !reflect.DeepEqual(ops, []Operation{OpSave}) {
+		t.Fatalf("operations of create %v", ops)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	db := openTestDB(t)
+	plain := func(ctx context.Context, op Operation, entity interface{}) error {
+		return errors.New("nope")
+	}
+	err := authorize(context.Background(), withPolicy(db, plain), OpGet, &Note{})
+	if errCrud, ok := err.(ErrorCrud); !ok || errCrud.Code != http.StatusForbidden || errCrud.Message != "nope" {
+		t.Fatalf("error %#v", err)
+	}
+	if err := authorize(context.Background(), withPolicy(db, nil), OpGet, &Note{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,299 @@
+package gormcrud
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// principalKey is the key of the Principal in the settings of gorm.DB
+const principalKey = "gormcrud:principal"
+
+// Principal is the authenticated client of the request
+type Principal struct {
+	Subject string                 `json:"subject"`
+	Roles   []string               `json:"roles"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Authenticator return the principal of the request. It returns nil, nil if
+// the request is without credentials of the authenticator.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc is a function used as Authenticator
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+// Authenticate call f(r)
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// ErrUnauthenticated is returned when the request is without valid credentials
+var ErrUnauthenticated = ErrorCrud{Message: "Unauthenticated", Code: http.StatusUnauthorized}
+
+type contextPrincipal struct{}
+
+// WithPrincipal return the context with the principal and its roles
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	ctx = context.WithValue(ctx, contextPrincipal{}, principal)
+	return WithRoles(ctx, principal.Roles...)
+}
+
+// PrincipalFromContext return the principal of the request
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextPrincipal{}).(*Principal)
+	return principal
+}
+
+// PrincipalFromDB return the principal of the request from the db received
+// by ValidateSave, ValidateDelete and the other hooks
+func PrincipalFromDB(db *gorm.DB) *Principal {
+	principal, _ := db.Get(principalKey)
+	p, _ := principal.(*Principal)
+	return p
+}
+
+// requestDB return db with the principal and the tenant of the request
+func requestDB(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		db = db.Set(principalKey, principal)
+	}
+	return resolveTenant(db, r)
+}
+
+// TenantFromClaim return the resolver of the tenant from the claim of the
+// principal (see JWT)
+func TenantFromClaim(claim string) TenantResolver {
+	return func(r *http.Request) (interface{}, error) {
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil || principal.Claims[claim] == nil {
+			return nil, ErrTenantNotFound
+		}
+		return fmt.Sprint(principal.Claims[claim]), nil
+	}
+}
+
+// FirstOf return the Authenticator that use the first authenticator that
+// find credentials in the request
+func FirstOf(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		for _, a := range authenticators {
+			principal, err := a.Authenticate(r)
+			if err != nil || principal != nil {
+				return principal, err
+			}
+		}
+		return nil, nil
+	})
+}
+
+// APIKey return the Authenticator of static api keys sent in the header
+// name. keys map the api key to its principal.
+func APIKey(header string, keys map[string]*Principal) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		key := r.Header.Get(header)
+		if key == "" {
+			return nil, nil
+		}
+		for k, principal := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return principal, nil
+			}
+		}
+		return nil, ErrUnauthenticated
+	})
+}
+
+// JWTConfig is the configuration of JWT. The tokens signed with HS256, HS384
+// and HS512 are verified with Secret and the tokens signed with RS256, RS384
+// and RS512 with PublicKey.
+type JWTConfig struct {
+	Secret     []byte
+	PublicKey  *rsa.PublicKey
+	Issuer     string
+	Audience   string
+	RolesClaim string
+	Leeway     time.Duration
+}
+
+// JWT return the Authenticator of bearer tokens of the header Authorization
+func JWT(config JWTConfig) Authenticator {
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, nil
+		}
+		claims, err := config.verify(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			return nil, ErrorCrud{Message: "Unauthenticated: " + err.Error(), Code: http.StatusUnauthorized}
+		}
+		principal := &Principal{Claims: claims}
+		principal.Subject, _ = claims["sub"].(string)
+		switch roles := claims[config.RolesClaim].(type) {
+		case string:
+			principal.Roles = strings.Fields(roles)
+		case []interface{}:
+			for _, role := range roles {
+				principal.Roles = append(principal.Roles, fmt.Sprint(role))
+			}
+		}
+		return principal, nil
+	})
+}
+
+// ParseRSAPublicKey parse the public key PEM (PKIX or PKCS1) for JWTConfig
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// verify check the signature and the registered claims of the token
+func (config JWTConfig) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := config.verifySignature(header.Alg, signed, signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(config.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if config.Issuer != "" && claims["iss"] != config.Issuer {
+		return nil, errors.New("invalid issuer")
+	}
+	if config.Audience != "" && !hasAudience(claims["aud"], config.Audience) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+func (config JWTConfig) verifySignature(alg string, signed []byte, signature []byte) error {
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	if len(alg) != 5 {
+		return errors.New("unsupported algorithm")
+	}
+	switch alg[2:] {
+	case "256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "384":
+		newHash, cryptoHash = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, cryptoHash = sha512.New, crypto.SHA512
+	default:
+		return errors.New("unsupported algorithm")
+	}
+	switch {
+	case strings.HasPrefix(alg, "HS") && len(config.Secret) > 0:
+		mac := hmac.New(newHash, config.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS") && config.PublicKey != nil:
+		h := newHash()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(config.PublicKey, cryptoHash, h.Sum(nil), signature)
+	}
+	return errors.New("unsupported algorithm")
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate return the handler that authenticate the request before f.
+// The principal is in the context of the request (PrincipalFromContext) and
+// in the db of the hooks (PrincipalFromDB).
+func Authenticate(authenticator Authenticator, f func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request, string) {
+	if authenticator == nil {
+		return f
+	}
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		principal, err := authenticator.Authenticate(r)
+		if err == nil && principal == nil {
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			WriteError(w, err)
+			return
+		}
+		f(w, r.WithContext(WithPrincipal(r.Context(), principal)), id)
+	}
+}
@@ -0,0 +1,196 @@
+package gormcrud
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var testSecret = []byte("secret")
+
+// signJWT return the token of the claims signed with alg HS256 or RS256
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, key interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authenticate(a Authenticator, header string, value string) (*Principal, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return a.Authenticate(r)
+}
+
+func TestJWT(t *testing.T) {
+	now := time.Now().Unix()
+	a := JWT(JWTConfig{Secret: testSecret, Issuer: "gormcrud", Audience: "api"})
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "gormcrud", "aud": "api", "exp": now + 60, "roles": []string{"admin", "user"}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	principal, err := authenticate(a, "Authorization", "Bearer "+signJWT(t, "HS256", claims(nil), testSecret))
+	if err != nil || principal.Subject != "alice" || !reflect.DeepEqual(principal.Roles, []string{"admin", "user"}) {
+		t.Fatalf("principal %+v %v", principal, err)
+	}
+	principal, err = authenticate(a, "Authorization", "Bearer "+signJWT(t, "HS256", claims(map[string]interface{}{"aud": []string{"x", "api"}, "roles": "a b"}), testSecret))
+	if err != nil || !reflect.DeepEqual(principal.Roles, []string{"a", "b"}) {
+		t.Fatalf("principal %+v %v", principal, err)
+	}
+	if principal, err := authenticate(a, "", ""); principal != nil || err != nil {
+		t.Fatalf("request without token %v %v", principal, err)
+	}
+
+	valid := signJWT(t, "HS256", claims(nil), testSecret)
+	parts := strings.Split(valid, ".")
+	forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+	invalid := map[string]string{
+		"signature":  signJWT(t, "HS256", claims(nil), []byte("other")),
+		"payload":    parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2],
+		"expired":    signJWT(t, "HS256", claims(map[string]interface{}{"exp": now - 60}), testSecret),
+		"not before": signJWT(t, "HS256", claims(map[string]interface{}{"nbf": now + 60}), testSecret),
+		"issuer":     signJWT(t, "HS256", claims(map[string]interface{}{"iss": "other"}), testSecret),
+		"audience":   signJWT(t, "HS256", claims(map[string]interface{}{"aud": []string{"other"}}), testSecret),
+		"alg none":   signJWT(t, "none", claims(nil), nil),
+		"rsa":        signJWT(t, "RS256", claims(nil), testRSAKey(t)),
+		"malformed":  "a.b",
+		"base64":     parts[0] + "." + parts[1] + ".!",
+	}
+	for name, token := range invalid {
+		principal, err := authenticate(a, "Authorization", "Bearer "+token)
+		if errCrud, ok := err.(ErrorCrud); principal != nil || !ok || errCrud.Code != http.StatusUnauthorized {
+			t.Errorf("%s: %+v %v", name, principal, err)
+		}
+	}
+
+	leeway := JWT(JWTConfig{Secret: testSecret, Leeway: time.Minute})
+	if _, err := authenticate(leeway, "Authorization", "Bearer "+signJWT(t, "HS256", map[string]interface{}{"exp": now - 10}, testSecret)); err != nil {
+		t.Fatalf("leeway %v", err)
+	}
+}
+
+var rsaKeyForTest *rsa.PrivateKey
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	if rsaKeyForTest == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsaKeyForTest = key
+	}
+	return rsaKeyForTest
+}
+
+func TestJWTRSA(t *testing.T) {
+	key := testRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}))
+	if err != nil || pkcs1.N.Cmp(public.N) != 0 {
+		t.Fatalf("PKCS1 %v", err)
+	}
+	if _, err := ParseRSAPublicKey([]byte("x")); err == nil {
+		t.Fatal("invalid PEM parsed")
+	}
+
+	a := JWT(JWTConfig{PublicKey: public, Secret: testSecret})
+	if _, err := authenticate(a, "Authorization", "Bearer "+signJWT(t, "RS256", map[string]interface{}{"sub": "a"}, key)); err != nil {
+		t.Fatal(err)
+	}
+	// the public key is not a secret of HMAC
+	confused := signJWT(t, "HS256", map[string]interface{}{"sub": "a"}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if _, err := authenticate(a, "Authorization", "Bearer "+confused); err == nil {
+		t.Fatal("token signed with the public key accepted")
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	alice := &Principal{Subject: "alice", Roles: []string{"admin"}}
+	a := FirstOf(APIKey("X-Api-Key", map[string]*Principal{"k1": alice}), JWT(JWTConfig{Secret: testSecret}))
+	if principal, err := authenticate(a, "X-Api-Key", "k1"); err != nil || principal != alice {
+		t.Fatalf("principal %v %v", principal, err)
+	}
+	if principal, err := authenticate(a, "X-Api-Key", "k2"); err != ErrUnauthenticated || principal != nil {
+		t.Fatalf("invalid key %v %v", principal, err)
+	}
+	if principal, err := authenticate(a, "Authorization", "Bearer "+signJWT(t, "HS256", map[string]interface{}{"sub": "bob"}, testSecret)); err != nil || principal.Subject != "bob" {
+		t.Fatalf("second authenticator %v %v", principal, err)
+	}
+	if principal, err := authenticate(a, "", ""); err != nil || principal != nil {
+		t.Fatalf("without credentials %v %v", principal, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	db := openTestDB(t)
+	r := mux.NewRouter()
+	var subject string
+	policy := func(ctx context.Context, op Operation, entity interface{}) error {
+		subject = PrincipalFromContext(ctx).Subject
+		return nil
+	}
+	keys := map[string]*Principal{"k1": {Subject: "alice", Claims: map[string]interface{}{"org": 1}}}
+	MapMux(r, db).AuthenticateWith(APIKey("X-Api-Key", keys)).
+		NewMap("/note", Note{}, []Note{}).Authorize(policy).Full()
+
+	w := serve(r, "GET", "/note", "")
+	expectCode(t, w, http.StatusUnauthorized)
+	if w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("headers %v", w.Header())
+	}
+	expectCode(t, serve(r, "GET", "/note", "", "X-Api-Key", "bad"), http.StatusUnauthorized)
+	expectCode(t, serve(r, "GET", "/note", "", "X-Api-Key", "k1"), http.StatusOK)
+	if subject != "alice" {
+		t.Fatalf("principal of the policy %q", subject)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := TenantFromClaim("org")(req); err != ErrTenantNotFound {
+		t.Fatalf("tenant without principal %v", err)
+	}
+	tenant, err := TenantFromClaim("org")(req.WithContext(WithPrincipal(req.Context(), keys["k1"])))
+	if err != nil || tenant != "1" {
+		t.Fatalf("tenant %v %v", tenant, err)
+	}
+}
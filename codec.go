@@ -0,0 +1,427 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The handlers write json and the codec of the header Accept of the request
+// encode the response (application/json, application/xml, application/yaml,
+// application/msgpack and application/x-ndjson are registered, see
+// RegisterCodec). The responses
+// of other formats (HAL, OData, the events, the CSV) are written as they
+// are. The body of Save is decoded with the codec of the header Content-Type.
+// The codecs encode and decode the values of json: nil, bool, json.Number,
+// string, []interface{} and Object.
+
+// Codec encode and decode the bodies of one media type
+type Codec interface {
+	// MediaType return the media type of the codec, like application/xml
+	MediaType() string
+	// Encode write the value
+	Encode(w io.Writer, v interface{}) error
+	// Decode read one value
+	Decode(r io.Reader) (interface{}, error)
+}
+
+var codecs struct {
+	sync.RWMutex
+	list []Codec
+}
+
+// RegisterCodec add the codec, it replace the codec of the same media type
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	for i, c := range codecs.list {
+		if c.MediaType() == codec.MediaType() {
+			codecs.list[i] = codec
+			return
+		}
+	}
+	codecs.list = append(codecs.list, codec)
+}
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(xmlCodec{})
+	RegisterCodec(yamlCodec{})
+	RegisterCodec(msgpackCodec{})
+	RegisterCodec(ndjsonCodec{})
+}
+
+// codecFor return the codec of the media type
+func codecFor(mediaType string) Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	for _, c := range codecs.list {
+		if c.MediaType() == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+// isJSONType return true for application/json and the types +json
+func isJSONType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// mediaTypeOf return the media type of the header Content-Type
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
+
+// acceptRange is one media range of the header Accept, the types +json are
+// application/json
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// match return the specificity of the range for the media type (2 for the
+// type, 1 for type/* and 0 for */*), -1 if it does not match
+func (ar acceptRange) match(mediaType string) int {
+	switch {
+	case ar.mediaType == mediaType:
+		return 2
+	case ar.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(ar.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(ar.mediaType, "*")):
+		return 1
+	}
+	return -1
+}
+
+// acceptQuality return the q of the media type, the specificity and the
+// position of its range. The most specific range that matches gives the q
+// (RFC 7231 5.3.2) and the first one wins between ranges of the same
+// specificity. The q is 0 if no range matches.
+func acceptQuality(ranges []acceptRange, mediaType string) (float64, int, int) {
+	best, q, pos := -1, 0.0, len(ranges)
+	for i, ar := range ranges {
+		if specificity := ar.match(mediaType); specificity > best {
+			best, q, pos = specificity, ar.q, i
+		}
+	}
+	return q, best, pos
+}
+
+// acceptCodec return the codec for the header Accept. The codecs, json
+// included, are ranked by q, then by the specificity of their ranges and then
+// by the order of the header. The json codec wins the ties, so it is chosen
+// when only */* or application/* match. It returns false if no codec is
+// acceptable.
+func acceptCodec(accept string) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonCodec{}, true
+	}
+	var ranges []acceptRange
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if isJSONType(mediaType) {
+			mediaType = jsonCodec{}.MediaType()
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	var best Codec
+	bestQ, bestSpecificity, bestPos := 0.0, 0, 0
+	for _, c := range codecs.list {
+		q, specificity, pos := acceptQuality(ranges, c.MediaType())
+		if q > bestQ || (q > 0 && q == bestQ && (specificity > bestSpecificity ||
+			(specificity == bestSpecificity && pos < bestPos))) {
+			best, bestQ, bestSpecificity, bestPos = c, q, specificity, pos
+		}
+	}
+	return best, best != nil
+}
+
+// Negotiate encode the json responses of f with the codec of the header
+// Accept and answer 406 if no codec is acceptable and 415 if the body has a
+// type without codec
+func Negotiate(f func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Add("Vary", "Accept")
+		if contentType := r.Header.Get("Content-Type"); contentType != "" && r.Body != nil && r.ContentLength != 0 {
+			if mediaType := mediaTypeOf(contentType); !isJSONType(mediaType) && codecFor(mediaType) == nil {
+				w.Header().Set("Content-Type", "application/json")
+				WriteError(w, ErrorCrud{Message: "Unsupported Media Type " + mediaType, Code: http.StatusUnsupportedMediaType})
+				return
+			}
+		}
+		codec, ok := acceptCodec(r.Header.Get("Accept"))
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			WriteError(w, ErrorCrud{Message: "Not Acceptable", Code: http.StatusNotAcceptable})
+			return
+		}
+		if _, isJSON := codec.(jsonCodec); isJSON {
+			f(w, r, id)
+			return
+		}
+		cw := &codecWriter{ResponseWriter: w, codec: codec, status: http.StatusOK}
+		f(cw, r, id)
+		cw.close()
+	}
+}
+
+// codecWriter keep the json written by the handler and encode it with the
+// codec at the end, the other types are written directly
+type codecWriter struct {
+	http.ResponseWriter
+	codec     Codec
+	status    int
+	decided   bool
+	transcode bool
+	body      bytes.Buffer
+}
+
+// decide choose to transcode the response on the first write
+func (cw *codecWriter) decide() {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	cw.transcode = isJSONType(mediaTypeOf(cw.Header().Get("Content-Type")))
+	if cw.transcode {
+		cw.Header().Set("Content-Type", cw.codec.MediaType())
+		cw.Header().Del("Content-Length")
+	}
+}
+
+func (cw *codecWriter) WriteHeader(status int) {
+	cw.decide()
+	if cw.transcode {
+		cw.status = status
+		return
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *codecWriter) Write(b []byte) (int, error) {
+	cw.decide()
+	if cw.transcode {
+		return cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// close encode the json kept with the codec
+func (cw *codecWriter) close() {
+	if !cw.transcode {
+		return
+	}
+	if cw.body.Len() == 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+		return
+	}
+	value, err := jsonCodec{}.Decode(&cw.body)
+	if err != nil {
+		cw.Header().Set("Content-Type", "application/json")
+		WriteError(cw.ResponseWriter, err)
+		return
+	}
+	var out bytes.Buffer
+	if err := cw.codec.Encode(&out, value); err != nil {
+		cw.Header().Set("Content-Type", "application/json")
+		WriteError(cw.ResponseWriter, err)
+		return
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	out.WriteTo(cw.ResponseWriter)
+}
+
+// decodeBody decode the body of the request in the entity with the codec of
+// the header Content-Type, json if it has not one
+func decodeBody(r *http.Request, entity interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	mediaType := mediaTypeOf(r.Header.Get("Content-Type"))
+	codec := codecFor(mediaType)
+	if mediaType == "" || isJSONType(mediaType) || codec == nil {
+		return json.Unmarshal(body, entity)
+	}
+	value, err := codec.Decode(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(conform(value, reflect.TypeOf(entity)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, entity)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// conform convert the texts of the value to the numbers and the booleans of
+// the fields of t, the codecs like XML have only texts
+func conform(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return value
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(Object)
+		if value == "" {
+			return Object{}
+		}
+		if !ok {
+			return value
+		}
+		types := map[string]reflect.Type{}
+		jsonTypes(t, types)
+		out := make(Object, len(obj))
+		for i, f := range obj {
+			out[i] = f
+			if ft, ok := types[f.Name]; ok {
+				out[i].Value = conform(f.Value, ft)
+			}
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if value == "" && t.Elem().Kind() != reflect.Uint8 {
+			return []interface{}{}
+		}
+		if !ok || t.Elem().Kind() == reflect.Uint8 {
+			return value
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			out[i] = conform(item, t.Elem())
+		}
+		return out
+	case reflect.Bool:
+		if s, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+			if s == "" {
+				return nil
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if s, ok := value.(string); ok {
+			if s == "" {
+				return nil
+			}
+			if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+				return json.Number(s)
+			}
+		}
+	}
+	return value
+}
+
+// jsonTypes return the types of the fields of the struct by the name in json
+func jsonTypes(t reflect.Type, types map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && baseType(f.Type).Kind() == reflect.Struct && f.Type.Kind() != reflect.Slice {
+			jsonTypes(baseType(f.Type), types)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		types[name] = f.Type
+	}
+}
+
+// jsonCodec is the codec of application/json
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader) (interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return decodeJSONValue(dec)
+}
+
+// decodeJSONValue read one value keeping the order of the objects
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := Object{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, Field{Name: key.(string), Value: value})
+			}
+			_, err := dec.Token()
+			return obj, err
+		case '[':
+			items := []interface{}{}
+			for dec.More() {
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, value)
+			}
+			_, err := dec.Token()
+			return items, err
+		}
+		return nil, fmt.Errorf("invalid delimiter %v", t)
+	}
+	return token, nil
+}
@@ -0,0 +1,277 @@
+package gormcrud
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// The MessagePack of the values use the smallest format of each value: the
+// numbers without fraction are integers and the others are float 64. The
+// decoder read all the formats except the extensions, the binaries are
+// strings.
+
+// msgpackCodec is the codec of application/msgpack
+type msgpackCodec struct{}
+
+func (msgpackCodec) MediaType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	out := bufio.NewWriter(w)
+	if err := encodeMsgpack(out, v); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// msgpackHeader write the byte of the format and the length in n bytes
+func msgpackHeader(w *bufio.Writer, format byte, length uint64, n int) {
+	w.WriteByte(format)
+	for i := n - 1; i >= 0; i-- {
+		w.WriteByte(byte(length >> (8 * uint(i))))
+	}
+}
+
+// msgpackLength write the header of one string, array or map with the fix
+// format (fix is the first byte and max its maximum length) or the formats of
+// 8 (only strings), 16 and 32 bits
+func msgpackLength(w *bufio.Writer, length int, fix byte, max int, format8 byte, format16 byte, format32 byte) {
+	switch {
+	case length <= max:
+		w.WriteByte(fix | byte(length))
+	case format8 != 0 && length <= math.MaxUint8:
+		msgpackHeader(w, format8, uint64(length), 1)
+	case length <= math.MaxUint16:
+		msgpackHeader(w, format16, uint64(length), 2)
+	default:
+		msgpackHeader(w, format32, uint64(length), 4)
+	}
+}
+
+func encodeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if value {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			encodeMsgpackInt(w, i)
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			msgpackHeader(w, 0xcf, u, 8)
+			return nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		msgpackHeader(w, 0xcb, math.Float64bits(f), 8)
+	case string:
+		msgpackLength(w, len(value), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		w.WriteString(value)
+	case []interface{}:
+		msgpackLength(w, len(value), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := encodeMsgpack(w, item); err != nil {
+				return err
+			}
+		}
+	case Object:
+		msgpackLength(w, len(value), 0x80, 15, 0, 0xde, 0xdf)
+		for _, f := range value {
+			encodeMsgpack(w, f.Name)
+			if err := encodeMsgpack(w, f.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported value %T", v)
+	}
+	return nil
+}
+
+// encodeMsgpackInt write the integer with the smallest format
+func encodeMsgpackInt(w *bufio.Writer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		w.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		msgpackHeader(w, 0xcc, uint64(i), 1)
+	case i >= 0 && i <= math.MaxUint16:
+		msgpackHeader(w, 0xcd, uint64(i), 2)
+	case i >= 0 && i <= math.MaxUint32:
+		msgpackHeader(w, 0xce, uint64(i), 4)
+	case i >= 0:
+		msgpackHeader(w, 0xcf, uint64(i), 8)
+	case i >= math.MinInt8:
+		msgpackHeader(w, 0xd0, uint64(i), 1)
+	case i >= math.MinInt16:
+		msgpackHeader(w, 0xd1, uint64(i), 2)
+	case i >= math.MinInt32:
+		msgpackHeader(w, 0xd2, uint64(i), 4)
+	default:
+		msgpackHeader(w, 0xd3, uint64(i), 8)
+	}
+}
+
+func (msgpackCodec) Decode(r io.Reader) (interface{}, error) {
+	return decodeMsgpack(bufio.NewReader(r))
+}
+
+// msgpackUint read the unsigned integer of n bytes
+func msgpackUint(r *bufio.Reader, n int) (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b[8-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	format, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case format <= 0x7f:
+		return json.Number(strconv.Itoa(int(format))), nil
+	case format >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(format)))), nil
+	case format >= 0x80 && format <= 0x8f:
+		return decodeMsgpackMap(r, int(format&0x0f))
+	case format >= 0x90 && format <= 0x9f:
+		return decodeMsgpackArray(r, int(format&0x0f))
+	case format >= 0xa0 && format <= 0xbf:
+		return decodeMsgpackString(r, int(format&0x1f))
+	}
+	switch format {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		sizes := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}
+		n, err := msgpackUint(r, sizes[format])
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackString(r, int(n))
+	case 0xca:
+		bits, err := msgpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(float64(math.Float32frombits(uint32(bits))))
+	case 0xcb:
+		bits, err := msgpackUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(math.Float64frombits(bits))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := msgpackUint(r, 1<<uint(format-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << uint(format-0xd0)
+		u, err := msgpackUint(r, n)
+		if err != nil {
+			return nil, err
+		}
+		// extend the sign of the n bytes
+		shift := uint(64 - 8*n)
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xdc, 0xdd:
+		n, err := msgpackUint(r, 2<<uint(format-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := msgpackUint(r, 2<<uint(format-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", format)
+}
+
+// msgpackFloat return the number of the float, json has not NaN and infinity
+func msgpackFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("msgpack: unsupported number %v", f)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func decodeMsgpackString(r *bufio.Reader, n int) (interface{}, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && len(b) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// msgpackCap return the capacity for n values, the length in the header is
+// not trusted
+func msgpackCap(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n int) (interface{}, error) {
+	items := make([]interface{}, 0, msgpackCap(n))
+	for i := 0; i < n; i++ {
+		item, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n int) (interface{}, error) {
+	obj := make(Object, 0, msgpackCap(n))
+	for i := 0; i < n; i++ {
+		key, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			name = fmt.Sprint(key)
+		}
+		obj = append(obj, Field{Name: name, Value: value})
+	}
+	return obj, nil
+}
@@ -0,0 +1,167 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// msgpackHex return the MessagePack of the value in hex
+func msgpackHex(t *testing.T, v interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := (msgpackCodec{}).Encode(&buf, v); err != nil {
+		t.Fatalf("encode %v: %v", v, err)
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestMsgpackEncode(t *testing.T) {
+	for data, want := range map[string]string{
+		`null`:                 "c0",
+		`true`:                 "c3",
+		`false`:                "c2",
+		`0`:                    "00",
+		`127`:                  "7f",
+		`128`:                  "cc80",
+		`255`:                  "ccff",
+		`256`:                  "cd0100",
+		`65535`:                "cdffff",
+		`65536`:                "ce00010000",
+		`4294967295`:           "ceffffffff",
+		`4294967296`:           "cf0000000100000000",
+		`18446744073709551615`: "cfffffffffffffffff",
+		`-1`:                   "ff",
+		`-32`:                  "e0",
+		`-33`:                  "d0df",
+		`-128`:                 "d080",
+		`-129`:                 "d1ff7f",
+		`-32768`:               "d18000",
+		`-32769`:               "d2ffff7fff",
+		`-2147483649`:          "d3ffffffff7fffffff",
+		`1.5`:                  "cb3ff8000000000000",
+		`""`:                   "a0",
+		`"abc"`:                "a3616263",
+		`[]`:                   "90",
+		`[1,"a"]`:              "9201a161",
+		`{}`:                   "80",
+		`{"a":1}`:              "81a16101",
+	} {
+		if got := msgpackHex(t, jsonValue(t, data)); got != want {
+			t.Errorf("%s: %s, want %s", data, got, want)
+		}
+	}
+
+	// the lengths of the strings, the arrays and the maps
+	for _, c := range []struct {
+		value  interface{}
+		header string
+	}{
+		{strings.Repeat("a", 31), "bf"},
+		{strings.Repeat("a", 32), "d920"},
+		{strings.Repeat("a", 255), "d9ff"},
+		{strings.Repeat("a", 256), "da0100"},
+		{strings.Repeat("a", 65536), "db00010000"},
+		{make([]interface{}, 15), "9f"},
+		{make([]interface{}, 16), "dc0010"},
+		{make([]interface{}, 65536), "dd00010000"},
+		{make(Object, 15), "8f"},
+		{make(Object, 16), "de0010"},
+		{make(Object, 65536), "df00010000"},
+	} {
+		if got := msgpackHex(t, c.value); !strings.HasPrefix(got, c.header) {
+			t.Errorf("%T of %d: %s", c.value, reflect.ValueOf(c.value).Len(), got[:12])
+		}
+	}
+	for _, v := range []interface{}{1, json.Number("x"), []interface{}{int64(1)}, Object{{Name: "a", Value: 1.5}}} {
+		if err := (msgpackCodec{}).Encode(&bytes.Buffer{}, v); err == nil {
+			t.Errorf("%#v is not a value of json", v)
+		}
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		`{"id":1,"rate":-1.5,"ok":false,"deleted_at":null,"title":"a ñ 日本"}`,
+		`{"tags":[{"id":1,"name":"x"},{"id":2,"name":"y"}],"empty":[],"none":{}}`,
+		`[0,127,128,255,256,65535,65536,4294967295,4294967296,9223372036854775807,18446744073709551615]`,
+		`[-1,-32,-33,-128,-129,-32768,-32769,-2147483648,-2147483649,-9223372036854775808]`,
+		`[0.5,-0.25,123.456,1e-07]`,
+		`{"nested":{"deep":[[1,[2]],{"a":null}]}}`,
+		`"scalar"`,
+		`null`,
+	} {
+		if got := roundTrip(t, msgpackCodec{}, jsonValue(t, data)); !reflect.DeepEqual(got, jsonValue(t, data)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s", data, out)
+		}
+	}
+
+	long := Object{
+		{Name: "s", Value: strings.Repeat("x", 70000)},
+		{Name: "a", Value: make([]interface{}, 70000)},
+	}
+	if got := roundTrip(t, msgpackCodec{}, long); !reflect.DeepEqual(got, long) {
+		t.Errorf("long values")
+	}
+	if got := roundTrip(t, msgpackCodec{}, jsonValue(t, `1e300`)); got != json.Number("1e+300") {
+		t.Errorf("float %v", got)
+	}
+}
+
+func TestMsgpackDecode(t *testing.T) {
+	for data, want := range map[string]string{
+		// the formats not written by the encoder
+		"ca3fc00000":       `1.5`,
+		"c403616263":       `"abc"`,
+		"c50003616263":     `"abc"`,
+		"c600000003616263": `"abc"`,
+		"da0003616263":     `"abc"`,
+		"d0ff":             `-1`,
+		"d1fffe":           `-2`,
+		"d2fffffffd":       `-3`,
+		"dc000201a161":     `[1,"a"]`,
+		"dd0000000101":     `[1]`,
+		"df00000001a161c0": `{"a":null}`,
+		"de000101c3":       `{"1":true}`,
+		"cc05":             `5`,
+	} {
+		b, _ := hex.DecodeString(data)
+		got, err := msgpackCodec{}.Decode(bytes.NewReader(b))
+		if err != nil || !reflect.DeepEqual(got, jsonValue(t, want)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s %v", data, out, err)
+		}
+	}
+}
+
+func TestMsgpackMalformed(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"c1",
+		"d4000000",
+		"c7010000",
+		"a36162",
+		"d9",
+		"d905616263",
+		"cd01",
+		"cb3ff8",
+		"92",
+		"9201",
+		"81a161",
+		"ddffffffff",
+		"dfffffffff",
+		"dbffffffff61",
+		"cb7ff8000000000000",
+		"cb7ff0000000000000",
+		"ca7f800000",
+	} {
+		b, _ := hex.DecodeString(data)
+		if value, err := (msgpackCodec{}).Decode(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: %v", data, value)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package gormcrud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// The NDJSON of the values has one json value for each line: the items of
+// the lists are the lines and the other values are one line. The lists of
+// All are encoded after the query, the export of .ndjson (see NDJSON) reads
+// the rows one by one. The decoder returns the list of the lines, the empty
+// lines are skipped.
+
+// ndjsonCodec is the codec of application/x-ndjson
+type ndjsonCodec struct{}
+
+func (ndjsonCodec) MediaType() string {
+	return ndjsonMediaType
+}
+
+func (ndjsonCodec) Encode(w io.Writer, v interface{}) error {
+	items, ok := v.([]interface{})
+	if !ok {
+		items = []interface{}{v}
+	}
+	out := bufio.NewWriter(w)
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		out.Write(data)
+		out.WriteByte('\n')
+	}
+	return out.Flush()
+}
+
+func (ndjsonCodec) Decode(r io.Reader) (interface{}, error) {
+	items := []interface{}{}
+	in := bufio.NewScanner(r)
+	in.Buffer(nil, 1<<26)
+	for n := 1; in.Scan(); n++ {
+		line := bytes.TrimSpace(in.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		value, err := decodeJSONValue(dec)
+		if err == nil {
+			if _, errEnd := dec.Token(); errEnd != io.EOF {
+				err = fmt.Errorf("more than one value")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ndjson: line %d: %v", n, err)
+		}
+		items = append(items, value)
+	}
+	return items, in.Err()
+}
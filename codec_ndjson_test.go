@@ -0,0 +1,68 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONCodec(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (ndjsonCodec{}).Encode(&buf, jsonValue(t, `[{"id":1,"tags":[{"id":2}]},{"id":2},[1],"a",null]`)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\"id\":1,\"tags\":[{\"id\":2}]}\n{\"id\":2}\n[1]\n\"a\"\nnull\n" {
+		t.Fatalf("ndjson %q", buf.String())
+	}
+	buf.Reset()
+	(ndjsonCodec{}).Encode(&buf, jsonValue(t, `{"message":"m","code":404}`))
+	if buf.String() != "{\"message\":\"m\",\"code\":404}\n" {
+		t.Fatalf("object %q", buf.String())
+	}
+	buf.Reset()
+	if (ndjsonCodec{}).Encode(&buf, jsonValue(t, `[]`)); buf.Len() != 0 {
+		t.Fatalf("empty list %q", buf.String())
+	}
+
+	for _, data := range []string{`[{"id":1,"a":[1,{"b":null}]},{"id":2}]`, `[1,"a",true,null]`, `[]`} {
+		if got := roundTrip(t, ndjsonCodec{}, jsonValue(t, data)); !reflect.DeepEqual(got, jsonValue(t, data)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s", data, out)
+		}
+	}
+	got, err := ndjsonCodec{}.Decode(strings.NewReader("\n{\"id\":1}\r\n  \n{\"id\":2}"))
+	if err != nil || !reflect.DeepEqual(got, jsonValue(t, `[{"id":1},{"id":2}]`)) {
+		t.Fatalf("lines %v %v", got, err)
+	}
+	for _, data := range []string{"{\"id\":1}\n{\"id\":", "{\"id\":1} {\"id\":2}\n", "x\n", "{\"id\":1}}\n"} {
+		if value, err := (ndjsonCodec{}).Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: %v", data, value)
+		}
+	}
+}
+
+func TestNDJSONAccept(t *testing.T) {
+	db, r := newNDJSONMux(t)
+	db.Create(&Note{Title: "a", Tags: []Tag{{Name: "x"}}})
+	db.Create(&Note{Title: "b"})
+
+	// the lists of All have the relations
+	w := serve(r, "GET", "/note", "", "Accept", ndjsonMediaType)
+	expectCode(t, w, http.StatusOK)
+	lines := readNDJSON(t, w.Body.String())
+	if w.Header().Get("Content-Type") != ndjsonMediaType || len(lines) != 2 || lines[1]["title"] != "b" {
+		t.Fatalf("list %s", w.Body.String())
+	}
+	if tags, _ := lines[0]["tags"].([]interface{}); len(tags) != 1 {
+		t.Fatalf("relations %v", lines[0])
+	}
+	w = serve(r, "GET", "/note/1", "", "Accept", ndjsonMediaType)
+	if lines = readNDJSON(t, w.Body.String()); len(lines) != 1 || lines[0]["title"] != "a" {
+		t.Fatalf("entity %s", w.Body.String())
+	}
+	w = serve(r, "POST", "/note", "{\"title\":\"c\"}\n", "Content-Type", ndjsonMediaType)
+	expectCode(t, w, http.StatusBadRequest)
+}
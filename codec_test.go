@@ -0,0 +1,228 @@
+package gormcrud
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// failCodec is one codec that fails to encode
+type failCodec struct{}
+
+func (failCodec) MediaType() string {
+	return "application/x-fail"
+}
+
+func (failCodec) Encode(w io.Writer, v interface{}) error {
+	w.Write([]byte("partial"))
+	return errors.New("encode fail")
+}
+
+func (failCodec) Decode(r io.Reader) (interface{}, error) {
+	return nil, errors.New("decode fail")
+}
+
+// jsonValue return the value of the json for the codecs
+func jsonValue(t *testing.T, data string) interface{} {
+	t.Helper()
+	value, err := jsonCodec{}.Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid json %v: %s", err, data)
+	}
+	return value
+}
+
+// roundTrip encode the value with the codec and decode it
+func roundTrip(t *testing.T, c Codec, v interface{}) interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, v); err != nil {
+		t.Fatalf("encode %v: %v", v, err)
+	}
+	value, err := c.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	return value
+}
+
+func TestAcceptCodec(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                  "application/json",
+		"*/*":                               "application/json",
+		"application/json":                  "application/json",
+		"application/hal+json":              "application/json",
+		"application/xml":                   "application/xml",
+		"APPLICATION/XML":                   "application/xml",
+		"application/yaml, application/xml": "application/yaml",
+		"application/yaml;q=0.5, application/msgpack":                          "application/msgpack",
+		"application/xml, */*;q=0.1":                                           "application/xml",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8":      "application/xml",
+		"application/xml;q=0.9, application/json;q=0.1":                        "application/xml",
+		"application/msgpack, application/json;q=0.5":                          "application/msgpack",
+		"application/yaml;q=1, application/json;q=0.1":                         "application/yaml",
+		"application/xml, application/json":                                    "application/xml",
+		"application/json, application/xml":                                    "application/json",
+		"application/xml;q=0.5, application/*":                                 "application/json",
+		"application/*":                                                        "application/json",
+		"text/*, */*;q=0.5":                                                    "application/json",
+		"application/json;q=0, application/xml":                                "application/xml",
+		"application/json;q=0, */*":                                            "application/xml",
+		"application/json;q=0, application/*;q=0.5, application/msgpack;q=0.5": "application/msgpack",
+		"application/json;q=0, application/msgpack;q=0.5, application/*;q=0.5": "application/msgpack",
+		"*/*;q=0, application/yaml":                                            "application/yaml",
+		"application/xml;q=x, application/yaml":                                "application/yaml",
+		"text/html":                                                            "",
+		"application/json;q=0":                                                 "",
+		"application/xml;q=0, application/json;q=0":                            "",
+		"application/xml;q=2":                                                  "",
+	} {
+		codec, ok := acceptCodec(accept)
+		got := ""
+		if ok {
+			got = codec.MediaType()
+		}
+		if got != want {
+			t.Errorf("%q: %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func newCodecMux(t *testing.T) *mux.Router {
+	db := openTestDB(t)
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/note", Note{}, []Note{}).Full()
+	db.Create(&Note{Title: "a", Words: 1, Tags: []Tag{{Name: "x"}}})
+	return r
+}
+
+func TestNegotiate(t *testing.T) {
+	r := newCodecMux(t)
+	for mediaType, want := range map[string]string{
+		"application/xml":  "<title>a</title><words>1</words><tags><item><id>1</id>",
+		"application/yaml": "title: a\nwords: 1\ntags:\n  - id: 1\n",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "<title>a</title><words>1</words>",
+		"text/html,*/*;q=0.8": `"title":"a","words":1,"tags":[{"id":1`,
+	} {
+		w := serve(r, "GET", "/note/1", "", "Accept", mediaType)
+		expectCode(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), want) || w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: %s", mediaType, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); !strings.Contains(mediaType, got) && got != "application/json" {
+			t.Errorf("%s: content type %s", mediaType, got)
+		}
+	}
+
+	w := serve(r, "GET", "/note", "", "Accept", "application/msgpack")
+	expectCode(t, w, http.StatusOK)
+	value, err := msgpackCodec{}.Decode(w.Body)
+	notes, _ := value.([]interface{})
+	if err != nil || len(notes) != 1 || w.Header().Get("Content-Type") != "application/msgpack" {
+		t.Fatalf("list in msgpack %v %v", value, err)
+	}
+	if title, _ := notes[0].(Object).Get("title"); title != "a" {
+		t.Fatalf("list in msgpack %v", value)
+	}
+
+	// the errors are transcoded too
+	w = serve(r, "GET", "/note/9", "", "Accept", "application/yaml")
+	expectCode(t, w, http.StatusNotFound)
+	if w.Body.String() != "message: Status Not Found\ncode: 404\n" {
+		t.Fatalf("error in yaml %q", w.Body.String())
+	}
+
+	w = serve(r, "GET", "/note/1", "", "Accept", "text/html")
+	expectCode(t, w, http.StatusNotAcceptable)
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("content type %v", w.Header())
+	}
+}
+
+func TestNegotiateEncodeError(t *testing.T) {
+	RegisterCodec(failCodec{})
+	r := newCodecMux(t)
+	w := serve(r, "GET", "/note/1", "", "Accept", failCodec{}.MediaType())
+	expectCode(t, w, http.StatusInternalServerError)
+	var e ErrorCrud
+	decode(t, w, &e)
+	if e.Message != "encode fail" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("error %+v %v", e, w.Header())
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	r := newCodecMux(t)
+	var buf bytes.Buffer
+	msgpackCodec{}.Encode(&buf, jsonValue(t, `{"title":"m","words":5}`))
+	for contentType, body := range map[string]string{
+		"":                                `{"title":"j","words":5}`,
+		"application/json":                `{"title":"j","words":5}`,
+		"application/json; charset=utf-8": `{"title":"j","words":5}`,
+		"application/xml":                 `<note><title>x</title><words>5</words><tags></tags></note>`,
+		"application/yaml":                "title: y\nwords: 5\n",
+		"application/msgpack":             buf.String(),
+	} {
+		w := serve(r, "POST", "/note", body, "Content-Type", contentType)
+		expectCode(t, w, http.StatusOK)
+		var ret struct {
+			Value Note `json:"Value"`
+		}
+		decode(t, w, &ret)
+		if ret.Value.Words != 5 || ret.Value.Title == "" {
+			t.Errorf("%s: %s", contentType, w.Body.String())
+		}
+	}
+
+	for contentType, body := range map[string]string{
+		"application/json":    `{"title":`,
+		"":                    `{"title":1}`,
+		"application/xml":     `<note><title>x</note>`,
+		"application/yaml":    "title: [1,\n",
+		"application/msgpack": "\x81\xa5title",
+	} {
+		w := serve(r, "POST", "/note", body, "Content-Type", contentType)
+		expectCode(t, w, http.StatusBadRequest)
+		var e ErrorCrud
+		decode(t, w, &e)
+		if !strings.HasPrefix(e.Message, "Invalid body") {
+			t.Errorf("%s: %s", contentType, w.Body.String())
+		}
+	}
+	expectCode(t, serve(r, "POST", "/note", "a", "Content-Type", "text/plain"), http.StatusUnsupportedMediaType)
+}
+
+func TestConform(t *testing.T) {
+	type conformed struct {
+		ID    uint     `json:"id"`
+		Done  bool     `json:"done"`
+		Rate  float64  `json:"rate"`
+		Names []string `json:"names"`
+		Nums  []int    `json:"nums"`
+		Inner struct {
+			N int `json:"n"`
+		} `json:"inner"`
+	}
+	value := conform(Object{
+		{Name: "id", Value: "3"},
+		{Name: "done", Value: "true"},
+		{Name: "rate", Value: "1.5"},
+		{Name: "names", Value: []interface{}{"1", "a"}},
+		{Name: "nums", Value: ""},
+		{Name: "inner", Value: Object{{Name: "n", Value: "-2"}}},
+		{Name: "other", Value: "4"},
+	}, reflect.TypeOf(&conformed{}))
+	want := jsonValue(t, `{"id":3,"done":true,"rate":1.5,"names":["1","a"],"nums":[],"inner":{"n":-2},"other":"4"}`)
+	if !reflect.DeepEqual(value, want) {
+		t.Fatalf("conform %#v", value)
+	}
+	if value := conform(Object{{Name: "id", Value: "x"}, {Name: "done", Value: ""}}, reflect.TypeOf(conformed{})); !reflect.DeepEqual(value, Object{{Name: "id", Value: "x"}, {Name: "done", Value: nil}}) {
+		t.Fatalf("conform of invalid texts %#v", value)
+	}
+}
@@ -0,0 +1,150 @@
+package gormcrud
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The XML of the values has the root element response, the fields of the
+// objects are elements, the items of the lists are elements item and null is
+// an empty element with nil="true". The names that are not valid in XML are
+// elements field with the name in the attribute name:
+//
+//	<response><id>1</id><tags><item><name>x</name></item></tags>
+//	<field name="@odata.count">2</field></response>
+//
+// The texts are decoded as strings (Save convert them to the type of the
+// fields) and an element with only elements item is a list, the empty
+// elements are empty texts.
+
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// xmlCodec is the codec of application/xml
+type xmlCodec struct{}
+
+func (xmlCodec) MediaType() string {
+	return "application/xml"
+}
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	out := bufio.NewWriter(w)
+	out.WriteString(xml.Header)
+	if err := encodeXML(out, "<response", "</response>", v); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	return out.Flush()
+}
+
+// xmlField return the start (without >) and the end of the element of the
+// field of one object
+func xmlField(name string) (string, string) {
+	if name == "item" || !xmlName.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+		var attr strings.Builder
+		xml.EscapeText(&attr, []byte(name))
+		return `<field name="` + attr.String() + `"`, "</field>"
+	}
+	return "<" + name, "</" + name + ">"
+}
+
+// encodeXML write the element of the value
+func encodeXML(w *bufio.Writer, start string, end string, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		w.WriteString(start + ` nil="true"/>`)
+	case Object:
+		w.WriteString(start + ">")
+		for _, f := range value {
+			fieldStart, fieldEnd := xmlField(f.Name)
+			if err := encodeXML(w, fieldStart, fieldEnd, f.Value); err != nil {
+				return err
+			}
+		}
+		w.WriteString(end)
+	case []interface{}:
+		w.WriteString(start + ">")
+		for _, item := range value {
+			if err := encodeXML(w, "<item", "</item>", item); err != nil {
+				return err
+			}
+		}
+		w.WriteString(end)
+	case string:
+		w.WriteString(start + ">")
+		xml.EscapeText(w, []byte(value))
+		w.WriteString(end)
+	case json.Number, bool:
+		w.WriteString(start + ">" + fmt.Sprint(value) + end)
+	default:
+		return fmt.Errorf("xml: unsupported value %T", v)
+	}
+	return nil
+}
+
+func (xmlCodec) Decode(r io.Reader) (interface{}, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			_, value, err := decodeXML(dec, start)
+			return value, err
+		}
+	}
+}
+
+// decodeXML read the element of start and return the name and the value
+func decodeXML(dec *xml.Decoder, start xml.StartElement) (string, interface{}, error) {
+	name := start.Name.Local
+	null := false
+	for _, attr := range start.Attr {
+		switch {
+		case start.Name.Local == "field" && attr.Name.Local == "name":
+			name = attr.Value
+		case attr.Name.Local == "nil" && attr.Value == "true":
+			null = true
+		}
+	}
+	var text strings.Builder
+	var children Object
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			childName, value, err := decodeXML(dec, t)
+			if err != nil {
+				return "", nil, err
+			}
+			children = append(children, Field{Name: childName, Value: value})
+		case xml.EndElement:
+			switch {
+			case null:
+				return name, nil, nil
+			case children == nil:
+				return name, text.String(), nil
+			}
+			for _, f := range children {
+				if f.Name != "item" {
+					return name, children, nil
+				}
+			}
+			items := make([]interface{}, len(children))
+			for i, f := range children {
+				items[i] = f.Value
+			}
+			return name, items, nil
+		}
+	}
+}
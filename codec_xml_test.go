@@ -0,0 +1,89 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXMLEncode(t *testing.T) {
+	var buf bytes.Buffer
+	value := jsonValue(t, `{"id":1,"ok":true,"rate":-1.5,"title":"a<b & \"c\"","deleted_at":null,`+
+		`"tags":[{"name":"x"},{"name":"y"}],"empty":[],"none":{},"@odata.count":2,"item":"i","xmlns":"n","a b":"s"}`)
+	if err := (xmlCodec{}).Encode(&buf, value); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><id>1</id><ok>true</ok><rate>-1.5</rate><title>a&lt;b &amp; &#34;c&#34;</title><deleted_at nil="true"/>` +
+		`<tags><item><name>x</name></item><item><name>y</name></item></tags><empty></empty><none></none>` +
+		`<field name="@odata.count">2</field><field name="item">i</field><field name="xmlns">n</field><field name="a b">s</field></response>` + "\n"
+	if buf.String() != want {
+		t.Fatalf("xml %s", buf.String())
+	}
+
+	buf.Reset()
+	(xmlCodec{}).Encode(&buf, jsonValue(t, `[1,"a",null]`))
+	if !strings.HasSuffix(buf.String(), `<response><item>1</item><item>a</item><item nil="true"/></response>`+"\n") {
+		t.Fatalf("list %s", buf.String())
+	}
+	if err := (xmlCodec{}).Encode(&buf, Object{{Name: "n", Value: 1}}); err == nil {
+		t.Fatal("int is not a value of json")
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	for data, want := range map[string]string{
+		// the texts are strings and the empty elements are empty texts
+		`{"id":1,"ok":false,"title":"a<b&c","deleted_at":null}`: `{"id":"1","ok":"false","title":"a<b&c","deleted_at":null}`,
+		`{"tags":[{"id":1,"name":"x"},{"id":2,"name":"y"}]}`:    `{"tags":[{"id":"1","name":"x"},{"id":"2","name":"y"}]}`,
+		`{"items":[[1,2],[]],"none":{},"empty":[]}`:             `{"items":[["1","2"],""],"none":"","empty":""}`,
+		`{"@odata.count":2,"item":"i","xml":"x","1a":"n"}`:      `{"@odata.count":"2","item":"i","xml":"x","1a":"n"}`,
+		`{"text":" spaces \n lines ","unicode":"ñ 日本"}`:         `{"text":" spaces \n lines ","unicode":"ñ 日本"}`,
+		`["a",null]`: `["a",null]`,
+		`"a"`:        `"a"`,
+	} {
+		if got := roundTrip(t, xmlCodec{}, jsonValue(t, data)); !reflect.DeepEqual(got, jsonValue(t, want)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s", data, out)
+		}
+	}
+}
+
+func TestXMLDecode(t *testing.T) {
+	for data, want := range map[string]string{
+		`<note><title>a</title></note>`:                                `{"title":"a"}`,
+		`<?xml version="1.0"?><!-- c --><note><title>a</title></note>`: `{"title":"a"}`,
+		`<note>  <title>a</title>  </note>`:                            `{"title":"a"}`,
+		`<note><title><![CDATA[<a>]]></title></note>`:                  `{"title":"<a>"}`,
+		`<note><title nil="true">a</title></note>`:                     `{"title":null}`,
+		`<note><tags><item>x</item></tags></note>`:                     `{"tags":["x"]}`,
+		`<note><item>x</item><title>a</title></note>`:                  `{"item":"x","title":"a"}`,
+		`<note><field name="a b">1</field></note>`:                     `{"a b":"1"}`,
+		`<note></note>`: `""`,
+	} {
+		got, err := xmlCodec{}.Decode(strings.NewReader(data))
+		if err != nil || !reflect.DeepEqual(got, jsonValue(t, want)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s %v", data, out, err)
+		}
+	}
+}
+
+func TestXMLMalformed(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`text`,
+		`<note>`,
+		`<note><title>a</note>`,
+		`<note><title>a</title>`,
+		`<note a="1></note>`,
+		`<note>&bad;</note>`,
+		`</note>`,
+	} {
+		if value, err := (xmlCodec{}).Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: %v", data, value)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package gormcrud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// The YAML of the values is written in block style and the strings are
+// quoted when they are not plain. The decoder read the same subset: the
+// block mappings and sequences, the scalars plain, single quoted and double
+// quoted, the comments and the flow collections written in json.
+
+var (
+	yamlPlain  = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_ ./@-]*$`)
+	yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlCodec is the codec of application/yaml
+type yamlCodec struct{}
+
+func (yamlCodec) MediaType() string {
+	return "application/yaml"
+}
+
+func (yamlCodec) Encode(w io.Writer, v interface{}) error {
+	out := bufio.NewWriter(w)
+	if err := encodeYAML(out, v, 0, ""); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// yamlString return the scalar of the string, plain if it is not read as
+// other value
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !strings.HasSuffix(s, " ") && yamlScalar(s) == s {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// yamlInline return the value written on the line of its key or its dash
+func yamlInline(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case nil:
+		return "null", true
+	case bool, json.Number:
+		return fmt.Sprint(value), true
+	case string:
+		return yamlString(value), true
+	case Object:
+		return "{}", len(value) == 0
+	case []interface{}:
+		return "[]", len(value) == 0
+	}
+	return "", false
+}
+
+// encodeYAML write the value at the indentation, the first line start with
+// first instead of the indentation
+func encodeYAML(w *bufio.Writer, v interface{}, indent int, first string) error {
+	pad := strings.Repeat(" ", indent)
+	prefix := func(i int) string {
+		if i == 0 && first != "" {
+			return first
+		}
+		return pad
+	}
+	if scalar, ok := yamlInline(v); ok {
+		w.WriteString(prefix(0) + scalar + "\n")
+		return nil
+	}
+	switch value := v.(type) {
+	case Object:
+		for i, f := range value {
+			w.WriteString(prefix(i) + yamlString(f.Name) + ":")
+			if scalar, ok := yamlInline(f.Value); ok {
+				w.WriteString(" " + scalar + "\n")
+				continue
+			}
+			w.WriteString("\n")
+			if err := encodeYAML(w, f.Value, indent+2, ""); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			if err := encodeYAML(w, item, indent+2, prefix(i)+"- "); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("yaml: unsupported value %T", v)
+	}
+	return nil
+}
+
+// yamlLine is one line with content of the document
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlParser read the lines of one document
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (yamlCodec) Decode(r io.Reader) (interface{}, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &yamlParser{}
+	for i, line := range strings.Split(string(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)), "\n") {
+		text := strings.TrimRight(yamlComment(line), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (len(text) == len(trimmed) && (text == "---" || text == "...")) {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml: tab in the indentation on line %d", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	value, err := p.node(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = fmt.Errorf("yaml: unexpected content on line %d", p.lines[p.pos].number)
+	}
+	return value, err
+}
+
+// yamlComment remove the comment of the line
+func yamlComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlKey return the key and the rest of the line of one entry of mapping
+func yamlKey(text string) (string, string, bool) {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key, err := yamlValue(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return fmt.Sprint(key), strings.TrimSpace(text[i+1:]), true
+		case quote == 0 && (c == '[' || c == '{') && i == 0:
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// node read the value of the lines at the indentation
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := yamlKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return yamlValue(line.text)
+}
+
+// nested read the value of the lines after one key or dash without value
+func (p *yamlParser) nested(indent int, sequenceAtIndent bool) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	isSequence := next.text == "-" || strings.HasPrefix(next.text, "- ")
+	if next.indent > indent || (sequenceAtIndent && next.indent == indent && isSequence) {
+		return p.node(next.indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || (line.text != "-" && !strings.HasPrefix(line.text, "- ")) {
+			if line.indent > indent {
+				return nil, fmt.Errorf("yaml: invalid indentation on line %d", line.number)
+			}
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// the content after the dash is read as a line of the next indentation
+		p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(rest), text: rest}
+		item, err := p.node(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	obj := Object{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		key, rest, ok := yamlKey(line.text)
+		if line.indent > indent || !ok {
+			return nil, fmt.Errorf("yaml: invalid mapping on line %d", line.number)
+		}
+		p.pos++
+		var value interface{}
+		var err error
+		if rest == "" {
+			value, err = p.nested(indent, true)
+		} else {
+			value, err = yamlValue(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, Field{Name: key, Value: value})
+	}
+	return obj, nil
+}
+
+// yamlValue return the value of one scalar or one flow collection in json
+func yamlValue(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		var s string
+		if err := json.Unmarshal([]byte(text), &s); err != nil {
+			return nil, fmt.Errorf("yaml: invalid string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("yaml: invalid string %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{"):
+		value, err := jsonCodec{}.Decode(strings.NewReader(text))
+		if err != nil {
+			return nil, fmt.Errorf("yaml: unsupported flow collection %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">") ||
+		strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*") || strings.HasPrefix(text, "!"):
+		return nil, fmt.Errorf("yaml: unsupported %s", text)
+	}
+	return yamlScalar(text), nil
+}
+
+// yamlScalar return the value of the plain scalar
+func yamlScalar(text string) interface{} {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlNumber.MatchString(text) {
+		return json.Number(text)
+	}
+	return text
+}
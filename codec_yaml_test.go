@@ -0,0 +1,116 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLEncode(t *testing.T) {
+	var buf bytes.Buffer
+	value := jsonValue(t, `{"id":1,"title":"a b","deleted_at":null,"ok":true,"words":"12","empty":"",`+
+		`"tags":[{"id":1,"name":"x"},{"id":2,"name":"y: z"}],"none":[],"obj":{},"matrix":[[1,2],["a"]]}`)
+	if err := (yamlCodec{}).Encode(&buf, value); err != nil {
+		t.Fatal(err)
+	}
+	want := `id: 1
+title: a b
+deleted_at: null
+ok: true
+words: "12"
+empty: ""
+tags:
+  - id: 1
+    name: x
+  - id: 2
+    name: "y: z"
+none: []
+obj: {}
+matrix:
+  - - 1
+    - 2
+  - - a
+`
+	if buf.String() != want {
+		t.Fatalf("yaml %s", buf.String())
+	}
+	if err := (yamlCodec{}).Encode(&buf, []interface{}{1}); err == nil {
+		t.Fatal("int is not a value of json")
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		`{"id":1,"rate":-1.5,"big":1e10,"ok":false,"deleted_at":null}`,
+		`{"tags":[{"id":1,"name":"x","labels":["a","b"]},{"id":2,"name":"y"}]}`,
+		`{"nested":{"deep":{"deeper":[1,[2,[3]],{"a":null}]}},"after":1}`,
+		`{"empty":"","list":[],"obj":{},"items":[[],{},"",null]}`,
+		// the strings that are read as other values or that need quotes
+		`{"s":["true","null","~","12","-1.5","1e3","a: b","#c","a #b","- x","[x]","{x}","'q'","\"d\"","|","> x","&a","*a","!t"," lead","trail ","tab\there","line\nbreak","ñ 日本","/path","a@b.c","0x1F"]}`,
+		// the keys that need quotes
+		`{"a b":1,"a: b":2,"#c":3,"12":4,"true":5,"":6,"-x":7}`,
+		`[1,"a",null,{"k":"v"}]`,
+		`"scalar"`,
+		`12`,
+		`null`,
+		`[]`,
+		`{}`,
+	} {
+		if got := roundTrip(t, yamlCodec{}, jsonValue(t, data)); !reflect.DeepEqual(got, jsonValue(t, data)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%s: %s", data, out)
+		}
+	}
+}
+
+func TestYAMLDecode(t *testing.T) {
+	for data, want := range map[string]string{
+		"title: a\nwords: 2\n":                            `{"title":"a","words":2}`,
+		"---\n# comment\ntitle: a # end\nnote: 'it''s'\n": `{"title":"a","note":"it's"}`,
+		"title: \"a\\tb # c\"\r\nok: True\r\n":            `{"title":"a\tb # c","ok":true}`,
+		"tags:\n- name: x\n- name: y\n":                   `{"tags":[{"name":"x"},{"name":"y"}]}`,
+		"tags:\n  -\n    name: x\n":                       `{"tags":[{"name":"x"}]}`,
+		"tags: [1, \"a\"]\nobj: {\"k\": null}\n":          `{"tags":[1,"a"],"obj":{"k":null}}`,
+		"a:\nb: ~\n":                                      `{"a":null,"b":null}`,
+		"- a\n- - b\n  - c\n":                             `["a",["b","c"]]`,
+		"url: http://x/y#z\n":                             `{"url":"http://x/y#z"}`,
+		"  indented: 1\n  other: 2\n...\n":                `{"indented":1,"other":2}`,
+		"# only comments\n":                               `null`,
+		"":                                                `null`,
+		"text":                                            `"text"`,
+	} {
+		got, err := yamlCodec{}.Decode(strings.NewReader(data))
+		if err != nil || !reflect.DeepEqual(got, jsonValue(t, want)) {
+			out, _ := json.Marshal(got)
+			t.Errorf("%q: %s %v", data, out, err)
+		}
+	}
+}
+
+func TestYAMLMalformed(t *testing.T) {
+	for _, data := range []string{
+		"title: a\n\tb: 1\n",
+		"title: a\n  b: 1\n",
+		"a: 1\nb\n",
+		"- a\nb: 1\n",
+		"- a\n  - b\n",
+		"title: \"a\n",
+		"title: 'a\n",
+		"title: \"a\\q\"\n",
+		"title: [1,\n",
+		"title: {a: 1}\n",
+		"title: |\n  text\n",
+		"title: >\n  text\n",
+		"title: &x a\n",
+		"title: *x\n",
+		"title: !!str a\n",
+		"a\nb\n",
+	} {
+		if value, err := (yamlCodec{}).Decode(strings.NewReader(data)); err == nil {
+			out, _ := json.Marshal(value)
+			t.Errorf("%q: %s", data, out)
+		}
+	}
+}
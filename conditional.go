@@ -0,0 +1,172 @@
+package gormcrud
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// updatedAtField is the name of the field used for Last-Modified
+const updatedAtField = "UpdatedAt"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// baseType return the struct type behind pointers and slices
+func baseType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t
+}
+
+// hasUpdatedAt return true if the entity type has a time.Time UpdatedAt field
+func hasUpdatedAt(t reflect.Type) bool {
+	t = baseType(t)
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	f, ok := t.FieldByName(updatedAtField)
+	return ok && f.Type == timeType
+}
+
+// updatedAt return the value of UpdatedAt of one entity
+func updatedAt(entity interface{}) (time.Time, bool) {
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if v.Kind() != reflect.Struct || !hasUpdatedAt(v.Type()) {
+		return time.Time{}, false
+	}
+	t := v.FieldByName(updatedAtField).Interface().(time.Time)
+	return t, !t.IsZero()
+}
+
+// makeETag return a strong entity tag for the payload
+func makeETag(payload []byte) string {
+	sum := sha1.Sum(payload)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatch evaluate the header If-None-Match (weak comparison)
+func etagMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified set ETag and Last-Modified on the response and write 304 when
+// the request validators match. It returns true if the response was written.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etag != "" && etagMatch(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if since, err := http.ParseTime(ims); err == nil {
+			notModified = !lastModified.Truncate(time.Second).After(since)
+		}
+	}
+	if notModified {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// encodeConditional encode the entity, answer 304 if the client have the same
+// version and write the body otherwise
+func encodeConditional(w http.ResponseWriter, r *http.Request, entity interface{}) {
+	encodeConditionalValue(w, r, entity, publicValue(r.Context(), entity))
+}
+
+// encodeConditionalValue encode value, the representation of the entity,
+// with the validators of the entity
+func encodeConditionalValue(w http.ResponseWriter, r *http.Request, entity interface{}, value interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(value); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorCrud{Message: err.Error(), Code: http.StatusInternalServerError})
+		return
+	}
+	lastModified, _ := updatedAt(entity)
+	if NotModified(w, r, makeETag(body.Bytes()), lastModified) {
+		return
+	}
+	w.Write(body.Bytes())
+}
+
+// listValidator return the validators of a collection derived from
+// max(updated_at), count(*) and, for the entities with soft delete,
+// max(deleted_at) because the soft delete does not change updated_at. The
+// query string is part of the etag because the same collection have different
+// representations for each page. It must be called before reading the
+// collection, so the validators are never newer than the body.
+func listValidator(db *gorm.DB, elem interface{}, r *http.Request) (string, time.Time, bool) {
+	if !hasUpdatedAt(reflect.TypeOf(elem)) {
+		return "", time.Time{}, false
+	}
+	model := reflect.New(reflect.TypeOf(elem)).Interface()
+	var maxUpdated, maxDeleted interface{}
+	var count int64
+	row := db.Model(model).Select("max(updated_at), count(*)").Row()
+	if row == nil || row.Scan(&maxUpdated, &count) != nil {
+		return "", time.Time{}, false
+	}
+	lastModified := parseDBTime(maxUpdated)
+	if isSoftDelete(reflect.TypeOf(elem)) {
+		row := db.Unscoped().Model(model).Select("max(deleted_at)").Row()
+		if row == nil || row.Scan(&maxDeleted) != nil {
+			return "", time.Time{}, false
+		}
+		if deleted := parseDBTime(maxDeleted); deleted.After(lastModified) {
+			lastModified = deleted
+		}
+	}
+	etag := makeETag([]byte(fmt.Sprintf("%v|%v|%d|%s", maxUpdated, maxDeleted, count, r.URL.RawQuery)))
+	return etag, lastModified, true
+}
+
+// parseDBTime convert the result of max(updated_at) for the known dialects
+func parseDBTime(v interface{}) time.Time {
+	var s string
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case []byte:
+		s = string(t)
+	case string:
+		s = t
+	default:
+		return time.Time{}
+	}
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04:05",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
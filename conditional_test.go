@@ -0,0 +1,102 @@
+package gormcrud
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetNotModified(t *testing.T) {
+	_, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+
+	w := serve(r, "GET", "/note/1", "")
+	expectCode(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("validators missing: %v", w.Header())
+	}
+	w = serve(r, "GET", "/note/1", "", "If-None-Match", etag)
+	expectCode(t, w, http.StatusNotModified)
+	if w.Body.Len() != 0 {
+		t.Fatalf("body of 304: %s", w.Body.String())
+	}
+
+	expectCode(t, serve(r, "POST", "/note", `{"id":1,"title":"b"}`), http.StatusOK)
+	w = serve(r, "GET", "/note/1", "", "If-None-Match", etag)
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("ETag") == etag {
+		t.Fatal("etag not changed by the update")
+	}
+}
+
+func TestListNotModified(t *testing.T) {
+	_, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+
+	for _, url := range []string{"/note", "/note.page?page=1&limit=10"} {
+		w := serve(r, "GET", url, "")
+		expectCode(t, w, http.StatusOK)
+		expectCode(t, serve(r, "GET", url, "", "If-None-Match", w.Header().Get("ETag")), http.StatusNotModified)
+	}
+	page1 := serve(r, "GET", "/note.page?page=1&limit=10", "").Header().Get("ETag")
+	page2 := serve(r, "GET", "/note.page?page=2&limit=10", "").Header().Get("ETag")
+	if page1 == page2 {
+		t.Fatal("same etag for two pages")
+	}
+}
+
+func TestListModifiedBySoftDelete(t *testing.T) {
+	db, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+	past := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Model(&Note{}).UpdateColumn("updated_at", past)
+
+	w := serve(r, "GET", "/note", "")
+	expectCode(t, w, http.StatusOK)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if lastModified != past.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified %q", lastModified)
+	}
+
+	// the soft delete does not change updated_at
+	expectCode(t, serve(r, "DELETE", "/note/1", ""), http.StatusOK)
+	w = serve(r, "GET", "/note", "", "If-Modified-Since", lastModified)
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("Last-Modified") == lastModified {
+		t.Fatal("Last-Modified not changed by the soft delete")
+	}
+	expectCode(t, serve(r, "GET", "/note", "", "If-None-Match", etag), http.StatusOK)
+}
+
+func TestETagMatch(t *testing.T) {
+	cases := []struct {
+		header string
+		match  bool
+	}{
+		{`"a"`, true},
+		{`W/"a"`, true},
+		{`"b", "a"`, true},
+		{`*`, true},
+		{`"b"`, false},
+		{`a`, false},
+	}
+	for _, c := range cases {
+		if etagMatch(c.header, `"a"`) != c.match {
+			t.Errorf("etagMatch(%q) = %v", c.header, !c.match)
+		}
+	}
+}
+
+func TestParseDBTime(t *testing.T) {
+	want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, v := range []interface{}{want, "2020-01-02 03:04:05", []byte("2020-01-02T03:04:05Z"), "2020-01-02 03:04:05+00:00"} {
+		if got := parseDBTime(v); !got.Equal(want) {
+			t.Errorf("parseDBTime(%v) = %v", v, got)
+		}
+	}
+	if !parseDBTime(nil).IsZero() || !parseDBTime("x").IsZero() {
+		t.Error("invalid time not zero")
+	}
+}
@@ -0,0 +1,68 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OpCount is the operation of the count of the entities
+const OpCount Operation = "count"
+
+// Count is operation for count the entities with the filter of the lists
+// without reading them
+func Count(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		if err := authorize(r.Context(), db, OpCount, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		filter, err := ParseFilter(r.Context(), db, entity, r.URL.Query())
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		query, err := Trashed(db, r, entity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		var count int64
+		if err := filter.Apply(ScopeTenant(query, entity), entity).Model(entity).Count(&count).Error; err != nil {
+			WriteError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int64{"count": count})
+	}
+}
+
+// Exists is operation for HEAD of one entity, it answer 200 or 404 without
+// body. The entity is read without the relations for the authorization.
+func Exists(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		db, err := requestDB(db, r)
+		if err != nil {
+			w.WriteHeader(toErrorCrud(err).Code)
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		if ScopeTenant(db, entity).Where("id = ?", id).First(entity).RowsAffected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := authorize(r.Context(), db, OpGet, entity); err != nil {
+			w.WriteHeader(toErrorCrud(err).Code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
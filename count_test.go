@@ -0,0 +1,113 @@
+package gormcrud
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// getCount return the count of the query
+func getCount(t *testing.T, h http.Handler, url string, header ...string) int64 {
+	t.Helper()
+	w := serve(h, "GET", url, "", header...)
+	expectCode(t, w, http.StatusOK)
+	var res map[string]int64
+	decode(t, w, &res)
+	return res["count"]
+}
+
+func TestCount(t *testing.T) {
+	db, r := newTestMux(t)
+	if n := getCount(t, r, "/note/count"); n != 0 {
+		t.Fatalf("count of none %d", n)
+	}
+	for _, note := range []Note{{Title: "a", Words: 1}, {Title: "b", Words: 2}, {Title: "c", Words: 3}} {
+		db.Create(&note)
+	}
+	for url, want := range map[string]int64{
+		"/note/count":               3,
+		"/note/count?title=b":       1,
+		"/note/count?words[gte]=2":  2,
+		"/note/count?title[in]=a,c": 2,
+		"/note/count?title=z":       0,
+		// the params of the lists are not filters
+		"/note/count?page=2&limit=1&sort=title": 3,
+	} {
+		if n := getCount(t, r, url); n != want {
+			t.Errorf("%s: %d, want %d", url, n, want)
+		}
+	}
+
+	db.Delete(&Note{ID: 1})
+	for url, want := range map[string]int64{
+		"/note/count":              2,
+		"/note/count?trashed=with": 3,
+		"/note/count?trashed=only": 1,
+	} {
+		if n := getCount(t, r, url); n != want {
+			t.Errorf("%s: %d, want %d", url, n, want)
+		}
+	}
+	for _, url := range []string{"/note/count?other=1", "/note/count?words[xx]=1", "/note/count?tags=1", "/note/count?trashed=x"} {
+		expectCode(t, serve(r, "GET", url, ""), http.StatusBadRequest)
+	}
+}
+
+func TestCountFieldRules(t *testing.T) {
+	db, r := newMemberMux(t)
+	db.Create(&Member{Login: "x", Password: "p", Note: "n"})
+	if n := getCount(t, r, "/member/count?note=n"); n != 1 {
+		t.Fatalf("count %d", n)
+	}
+	expectCode(t, serve(r, "GET", "/member/count?password=p", ""), http.StatusBadRequest)
+	expectCode(t, serve(withRoles(r, "guest"), "GET", "/member/count?note=n", ""), http.StatusBadRequest)
+}
+
+func TestCountTenant(t *testing.T) {
+	_, r := newAccountMux(t)
+	expectCode(t, serve(r, "GET", "/account/count", ""), http.StatusUnauthorized)
+	if n := getCount(t, r, "/account/count", "X-Tenant", "1"); n != 1 {
+		t.Fatalf("count of the tenant %d", n)
+	}
+	if n := getCount(t, r, "/account/count?name=b", "X-Tenant", "1"); n != 0 {
+		t.Fatalf("count of other tenant %d", n)
+	}
+}
+
+func TestExists(t *testing.T) {
+	db, r := newTestMux(t)
+	db.Create(&Note{Title: "a", Tags: []Tag{{Name: "x"}}})
+	db.Create(&Note{Title: "b"})
+	db.Delete(&Note{ID: 2})
+
+	for url, code := range map[string]int{
+		"/note/1": http.StatusOK,
+		"/note/2": http.StatusNotFound,
+		"/note/9": http.StatusNotFound,
+		"/note/x": http.StatusNotFound,
+	} {
+		w := serve(r, "HEAD", url, "")
+		expectCode(t, w, code)
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: body %s", url, w.Body.String())
+		}
+	}
+}
+
+func TestExistsTenantAndAuthorize(t *testing.T) {
+	_, r := newAccountMux(t)
+	expectCode(t, serve(r, "HEAD", "/account/1", ""), http.StatusUnauthorized)
+	expectCode(t, serve(r, "HEAD", "/account/1", "", "X-Tenant", "1"), http.StatusOK)
+	expectCode(t, serve(r, "HEAD", "/account/2", "", "X-Tenant", "1"), http.StatusNotFound)
+
+	_, r = newDocMux(t, nil)
+	expectCode(t, serve(r, "HEAD", "/doc/2", ""), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/doc/count", ""), http.StatusForbidden)
+	_, r = newDocMux(t, func(ctx context.Context, op Operation, entity interface{}) error {
+		if op == OpGet {
+			return ErrForbidden
+		}
+		return nil
+	})
+	expectCode(t, serve(r, "HEAD", "/doc/1", ""), http.StatusForbidden)
+}
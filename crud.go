@@ -1,10 +1,11 @@
 package gormcrud
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -22,6 +23,40 @@ type ErrorCrud struct {
 	Code    int    `json:"code"`
 }
 
+// Error return the message of error
+func (e ErrorCrud) Error() string {
+	return e.Message
+}
+
+// toErrorCrud return the error as ErrorCrud, the errors of validation that
+// are not ErrorCrud are 400 and the other errors are 500
+func toErrorCrud(err error) ErrorCrud {
+	code := http.StatusInternalServerError
+	if v, ok := err.(validationError); ok {
+		err, code = v.error, http.StatusBadRequest
+	}
+	errCrud, ok := err.(ErrorCrud)
+	if p, isPtr := err.(*ErrorCrud); isPtr && p != nil {
+		errCrud, ok = *p, true
+	}
+	if !ok {
+		errCrud = ErrorCrud{Message: err.Error(), Code: code}
+	}
+	return errCrud
+}
+
+// WriteError write the error, ErrorCrud set the status code and other errors
+// are written as 500
+func WriteError(w http.ResponseWriter, err error) {
+	errCrud := toErrorCrud(err)
+	if errCrud.Code >= 400 && errCrud.Code < 600 {
+		w.WriteHeader(errCrud.Code)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(errCrud)
+}
+
 type LinkStatusCrud struct {
 	Message     string `json:"message"`
 	Status      string `json:"status"`
@@ -40,6 +75,11 @@ type ValidateDelete interface {
 	CrudValidateDelete(db *gorm.DB) error
 }
 
+// ValidatePurge is interface for validate permanent delete (?purge=true)
+type ValidatePurge interface {
+	CrudValidatePurge(db *gorm.DB) error
+}
+
 // Save entity
 func Save(db *gorm.DB, new interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
 
@@ -48,42 +88,145 @@ func Save(db *gorm.DB, new interface{}) func(w http.ResponseWriter, r *http.Requ
 			Set("gorm:association_autoupdate", false).
 			Set("gorm:association_autocreate", false)
 		w.Header().Set("Content-Type", "application/json")
+		db1, err := requestDB(db1, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
 		entity := reflect.New(reflect.TypeOf(new)).Interface()
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		json.Unmarshal(reqBody, entity)
-		if ok, err := entity.(ValidateSave); err {
-			errValidation := ok.CrudValidateSave(db1)
-			if errValidation != nil {
-				json.NewEncoder(w).Encode(errValidation)
-				return
-			}
+		if err := decodeBody(r, entity); err != nil {
+			WriteError(w, ErrorCrud{Message: "Invalid body " + err.Error(), Code: http.StatusBadRequest})
+			return
 		}
 		fmt.Println(
 			reflect.New(reflect.TypeOf(new)))
-		ret := db1.Save(entity)
-		if ret != nil {
-			if ret.Error != nil {
-				json.NewEncoder(w).Encode(ret)
-				return
-			}
+		ret, err := saveEntity(r, db1, entity)
+		if err != nil {
+			WriteError(w, err)
+			return
 		}
+		ret.Value = publicValue(r.Context(), ret.Value)
 		json.NewEncoder(w).Encode(ret)
 	}
 }
 
+// saveEntity validate and save the entity in one transaction, it is the
+// path of Save and of the revert of revisions
+func saveEntity(r *http.Request, db *gorm.DB, entity interface{}) (*gorm.DB, error) {
+	var ret *gorm.DB
+	err := Transaction(db, func(tx *gorm.DB) error {
+		create := isCreate(tx, entity)
+		before := auditSnapshot(tx, entity)
+		if err := applyWriteRules(r.Context(), tx, entity, create); err != nil {
+			return err
+		}
+		if err := forceTenant(tx, entity); err != nil {
+			return err
+		}
+		if otherTenant(tx, entity) {
+			return ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}
+		}
+		if err := otherTenantRelations(tx, entity); err != nil {
+			return err
+		}
+		if !create {
+			// the update is authorized on the stored entity too, the body
+			// can change the fields checked by the policy
+			scope := tx.NewScope(entity)
+			stored := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+			where := scope.QuotedTableName() + "." + scope.Quote(scope.PrimaryKey()) + " = ?"
+			if ScopeTenant(tx.Unscoped(), stored).Where(where, scope.PrimaryKeyValue()).First(stored).RowsAffected == 0 {
+				return ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}
+			}
+			if err := authorize(r.Context(), tx, OpSave, stored); err != nil {
+				return err
+			}
+		}
+		if err := authorize(r.Context(), tx, OpSave, entity); err != nil {
+			return err
+		}
+		if ok, err := entity.(ValidateSave); err {
+			if errValidation := ok.CrudValidateSave(tx); errValidation != nil {
+				return validationError{errValidation}
+			}
+		}
+		if err := beforeSave(r.Context(), tx, entity, create); err != nil {
+			return err
+		}
+		ret = tx.Save(entity)
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if err := afterSave(r.Context(), tx, entity, create); err != nil {
+			return err
+		}
+		operation := "update"
+		if create {
+			operation = "create"
+		}
+		if err := writeAudit(tx, operation, entity, before); err != nil {
+			return err
+		}
+		if err := writeRevision(tx, entity); err != nil {
+			return err
+		}
+		return recordEvent(tx, operation, entity)
+	})
+	return ret, err
+}
+
 // All return all entities
 func All(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
 	return func(w http.ResponseWriter, r *http.Request, id string) {
 		db := db.Set("gorm:auto_preload", true).Set("gorm:association_autoupdate", false).Set("gorm:association_autocreate", false)
-		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if isOData(db, r) {
+			odataList(w, r, db, elem, OpAll)
+			return
+		}
 		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		filter, err := listFilter(db, r, entity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if db, err = Trashed(db, r, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		db = filter.Apply(ScopeTenant(db, entity), entity)
+		w.Header().Set("Content-Type", "application/json")
+		etag, lastModified, validated := listValidator(db, elem, r)
 		ret := db.Find(entity)
-		if ret.RowsAffected == 0 {
+		if ret.Error != nil {
+			WriteError(w, ret.Error)
+			return
+		}
+		if err := authorize(r.Context(), db, OpAll, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if validated && NotModified(w, r, etag, lastModified) {
+			return
+		}
+		if ret.RowsAffected == 0 && !isHAL(db) {
 			var a [0]interface{}
 			json.NewEncoder(w).Encode(a)
 			return
 		}
-		json.NewEncoder(w).Encode(entity)
+		if err := afterRead(r.Context(), db, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if isHAL(db) {
+			writeHALList(w, r, db, entity)
+			return
+		}
+		json.NewEncoder(w).Encode(publicValue(r.Context(), entity))
 	}
 }
 
@@ -91,10 +234,30 @@ func All(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Requ
 func Page(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
 	return func(w http.ResponseWriter, r *http.Request, id string) {
 		db := db.Set("gorm:auto_preload", true).Set("gorm:association_autoupdate", false).Set("gorm:association_autocreate", false)
-		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if isOData(db, r) {
+			odataList(w, r, db, elem, OpPage)
+			return
+		}
 		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		filter, err := listFilter(db, r, entity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if db, err = Trashed(db, r, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		db = filter.Apply(ScopeTenant(db, entity), entity)
+		w.Header().Set("Content-Type", "application/json")
 		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		etag, lastModified, validated := listValidator(db, elem, r)
 
 		ret := pagination.Paging(&pagination.Param{
 			DB:      db,
@@ -102,6 +265,22 @@ func Page(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Req
 			Limit:   limit,
 			OrderBy: []string{"id desc"},
 		}, entity)
+		if err := authorize(r.Context(), db, OpPage, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if validated && NotModified(w, r, etag, lastModified) {
+			return
+		}
+		if err := afterRead(r.Context(), db, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if isHAL(db) {
+			writeHALPage(w, r, db, entity, ret)
+			return
+		}
+		ret.Records = publicValue(r.Context(), ret.Records)
 
 		json.NewEncoder(w).Encode(ret)
 	}
@@ -114,10 +293,15 @@ func Get(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Requ
 			Set("gorm:association_autoupdate", false).
 			Set("gorm:association_autocreate", false)
 		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
 		entity := reflect.New(reflect.TypeOf(elem)).Interface()
 
 		key := id
-		ret := db.Where("id = ?", key).First(entity)
+		ret := ScopeTenant(db, entity).Where("id = ?", key).First(entity)
 		if ret != nil {
 			if ret.RowsAffected == 0 {
 				w.WriteHeader(http.StatusNotFound)
@@ -129,7 +313,25 @@ func Get(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Requ
 				return
 			}
 		}
-		json.NewEncoder(w).Encode(entity)
+		if err := authorize(r.Context(), db, OpGet, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if err := afterRead(r.Context(), db, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if isHAL(db) {
+			res, err := halEntity(db, r, entity)
+			if err != nil {
+				WriteError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", halMediaType)
+			encodeConditionalValue(w, r, entity, res)
+			return
+		}
+		encodeConditional(w, r, entity)
 	}
 }
 
@@ -139,55 +341,109 @@ func Delete(db *gorm.DB, new interface{}) func(w http.ResponseWriter, r *http.Re
 		w.Header().Set("Content-Type", "application/json")
 		entity := reflect.New(reflect.TypeOf(new)).Interface()
 		key := id
-		ret := db.Where("id = ?", key).First(entity)
-		if ret != nil {
-			if ret.RowsAffected == 0 {
-				w.WriteHeader(http.StatusNotFound)
-				_ = json.NewEncoder(w).Encode(ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound})
-				return
-			}
-			if ret.Error != nil {
-				_ = json.NewEncoder(w).Encode(ret)
-				return
-			}
+		purge := r.URL.Query().Get("purge") == "true"
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
 		}
-
-		if ok, err := entity.(ValidateDelete); err {
-			errValidation := ok.CrudValidateDelete(db)
-			if errValidation != nil {
-				_ = json.NewEncoder(w).Encode(errValidation)
-				return
-			}
+		if purge {
+			db = db.Unscoped()
 		}
-
-		db.Delete(entity)
-		_ = json.NewEncoder(w).Encode(entity)
+		if err := deleteEntity(r, db, entity, key, purge); err != nil {
+			WriteError(w, err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(publicValue(r.Context(), entity))
 		return
 	}
 }
 
+// deleteEntity read the entity with the id and delete (or purge) it in one
+// transaction, it is the path of Delete and of the other protocols
+func deleteEntity(r *http.Request, db *gorm.DB, entity interface{}, id string, purge bool) error {
+	return Transaction(db, func(tx *gorm.DB) error {
+		if err := loadEntity(tx, entity, id, "Status Not Found"); err != nil {
+			return err
+		}
+		if err := authorize(r.Context(), tx, OpDelete, entity); err != nil {
+			return err
+		}
+		if purge {
+			if ok, err := entity.(ValidatePurge); err {
+				if errValidation := ok.CrudValidatePurge(tx); errValidation != nil {
+					return validationError{errValidation}
+				}
+			}
+		} else if ok, err := entity.(ValidateDelete); err {
+			if errValidation := ok.CrudValidateDelete(tx); errValidation != nil {
+				return validationError{errValidation}
+			}
+		}
+		before := auditSnapshot(tx, entity)
+		if err := beforeDelete(r.Context(), tx, entity); err != nil {
+			return err
+		}
+		if ret := tx.Delete(entity); ret.Error != nil {
+			return ret.Error
+		}
+		if err := afterDelete(r.Context(), tx, entity); err != nil {
+			return err
+		}
+		operation := "delete"
+		if purge {
+			operation = "purge"
+		}
+		if err := writeAudit(tx, operation, entity, before); err != nil {
+			return err
+		}
+		return recordEvent(tx, operation, entity)
+	})
+}
+
 // Link is operation for link and unlink entities
 func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter, r *http.Request, id string) {
 	return func(w http.ResponseWriter, r *http.Request, id string) {
 		db := db.Set("gorm:auto_preload", true).Set("gorm:association_autoupdate", false).Set("gorm:association_autocreate", false)
 		id1 := id
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
 
-		result := make(map[string]LinkStatusCrud)
 		rootEntity := reflect.New(reflect.TypeOf(root)).Interface()
 		w.Header().Set("Content-Type", "application/json")
-		ret := db.Where("id = ?", id1).First(rootEntity)
-		if ret != nil {
-			if ret.RowsAffected == 0 {
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(ErrorCrud{Message: "Status Not Found (1)", Code: http.StatusNotFound})
-				return
-			}
-			if ret.Error != nil {
-				json.NewEncoder(w).Encode(ret)
-				return
-			}
+		result, notFound, err := linkEntity(r, db, rootEntity, id1, op, r.URL.Query())
+		if err != nil && err != errLinkRollback {
+			WriteError(w, err)
+			return
+		}
+		if notFound {
+			w.WriteHeader(http.StatusNotFound)
 		}
-		for key, values := range r.URL.Query() {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+}
+
+// linkEntity read the root entity with id1 and link or unlink the entities
+// of links (field -> ids) to it in one transaction, all the links are applied
+// or none of them. It returns the status of each link, true if one of them
+// was not found and errLinkRollback if they were rolled back.
+func linkEntity(r *http.Request, db *gorm.DB, rootEntity interface{}, id1 string, op string, links url.Values) (map[string]LinkStatusCrud, bool, error) {
+	result := make(map[string]LinkStatusCrud)
+	notFound := false
+	// all the links of the request are applied or none of them
+	err := Transaction(db, func(db *gorm.DB) error {
+		if err := loadEntity(db, rootEntity, id1, "Status Not Found (1)"); err != nil {
+			return err
+		}
+		if err := authorize(r.Context(), db, Operation(op), rootEntity); err != nil {
+			return err
+		}
+		before := auditSnapshot(db, rootEntity)
+		for key, values := range links {
 			field := key
 			for _, id2 := range values {
 				elem := reflect.ValueOf(rootEntity).Elem()
@@ -217,10 +473,10 @@ func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter,
 				}
 
 				childEntity := reflect.New(child).Interface()
-				ret = db.Where("id = ?", id2).First(childEntity)
+				ret := ScopeTenant(db, childEntity).Where("id = ?", id2).First(childEntity)
 				if ret != nil {
 					if ret.RowsAffected == 0 {
-						w.WriteHeader(http.StatusNotFound)
+						notFound = true
 						result[field+id1+"_"+id2] = LinkStatusCrud{
 							Message:     "ID:" + id1 + " -> " + id2 + "(err)(Status Not Found)",
 							Status:      "err",
@@ -250,7 +506,7 @@ func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter,
 						defer func() {
 							if r := recover(); r != nil {
 								result[field+id1+"_"+id2] = LinkStatusCrud{
-									Message:     "ID:" + id1 + " -> " + id2 + " (err) " + r.(string) + ".",
+									Message:     "ID:" + id1 + " -> " + id2 + " (err) " + fmt.Sprint(r) + ".",
 									Status:      "err",
 									Operation:   op,
 									CountBefore: countBefore,
@@ -265,7 +521,16 @@ func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter,
 							Set("gorm:association_autoupdate", true).
 							Set("gorm:association_autocreate", true)
 
-						db.Save(rootEntity)
+						if ret := db.Save(rootEntity); ret.Error != nil {
+							result[field+id1+"_"+id2] = LinkStatusCrud{
+								Message:     "ID:" + id1 + " -> " + id2 + " (err) " + ret.Error.Error() + ".",
+								Status:      "err",
+								Operation:   op,
+								CountBefore: countBefore,
+								CountAfter:  -1,
+							}
+							return
+						}
 						countAfter := association.Count()
 						result[field+id1+"_"+id2] = LinkStatusCrud{
 							Message:     "ID:" + id1 + " -> " + id2 + " (ok)",
@@ -275,7 +540,18 @@ func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter,
 							CountAfter:  countAfter,
 						}
 					} else {
-						countAfter := db.Model(rootEntity).Association(field).Delete(childEntity).Count()
+						unlinked := db.Model(rootEntity).Association(field).Delete(childEntity)
+						if unlinked.Error != nil {
+							result[field+id1+"_"+id2] = LinkStatusCrud{
+								Message:     "ID:" + id1 + " -/-> " + id2 + " (err) " + unlinked.Error.Error() + ".",
+								Status:      "err",
+								Operation:   op,
+								CountBefore: countBefore,
+								CountAfter:  -1,
+							}
+							return
+						}
+						countAfter := unlinked.Count()
 						result[field+id1+"_"+id2] = LinkStatusCrud{
 							Message:     "ID:" + id1 + " -/-> " + id2 + " (ok)",
 							Status:      "ok",
@@ -288,27 +564,66 @@ func Link(db *gorm.DB, root interface{}, op string) func(w http.ResponseWriter,
 
 			}
 		}
+		for _, status := range result {
+			if status.Status == "err" {
+				return errLinkRollback
+			}
+		}
+		if err := writeAudit(db, op, rootEntity, before); err != nil {
+			return err
+		}
+		return recordEvent(db, op, rootEntity)
+	})
+	if err == errLinkRollback {
+		for key, status := range result {
+			if status.Status == "ok" {
+				status.Status = "rollback"
+				status.Message += " (rollback)"
+				status.CountAfter = status.CountBefore
+				result[key] = status
+			}
+		}
+	}
+	return result, notFound, err
+}
 
-		json.NewEncoder(w).Encode(result)
-		return
+type MapperGormCrud struct {
+	R           *mux.Router
+	RestBase    string
+	Db          *gorm.DB
+	Entity      interface{}
+	Array       interface{}
+	Policy      Policy
+	Tenant      TenantResolver
+	Authn       Authenticator
+	Auditor     AuditSink
+	Bus         *EventBus
+	Outbox      *Outbox
+	Versioning  bool
+	Filtering   bool
+	ODataMode   bool
+	JSONAPIMode bool
+	HALMode     bool
+}
 
-	}
+type contextParams struct{}
 
+// withParams return the request with the params of the path in the context
+func withParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextParams{}, params))
 }
 
-type MapperGormCrud struct {
-	R        *mux.Router
-	RestBase string
-	Db       *gorm.DB
-	Entity   interface{}
-	Array    interface{}
+// PathParam return the param of the path of the route (mux or gin)
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(contextParams{}).(map[string]string)
+	return params[name]
 }
 
 func WrapMux(f func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
-		f(w, r, id)
+		f(w, withParams(r, vars), id)
 	}
 }
 
@@ -320,43 +635,277 @@ func MapMux(r *mux.Router, db *gorm.DB) MapperGormCrud {
 }
 
 func (g MapperGormCrud) NewMap(restBase string, entity interface{}, array interface{}) MapperGormCrud {
-	return MapperGormCrud{R: g.R, RestBase: restBase, Db: g.Db, Entity: entity, Array: array}
+	return MapperGormCrud{R: g.R, RestBase: restBase, Db: g.Db, Entity: entity, Array: array, Tenant: g.Tenant, Authn: g.Authn, Auditor: g.Auditor, Bus: g.Bus, Outbox: g.Outbox}
+}
+
+// TenantBy set the resolver of the tenant for all the resources mapped after
+func (g MapperGormCrud) TenantBy(resolver TenantResolver) MapperGormCrud {
+	g.Tenant = resolver
+	return g
+}
+
+// AuthenticateWith set the authenticator for all the resources mapped after
+func (g MapperGormCrud) AuthenticateWith(authenticator Authenticator) MapperGormCrud {
+	g.Authn = authenticator
+	return g
+}
+
+// AuditTo set the sink of the audit for all the resources mapped after
+func (g MapperGormCrud) AuditTo(sink AuditSink) MapperGormCrud {
+	g.Auditor = sink
+	return g
+}
+
+// PublishTo set the bus of the events for all the resources mapped after
+func (g MapperGormCrud) PublishTo(bus *EventBus) MapperGormCrud {
+	g.Bus = bus
+	return g
+}
+
+// OutboxTo write the events in the outbox in the transaction of the
+// operations, for all the resources mapped after. The events are then
+// published only by the outbox, add BusPublisher to its publishers to deliver
+// them to the bus of PublishTo.
+func (g MapperGormCrud) OutboxTo(outbox *Outbox) MapperGormCrud {
+	g.Outbox = outbox
+	return g
+}
+
+// wrap return the handler of mux for the operation
+func (g MapperGormCrud) wrap(f func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request) {
+	return WrapMux(Negotiate(Authenticate(g.Authn, f)))
+}
+
+// wrapRaw return the handler without the codecs, for the handlers that write
+// their formats
+func (g MapperGormCrud) wrapRaw(f func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request) {
+	return WrapMux(Authenticate(g.Authn, f))
+}
+
+// Authorize set the policy of the resource, it must be called before the
+// methods that map the operations
+func (g MapperGormCrud) Authorize(policy Policy) MapperGormCrud {
+	g.Policy = policy
+	return g
+}
+
+// Filterable enable the filter of the lists in the query string of All and
+// Page (see ParseFilter), it must be called before the methods that map the
+// operations
+func (g MapperGormCrud) Filterable() MapperGormCrud {
+	g.Filtering = true
+	return g
+}
+
+// db return the gorm.DB with the settings of the resource
+func (g MapperGormCrud) db() *gorm.DB {
+	db := withTenant(withPolicy(g.Db, g.Policy), g.Tenant)
+	db = withFilter(withVersioning(withAudit(db, g.Auditor), g.Versioning), g.Filtering)
+	if g.ODataMode {
+		db = withOData(db, g.RestBase)
+	}
+	if g.HALMode {
+		db = withHAL(db, g.RestBase)
+	}
+	return withOutbox(withBus(db, g.Bus), g.Outbox)
 }
 
 func (g MapperGormCrud) Save() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase, WrapMux(Save(g.Db, g.Entity))).Methods(http.MethodPost)
+	if g.JSONAPIMode {
+		g.R.HandleFunc(g.RestBase, WrapMux(g.jsonAPI().Save)).Methods(http.MethodPost)
+		g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(g.jsonAPI().Save)).Methods(http.MethodPatch)
+		return g
+	}
+	g.R.HandleFunc(g.RestBase, g.wrap(Save(g.db(), g.Entity))).Methods(http.MethodPost)
 	return g
 }
 
 func (g MapperGormCrud) All() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase, WrapMux(All(g.Db, g.Array))).Methods(http.MethodGet)
+	if g.JSONAPIMode {
+		g.R.HandleFunc(g.RestBase, WrapMux(g.jsonAPI().List)).Methods(http.MethodGet)
+		return g
+	}
+	g.R.HandleFunc(g.RestBase, g.wrap(All(g.db(), g.Array))).Methods(http.MethodGet)
 	return g
 }
 
 func (g MapperGormCrud) Page() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase+".page", WrapMux(Page(g.Db, g.Array))).Methods(http.MethodGet)
+	if g.JSONAPIMode {
+		g.R.HandleFunc(g.RestBase+".page", WrapMux(g.jsonAPI().List)).Methods(http.MethodGet)
+		return g
+	}
+	g.R.HandleFunc(g.RestBase+".page", g.wrap(Page(g.db(), g.Array))).Methods(http.MethodGet)
 	return g
 }
 
 func (g MapperGormCrud) Get() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(Get(g.Db, g.Entity))).Methods(http.MethodGet)
+	if g.JSONAPIMode {
+		g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(g.jsonAPI().Get)).Methods(http.MethodGet)
+		return g
+	}
+	g.R.HandleFunc(g.RestBase+"/{id}", g.wrap(Get(g.db(), g.Entity))).Methods(http.MethodGet)
 	return g
 }
 
 func (g MapperGormCrud) Delete() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(Delete(g.Db, g.Entity))).Methods(http.MethodDelete)
+	if g.JSONAPIMode {
+		g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(g.jsonAPI().Delete)).Methods(http.MethodDelete)
+		return g
+	}
+	g.R.HandleFunc(g.RestBase+"/{id}", g.wrap(Delete(g.db(), g.Entity))).Methods(http.MethodDelete)
+	return g
+}
+
+func (g MapperGormCrud) Restore() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/{id}/restore", g.wrap(Restore(g.db(), g.Entity))).Methods(http.MethodPost)
+	return g
+}
+
+func (g MapperGormCrud) History() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/{id}/history", g.wrap(History(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// Events map the change feed of server-sent events on GET /events, it needs
+// the bus of PublishTo and it must be called before Get
+func (g MapperGormCrud) Events() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/events", g.wrapRaw(Events(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// Live register the resource in the hub of the websocket endpoint, it needs
+// the bus of PublishTo
+func (g MapperGormCrud) Live(hub *LiveHub) MapperGormCrud {
+	hub.register(g.db(), g.Entity)
+	return g
+}
+
+// LiveAt map the websocket endpoint of the hub on GET path
+func (g MapperGormCrud) LiveAt(path string, hub *LiveHub) MapperGormCrud {
+	g.R.HandleFunc(path, g.wrapRaw(hub.Serve)).Methods(http.MethodGet)
+	return g
+}
+
+// Count map the count of the entities on GET /count, it must be called before Get
+func (g MapperGormCrud) Count() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/count", g.wrap(Count(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// Exists map the existence of one entity on HEAD /:id
+func (g MapperGormCrud) Exists() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/{id}", g.wrap(Exists(g.db(), g.Entity))).Methods(http.MethodHead)
+	return g
+}
+
+// Aggregate map the aggregation of the entities on GET /aggregate, it is in
+// Full and without Full it must be called before Get
+func (g MapperGormCrud) Aggregate() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+"/aggregate", g.wrap(Aggregate(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// CSV map the export of the entities on GET .csv and the import on POST .csv
+func (g MapperGormCrud) CSV() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+".csv", g.wrapRaw(ExportCSV(g.db(), g.Entity))).Methods(http.MethodGet)
+	g.R.HandleFunc(g.RestBase+".csv", g.wrapRaw(ImportCSV(g.db(), g.Entity))).Methods(http.MethodPost)
+	return g
+}
+
+// NDJSON map the streaming export of the entities on GET .ndjson, All answers
+// NDJSON with the header Accept: application/x-ndjson without it
+func (g MapperGormCrud) NDJSON() MapperGormCrud {
+	g.R.HandleFunc(g.RestBase+".ndjson", g.wrapRaw(ExportNDJSON(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// Service return the pipeline of the resource for the other protocols
+func (g MapperGormCrud) Service() *Service {
+	return &Service{db: g.db(), elem: g.Entity, authn: g.Authn}
+}
+
+// GraphQL register the resource in the schema of the GraphQL endpoint
+func (g MapperGormCrud) GraphQL(gql *GraphQL) MapperGormCrud {
+	gql.register(g.db(), g.Entity)
+	return g
+}
+
+// GraphQLAt map the GraphQL endpoint on GET and POST path
+func (g MapperGormCrud) GraphQLAt(path string, gql *GraphQL) MapperGormCrud {
+	g.R.HandleFunc(path, g.wrapRaw(gql.Serve)).Methods(http.MethodGet, http.MethodPost)
+	return g
+}
+
+// JSONRPC register the methods of the resource in the JSON-RPC endpoint
+func (g MapperGormCrud) JSONRPC(rpc *JSONRPC) MapperGormCrud {
+	rpc.register(g.Service())
+	return g
+}
+
+// JSONRPCAt map the JSON-RPC endpoint on POST path
+func (g MapperGormCrud) JSONRPCAt(path string, rpc *JSONRPC) MapperGormCrud {
+	g.R.HandleFunc(path, g.wrapRaw(rpc.Serve)).Methods(http.MethodPost)
+	return g
+}
+
+// OData answer All and Page in the OData mode when the request has the
+// system query options of OData and map the document $metadata, it must be
+// called before All and Page and before Get
+func (g MapperGormCrud) OData() MapperGormCrud {
+	g.ODataMode = true
+	g.R.HandleFunc(g.RestBase+"/$metadata", g.wrapRaw(ODataMetadata(g.db(), g.Entity))).Methods(http.MethodGet)
+	return g
+}
+
+// HAL render Get, All and Page in the hypermedia format HAL with _links and
+// _embedded, it must be called before All, Page and Get
+func (g MapperGormCrud) HAL() MapperGormCrud {
+	g.HALMode = true
+	return g
+}
+
+// JSONAPI render the resource in the format JSON:API and map the
+// relationship endpoints, that replace LinkMethod and LinkUrl. It must be
+// called before the methods that map the operations.
+func (g MapperGormCrud) JSONAPI() MapperGormCrud {
+	g.JSONAPIMode = true
+	g.R.HandleFunc(g.RestBase+"/{id}/relationships/{field}", WrapMux(g.jsonAPI().Relationship)).
+		Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+	return g
+}
+
+// jsonAPI return the format JSON:API of the resource, the service
+// authenticate the requests to write the errors of JSON:API
+func (g MapperGormCrud) jsonAPI() jsonAPI {
+	return jsonAPI{service: g.Service(), base: g.RestBase}
+}
+
+// Versioned store the revisions of the resource on each save and map the
+// revisions, it must be called before Save
+func (g MapperGormCrud) Versioned() MapperGormCrud {
+	g.Db.AutoMigrate(&Revision{})
+	g.Versioning = true
+	g.R.HandleFunc(g.RestBase+"/{id}/revisions", g.wrap(Revisions(g.db(), g.Entity))).Methods(http.MethodGet)
+	g.R.HandleFunc(g.RestBase+"/{id}/revisions/{rev}", g.wrap(GetRevision(g.db(), g.Entity))).Methods(http.MethodGet)
+	g.R.HandleFunc(g.RestBase+"/{id}/revisions/{rev}/revert", g.wrap(Revert(g.db(), g.Entity))).Methods(http.MethodPost)
 	return g
 }
 
 func (g MapperGormCrud) LinkMethod() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(Link(g.Db, g.Entity, "link"))).Methods("LINK")
-	g.R.HandleFunc(g.RestBase+"/{id}", WrapMux(Link(g.Db, g.Entity, "unlink"))).Methods("UNLINK")
+	if g.JSONAPIMode {
+		return g
+	}
+	g.R.HandleFunc(g.RestBase+"/{id}", g.wrap(Link(g.db(), g.Entity, "link"))).Methods("LINK")
+	g.R.HandleFunc(g.RestBase+"/{id}", g.wrap(Link(g.db(), g.Entity, "unlink"))).Methods("UNLINK")
 	return g
 }
 
 func (g MapperGormCrud) LinkUrl() MapperGormCrud {
-	g.R.HandleFunc(g.RestBase+"/{id}/link", WrapMux(Link(g.Db, g.Entity, "link"))).Methods(http.MethodGet)
-	g.R.HandleFunc(g.RestBase+"/{id}/unlink", WrapMux(Link(g.Db, g.Entity, "unlink"))).Methods(http.MethodGet)
+	if g.JSONAPIMode {
+		return g
+	}
+	g.R.HandleFunc(g.RestBase+"/{id}/link", g.wrap(Link(g.db(), g.Entity, "link"))).Methods(http.MethodGet)
+	g.R.HandleFunc(g.RestBase+"/{id}/unlink", g.wrap(Link(g.db(), g.Entity, "unlink"))).Methods(http.MethodGet)
 	return g
 }
 
@@ -371,23 +920,39 @@ func (g MapperGormCrud) Base() MapperGormCrud {
 
 func (g MapperGormCrud) Full() MapperGormCrud {
 	g.
+		Aggregate().
 		All().
+		Count().
 		Delete().
+		Exists().
 		Get().
+		History().
 		LinkMethod().
 		LinkUrl().
 		Page().
+		Restore().
 		Save()
 	return g
 }
 
 // MapperGinGornCrud is struct of mapper gingonic
 type MapperGinGormCrud struct {
-	R        *gin.Engine
-	RestBase string
-	Db       *gorm.DB
-	Entity   interface{}
-	Array    interface{}
+	R           *gin.Engine
+	RestBase    string
+	Db          *gorm.DB
+	Entity      interface{}
+	Array       interface{}
+	Policy      Policy
+	Tenant      TenantResolver
+	Authn       Authenticator
+	Auditor     AuditSink
+	Bus         *EventBus
+	Outbox      *Outbox
+	Versioning  bool
+	Filtering   bool
+	ODataMode   bool
+	JSONAPIMode bool
+	HALMode     bool
 }
 
 // WrapF is a helper function for wrapping http.HandlerFunc and returns a Gin middleware.
@@ -395,7 +960,11 @@ func WrapGin(f func(http.ResponseWriter, *http.Request, string)) gin.HandlerFunc
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		fmt.Println(id)
-		f(c.Writer, c.Request, id)
+		params := map[string]string{}
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+		}
+		f(c.Writer, withParams(c.Request, params), id)
 	}
 }
 
@@ -407,51 +976,289 @@ func MapGin(engine *gin.Engine, db *gorm.DB) MapperGinGormCrud {
 
 // NewMap configuration endpoint
 func (g MapperGinGormCrud) NewMap(restBase string, entity interface{}, array interface{}) MapperGinGormCrud {
-	return MapperGinGormCrud{R: g.R, RestBase: restBase, Db: g.Db, Entity: entity, Array: array}
+	return MapperGinGormCrud{R: g.R, RestBase: restBase, Db: g.Db, Entity: entity, Array: array, Tenant: g.Tenant, Authn: g.Authn, Auditor: g.Auditor, Bus: g.Bus, Outbox: g.Outbox}
+}
+
+// TenantBy set the resolver of the tenant for all the resources mapped after
+func (g MapperGinGormCrud) TenantBy(resolver TenantResolver) MapperGinGormCrud {
+	g.Tenant = resolver
+	return g
+}
+
+// AuthenticateWith set the authenticator for all the resources mapped after
+func (g MapperGinGormCrud) AuthenticateWith(authenticator Authenticator) MapperGinGormCrud {
+	g.Authn = authenticator
+	return g
+}
+
+// AuditTo set the sink of the audit for all the resources mapped after
+func (g MapperGinGormCrud) AuditTo(sink AuditSink) MapperGinGormCrud {
+	g.Auditor = sink
+	return g
+}
+
+// PublishTo set the bus of the events for all the resources mapped after
+func (g MapperGinGormCrud) PublishTo(bus *EventBus) MapperGinGormCrud {
+	g.Bus = bus
+	return g
+}
+
+// OutboxTo write the events in the outbox in the transaction of the
+// operations, for all the resources mapped after. The events are then
+// published only by the outbox, add BusPublisher to its publishers to deliver
+// them to the bus of PublishTo.
+func (g MapperGinGormCrud) OutboxTo(outbox *Outbox) MapperGinGormCrud {
+	g.Outbox = outbox
+	return g
+}
+
+// wrap return the handler of gin for the operation
+func (g MapperGinGormCrud) wrap(f func(http.ResponseWriter, *http.Request, string)) gin.HandlerFunc {
+	return WrapGin(Negotiate(Authenticate(g.Authn, f)))
+}
+
+// wrapRaw return the handler without the codecs, for the handlers that write
+// their formats
+func (g MapperGinGormCrud) wrapRaw(f func(http.ResponseWriter, *http.Request, string)) gin.HandlerFunc {
+	return WrapGin(Authenticate(g.Authn, f))
+}
+
+// Authorize set the policy of the resource, it must be called before the
+// methods that map the operations
+func (g MapperGinGormCrud) Authorize(policy Policy) MapperGinGormCrud {
+	g.Policy = policy
+	return g
+}
+
+// Filterable enable the filter of the lists in the query string of All and
+// Page (see ParseFilter), it must be called before the methods that map the
+// operations
+func (g MapperGinGormCrud) Filterable() MapperGinGormCrud {
+	g.Filtering = true
+	return g
+}
+
+// db return the gorm.DB with the settings of the resource
+func (g MapperGinGormCrud) db() *gorm.DB {
+	db := withTenant(withPolicy(g.Db, g.Policy), g.Tenant)
+	db = withFilter(withVersioning(withAudit(db, g.Auditor), g.Versioning), g.Filtering)
+	if g.ODataMode {
+		db = withOData(db, g.RestBase)
+	}
+	if g.HALMode {
+		db = withHAL(db, g.RestBase)
+	}
+	return withOutbox(withBus(db, g.Bus), g.Outbox)
 }
 
 // Save one entity
 func (g MapperGinGormCrud) Save() MapperGinGormCrud {
-	g.R.POST(g.RestBase, WrapGin(Save(g.Db, g.Entity)))
+	if g.JSONAPIMode {
+		g.R.POST(g.RestBase, WrapGin(g.jsonAPI().Save))
+		g.R.PATCH(g.RestBase+"/:id", WrapGin(g.jsonAPI().Save))
+		return g
+	}
+	g.R.POST(g.RestBase, g.wrap(Save(g.db(), g.Entity)))
 	return g
 }
 
 // Return all entities
 func (g MapperGinGormCrud) All() MapperGinGormCrud {
-	g.R.GET(g.RestBase, WrapGin(All(g.Db, g.Array)))
+	if g.JSONAPIMode {
+		g.R.GET(g.RestBase, WrapGin(g.jsonAPI().List))
+		return g
+	}
+	g.R.GET(g.RestBase, g.wrap(All(g.db(), g.Array)))
 	return g
 }
 
 // Page return page with querystring page(number page) and limit (size page) .page?pahe=1&limit=10
 func (g MapperGinGormCrud) Page() MapperGinGormCrud {
-	g.R.GET(g.RestBase+".page", WrapGin(Page(g.Db, g.Array)))
+	if g.JSONAPIMode {
+		g.R.GET(g.RestBase+".page", WrapGin(g.jsonAPI().List))
+		return g
+	}
+	g.R.GET(g.RestBase+".page", g.wrap(Page(g.db(), g.Array)))
 	return g
 }
 
 // Get return one entity for id
 func (g MapperGinGormCrud) Get() MapperGinGormCrud {
-	g.R.GET(g.RestBase+"/:id", WrapGin(Get(g.Db, g.Entity)))
+	if g.JSONAPIMode {
+		g.R.GET(g.RestBase+"/:id", WrapGin(g.jsonAPI().Get))
+		return g
+	}
+	g.R.GET(g.RestBase+"/:id", g.wrap(Get(g.db(), g.Entity)))
 	return g
 }
 
 // Delete map operation delete on method delete 
 func (g MapperGinGormCrud) Delete() MapperGinGormCrud {
-	g.R.DELETE(g.RestBase+"/:id", WrapGin(Delete(g.Db, g.Entity)))
+	if g.JSONAPIMode {
+		g.R.DELETE(g.RestBase+"/:id", WrapGin(g.jsonAPI().Delete))
+		return g
+	}
+	g.R.DELETE(g.RestBase+"/:id", g.wrap(Delete(g.db(), g.Entity)))
+
+	return g
+}
+
+// Restore map operation restore of soft deleted entity on POST /:id/restore
+func (g MapperGinGormCrud) Restore() MapperGinGormCrud {
+	g.R.POST(g.RestBase+"/:id/restore", g.wrap(Restore(g.db(), g.Entity)))
+	return g
+}
+
+// History map the audit of the entity on GET /:id/history
+func (g MapperGinGormCrud) History() MapperGinGormCrud {
+	g.R.GET(g.RestBase+"/:id/history", g.wrap(History(g.db(), g.Entity)))
+	return g
+}
+
+// Events map the change feed of server-sent events on GET /events, it needs
+// the bus of PublishTo
+func (g MapperGinGormCrud) Events() MapperGinGormCrud {
+	g.R.GET(g.RestBase+"/events", g.wrapRaw(Events(g.db(), g.Entity)))
+	return g
+}
+
+// Live register the resource in the hub of the websocket endpoint, it needs
+// the bus of PublishTo
+func (g MapperGinGormCrud) Live(hub *LiveHub) MapperGinGormCrud {
+	hub.register(g.db(), g.Entity)
+	return g
+}
+
+// LiveAt map the websocket endpoint of the hub on GET path
+func (g MapperGinGormCrud) LiveAt(path string, hub *LiveHub) MapperGinGormCrud {
+	g.R.GET(path, g.wrapRaw(hub.Serve))
+	return g
+}
+
+// Count map the count of the entities on GET /count
+func (g MapperGinGormCrud) Count() MapperGinGormCrud {
+	g.R.GET(g.RestBase+"/count", g.wrap(Count(g.db(), g.Entity)))
+	return g
+}
+
+// Exists map the existence of one entity on HEAD /:id
+func (g MapperGinGormCrud) Exists() MapperGinGormCrud {
+	g.R.HEAD(g.RestBase+"/:id", g.wrap(Exists(g.db(), g.Entity)))
+	return g
+}
+
+// Aggregate map the aggregation of the entities on GET /aggregate, it is in
+// Full
+func (g MapperGinGormCrud) Aggregate() MapperGinGormCrud {
+	g.R.GET(g.RestBase+"/aggregate", g.wrap(Aggregate(g.db(), g.Entity)))
+	return g
+}
+
+// CSV map the export of the entities on GET .csv and the import on POST .csv
+func (g MapperGinGormCrud) CSV() MapperGinGormCrud {
+	g.R.GET(g.RestBase+".csv", g.wrapRaw(ExportCSV(g.db(), g.Entity)))
+	g.R.POST(g.RestBase+".csv", g.wrapRaw(ImportCSV(g.db(), g.Entity)))
+	return g
+}
 
+// NDJSON map the streaming export of the entities on GET .ndjson, All answers
+// NDJSON with the header Accept: application/x-ndjson without it
+func (g MapperGinGormCrud) NDJSON() MapperGinGormCrud {
+	g.R.GET(g.RestBase+".ndjson", g.wrapRaw(ExportNDJSON(g.db(), g.Entity)))
+	return g
+}
+
+// Service return the pipeline of the resource for the other protocols
+func (g MapperGinGormCrud) Service() *Service {
+	return &Service{db: g.db(), elem: g.Entity, authn: g.Authn}
+}
+
+// GraphQL register the resource in the schema of the GraphQL endpoint
+func (g MapperGinGormCrud) GraphQL(gql *GraphQL) MapperGinGormCrud {
+	gql.register(g.db(), g.Entity)
+	return g
+}
+
+// GraphQLAt map the GraphQL endpoint on GET and POST path
+func (g MapperGinGormCrud) GraphQLAt(path string, gql *GraphQL) MapperGinGormCrud {
+	g.R.GET(path, g.wrapRaw(gql.Serve))
+	g.R.POST(path, g.wrapRaw(gql.Serve))
+	return g
+}
+
+// JSONRPC register the methods of the resource in the JSON-RPC endpoint
+func (g MapperGinGormCrud) JSONRPC(rpc *JSONRPC) MapperGinGormCrud {
+	rpc.register(g.Service())
+	return g
+}
+
+// JSONRPCAt map the JSON-RPC endpoint on POST path
+func (g MapperGinGormCrud) JSONRPCAt(path string, rpc *JSONRPC) MapperGinGormCrud {
+	g.R.POST(path, g.wrapRaw(rpc.Serve))
+	return g
+}
+
+// OData answer All and Page in the OData mode when the request has the
+// system query options of OData and map the document $metadata, it must be
+// called before All and Page
+func (g MapperGinGormCrud) OData() MapperGinGormCrud {
+	g.ODataMode = true
+	g.R.GET(g.RestBase+"/$metadata", g.wrapRaw(ODataMetadata(g.db(), g.Entity)))
+	return g
+}
+
+// HAL render Get, All and Page in the hypermedia format HAL with _links and
+// _embedded, it must be called before All, Page and Get
+func (g MapperGinGormCrud) HAL() MapperGinGormCrud {
+	g.HALMode = true
+	return g
+}
+
+// JSONAPI render the resource in the format JSON:API and map the
+// relationship endpoints, that replace LinkMethod and LinkUrl. It must be
+// called before the methods that map the operations.
+func (g MapperGinGormCrud) JSONAPI() MapperGinGormCrud {
+	g.JSONAPIMode = true
+	g.R.GET(g.RestBase+"/:id/relationships/:field", WrapGin(g.jsonAPI().Relationship))
+	g.R.POST(g.RestBase+"/:id/relationships/:field", WrapGin(g.jsonAPI().Relationship))
+	g.R.DELETE(g.RestBase+"/:id/relationships/:field", WrapGin(g.jsonAPI().Relationship))
+	return g
+}
+
+// jsonAPI return the format JSON:API of the resource, the service
+// authenticate the requests to write the errors of JSON:API
+func (g MapperGinGormCrud) jsonAPI() jsonAPI {
+	return jsonAPI{service: g.Service(), base: g.RestBase}
+}
+
+// Versioned store the revisions of the resource on each save and map the
+// revisions, it must be called before Save
+func (g MapperGinGormCrud) Versioned() MapperGinGormCrud {
+	g.Db.AutoMigrate(&Revision{})
+	g.Versioning = true
+	g.R.GET(g.RestBase+"/:id/revisions", g.wrap(Revisions(g.db(), g.Entity)))
+	g.R.GET(g.RestBase+"/:id/revisions/:rev", g.wrap(GetRevision(g.db(), g.Entity)))
+	g.R.POST(g.RestBase+"/:id/revisions/:rev/revert", g.wrap(Revert(g.db(), g.Entity)))
 	return g
 }
 
 // LinkMethod map operation link and unlink with indicator in method htpp LINK UNLINK
 func (g MapperGinGormCrud) LinkMethod() MapperGinGormCrud {
-	g.R.Handle("LINK", g.RestBase+"/:id/link", WrapGin(Link(g.Db, g.Entity, "link")))
-	g.R.Handle("UNLINK", g.RestBase+"/:id/unlink", WrapGin(Link(g.Db, g.Entity, "unlink")))
+	if g.JSONAPIMode {
+		return g
+	}
+	g.R.Handle("LINK", g.RestBase+"/:id/link", g.wrap(Link(g.db(), g.Entity, "link")))
+	g.R.Handle("UNLINK", g.RestBase+"/:id/unlink", g.wrap(Link(g.db(), g.Entity, "unlink")))
 	return g
 }
 
 // LinkUrl map operation link and unlink with indicator in url
 func (g MapperGinGormCrud) LinkUrl() MapperGinGormCrud {
-	g.R.GET(g.RestBase+"/:id/link", WrapGin(Link(g.Db, g.Entity, "link")))
-	g.R.GET(g.RestBase+"/:id/unlink", WrapGin(Link(g.Db, g.Entity, "unlink")))
+	if g.JSONAPIMode {
+		return g
+	}
+	g.R.GET(g.RestBase+"/:id/link", g.wrap(Link(g.db(), g.Entity, "link")))
+	g.R.GET(g.RestBase+"/:id/unlink", g.wrap(Link(g.db(), g.Entity, "unlink")))
 	return g
 }
 
@@ -468,12 +1275,17 @@ func (g MapperGinGormCrud) Base() MapperGinGormCrud {
 // Full map all apis for entity
 func (g MapperGinGormCrud) Full() MapperGinGormCrud {
 	g.
+		Aggregate().
 		All().
+		Count().
 		Delete().
+		Exists().
 		Get().
+		History().
 		LinkMethod().
 		LinkUrl().
 		Page().
+		Restore().
 		Save()
 	return g
 }
@@ -0,0 +1,227 @@
+package gormcrud
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The export in CSV has one column for each field of the entity that the
+// client can read (the names in json, without the relations), the rows are
+// read like the other exports (see export.go). The import read the same
+// format, the first line is the header, and save each line like Save (the
+// lines with id update the entity). The empty cells are the zero value.
+// The cells of the text fields that start with =, +, -, @ or ' are written
+// with one ' before, so the spreadsheets do not run them as formulas, the
+// import removes it. The numbers are written as they are.
+
+// CSVLineError is the error of one line of the import, the header is the
+// line 1
+type CSVLineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// CSVReport is the result of the import
+type CSVReport struct {
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Failed  int            `json:"failed"`
+	Errors  []CSVLineError `json:"errors"`
+}
+
+// csvColumns return the properties that are columns of the CSV
+func csvColumns(props []resourceProperty) []resourceProperty {
+	var columns []resourceProperty
+	for _, prop := range props {
+		if !prop.relation {
+			columns = append(columns, prop)
+		}
+	}
+	return columns
+}
+
+// csvCell return the text of the value in json of one cell
+func csvCell(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	if raw == nil || string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// csvFormula is the first characters of the cells escaped with '
+const csvFormula = "=+-@'"
+
+// csvEscape escape the cell of the field of type t that a spreadsheet would
+// read as formula, only the text fields are escaped
+func csvEscape(cell string, t reflect.Type) string {
+	if cell != "" && baseType(t).Kind() == reflect.String && strings.IndexByte(csvFormula, cell[0]) >= 0 {
+		return "'" + cell
+	}
+	return cell
+}
+
+// csvUnescape remove the ' of the cell of the field of type t escaped by
+// csvEscape
+func csvUnescape(cell string, t reflect.Type) string {
+	if len(cell) > 1 && baseType(t).Kind() == reflect.String && cell[0] == '\'' && strings.IndexByte(csvFormula, cell[1]) >= 0 {
+		return cell[1:]
+	}
+	return cell
+}
+
+// csvValue return the value in json of the cell for the type of the field
+func csvValue(cell string, t reflect.Type) (json.RawMessage, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if !json.Valid([]byte(cell)) {
+			return nil, fmt.Errorf("invalid value %q", cell)
+		}
+		return json.RawMessage(cell), nil
+	}
+	data, _ := json.Marshal(cell)
+	return json.RawMessage(data), nil
+}
+
+// ExportCSV is operation for download the entities in CSV
+func ExportCSV(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		e, err := newExport(r, db, elem)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		columns := csvColumns(resourceProperties(db, reflect.TypeOf(elem), Roles(r.Context())))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+e.table+`.csv"`)
+		ew := newExportWriter(w)
+		out := csv.NewWriter(ew)
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		out.Write(header)
+		err = e.each(ew, func(data json.RawMessage) error {
+			fields := map[string]json.RawMessage{}
+			json.Unmarshal(data, &fields)
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				record[i] = csvEscape(csvCell(fields[column.name]), column.typ)
+			}
+			return out.Write(record)
+		}, func() error {
+			out.Flush()
+			return out.Error()
+		})
+		if err != nil {
+			ew.fail(err)
+		}
+	}
+}
+
+// ImportCSV is operation for upload the entities in CSV, each line is saved
+// in its transaction and the errors are in the report
+func ImportCSV(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		db := db.Set("gorm:auto_preload", true).
+			Set("gorm:association_autoupdate", false).
+			Set("gorm:association_autocreate", false)
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		in := csv.NewReader(r.Body)
+		header, err := in.Read()
+		if err != nil {
+			WriteError(w, ErrorCrud{Message: "Invalid CSV " + err.Error(), Code: http.StatusBadRequest})
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for _, field := range db.NewScope(reflect.New(reflect.TypeOf(elem)).Interface()).Fields() {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			if field.IsNormal && name != "-" {
+				fields[name] = field.Struct.Type
+			}
+		}
+		for _, name := range header {
+			if _, ok := fields[name]; !ok {
+				WriteError(w, ErrorCrud{Message: "Invalid column " + name, Code: http.StatusBadRequest})
+				return
+			}
+		}
+
+		report := CSVReport{Errors: []CSVLineError{}}
+		fail := func(line int, err error) {
+			errCrud := toErrorCrud(err)
+			report.Failed++
+			report.Errors = append(report.Errors, CSVLineError{Line: line, Message: errCrud.Message, Code: errCrud.Code})
+		}
+		for line := 2; ; line++ {
+			record, err := in.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fail(line, ErrorCrud{Message: err.Error(), Code: http.StatusBadRequest})
+				if parseErr, ok := err.(*csv.ParseError); ok && parseErr.Err == csv.ErrFieldCount {
+					continue
+				}
+				break
+			}
+			object := map[string]json.RawMessage{}
+			var errValue error
+			for i, cell := range record {
+				cell = csvUnescape(cell, fields[header[i]])
+				if cell == "" {
+					continue
+				}
+				if object[header[i]], errValue = csvValue(cell, fields[header[i]]); errValue != nil {
+					break
+				}
+			}
+			if errValue != nil {
+				fail(line, ErrorCrud{Message: errValue.Error(), Code: http.StatusBadRequest})
+				continue
+			}
+			data, _ := json.Marshal(object)
+			entity := reflect.New(reflect.TypeOf(elem)).Interface()
+			if err := json.Unmarshal(data, entity); err != nil {
+				fail(line, ErrorCrud{Message: err.Error(), Code: http.StatusBadRequest})
+				continue
+			}
+			create := isCreate(db, entity)
+			if _, err := saveEntity(r, db, entity); err != nil {
+				fail(line, err)
+				continue
+			}
+			if create {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,197 @@
+package gormcrud
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// flakyValue fails to encode in json when the value is fail
+type flakyValue string
+
+func (v flakyValue) MarshalJSON() ([]byte, error) {
+	if v == "fail" {
+		return nil, errors.New("value fail")
+	}
+	return json.Marshal(string(v))
+}
+
+type Flaky struct {
+	ID    uint       `gorm:"primary_key" json:"id"`
+	Value flakyValue `json:"value"`
+}
+
+func newCSVMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{}, &Flaky{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).CSV().Full().
+		NewMap("/member", Member{}, []Member{}).CSV().Full().
+		NewMap("/flaky", Flaky{}, []Flaky{}).CSV().Full()
+	return db, r
+}
+
+// readCSV return the lines of the CSV of the response with the columns named
+// in the header
+func readCSV(t *testing.T, body string) []map[string]string {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil || len(records) == 0 {
+		t.Fatalf("invalid CSV %v: %s", err, body)
+	}
+	lines := []map[string]string{}
+	for _, record := range records[1:] {
+		line := map[string]string{}
+		for i, name := range records[0] {
+			line[name] = record[i]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestCSVExport(t *testing.T) {
+	db, r := newCSVMux(t)
+	for _, note := range []Note{{Title: "b", Words: 2}, {Title: "a", Words: -3}, {Title: "c", Words: 2}} {
+		db.Create(&note)
+	}
+
+	w := serve(r, "GET", "/note.csv", "")
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || w.Header().Get("Content-Disposition") != `attachment; filename="note.csv"` {
+		t.Fatalf("headers %v", w.Header())
+	}
+	header := strings.SplitN(w.Body.String(), "\n", 2)[0]
+	if header != "id,created_at,updated_at,deleted_at,title,words" {
+		t.Fatalf("header %q", header)
+	}
+	lines := readCSV(t, w.Body.String())
+	if len(lines) != 3 || lines[1]["id"] != "2" || lines[1]["title"] != "a" || lines[1]["words"] != "-3" || lines[0]["deleted_at"] != "" {
+		t.Fatalf("lines %v", lines)
+	}
+
+	w = serve(r, "GET", "/note.csv?words=2&sort=-title", "")
+	expectCode(t, w, http.StatusOK)
+	if lines = readCSV(t, w.Body.String()); len(lines) != 2 || lines[0]["title"] != "c" || lines[1]["title"] != "b" {
+		t.Fatalf("filter and sort %v", lines)
+	}
+	expectCode(t, serve(r, "GET", "/note.csv?other=1", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "GET", "/note.csv?sort=tags", ""), http.StatusBadRequest)
+}
+
+func TestCSVFormula(t *testing.T) {
+	db, r := newCSVMux(t)
+	titles := []string{"=1+1", "+1", "-1", "@SUM(A1)", "'=x", "a=b", "'a", ""}
+	for _, title := range titles {
+		db.Create(&Note{Title: title})
+	}
+	w := serve(r, "GET", "/note.csv", "")
+	expectCode(t, w, http.StatusOK)
+	var got []string
+	for _, line := range readCSV(t, w.Body.String()) {
+		got = append(got, line["title"])
+	}
+	want := []string{"'=1+1", "'+1", "'-1", "'@SUM(A1)", "''=x", "a=b", "''a", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("escaped cells %q", got)
+	}
+
+	// the import of the export keep the values, the numbers are not escaped
+	db.Delete(Note{})
+	w = serve(r, "POST", "/note.csv", "title,words\n"+strings.Join(want, ",-2\n")+",-2\n")
+	expectCode(t, w, http.StatusOK)
+	var notes []Note
+	db.Order("id").Find(&notes)
+	for i, note := range notes {
+		if note.Title != titles[i] || note.Words != -2 {
+			t.Fatalf("imported %+v", notes)
+		}
+	}
+	if len(notes) != len(titles) {
+		t.Fatalf("imported %+v", notes)
+	}
+}
+
+func TestCSVImport(t *testing.T) {
+	db, r := newCSVMux(t)
+	db.Create(&Note{Title: "a", Words: 1})
+
+	w := serve(r, "POST", "/note.csv", "id,title,words\n1,b,\n,c,3\n,d,x\n,e\n,\"f,g\",4\n")
+	expectCode(t, w, http.StatusOK)
+	var report CSVReport
+	decode(t, w, &report)
+	if report.Created != 2 || report.Updated != 1 || report.Failed != 2 {
+		t.Fatalf("report %+v", report)
+	}
+	if fmt.Sprint(report.Errors[0].Line, report.Errors[0].Code, report.Errors[1].Line, report.Errors[1].Code) != "4 400 5 400" {
+		t.Fatalf("errors %+v", report.Errors)
+	}
+	var notes []Note
+	db.Order("id").Find(&notes)
+	if len(notes) != 3 || notes[0].Title != "b" || notes[0].Words != 0 || notes[1].Words != 3 || notes[2].Title != "f,g" {
+		t.Fatalf("notes %+v", notes)
+	}
+
+	for _, body := range []string{"", "title,other\na,1\n", "\"title\n"} {
+		expectCode(t, serve(r, "POST", "/note.csv", body), http.StatusBadRequest)
+	}
+}
+
+func TestCSVFieldRules(t *testing.T) {
+	db, r := newCSVMux(t)
+	db.Create(&Member{Login: "x", Password: "secret", Note: "n", Name: "y"})
+
+	w := serve(r, "GET", "/member.csv", "")
+	expectCode(t, w, http.StatusOK)
+	if lines := readCSV(t, w.Body.String()); len(lines) != 1 || lines[0]["login"] != "x" || lines[0]["note"] != "n" {
+		t.Fatalf("lines %v", lines)
+	}
+	if strings.Contains(w.Body.String(), "password") {
+		t.Fatalf("writeonly column %s", w.Body.String())
+	}
+	w = serve(withRoles(r, "guest"), "GET", "/member.csv", "")
+	if lines := readCSV(t, w.Body.String()); len(lines) != 1 || strings.Contains(w.Body.String(), "note") {
+		t.Fatalf("hidden column %s", w.Body.String())
+	}
+	expectCode(t, serve(withRoles(r, "guest"), "GET", "/member.csv?note=n", ""), http.StatusBadRequest)
+}
+
+func TestCSVExportError(t *testing.T) {
+	db, r := newCSVMux(t)
+	db.Create(&Flaky{Value: "fail"})
+
+	// the error before the body is the response
+	w := serve(r, "GET", "/flaky.csv", "")
+	expectCode(t, w, http.StatusInternalServerError)
+	var e ErrorCrud
+	decode(t, w, &e)
+	if e.Message == "" || w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("error %+v %v", e, w.Header())
+	}
+
+	// the error after the first rows is in the trailer
+	db.Delete(Flaky{})
+	for i := 0; i < exportFlushRows+10; i++ {
+		value := flakyValue("ok")
+		if i == exportFlushRows+5 {
+			value = "fail"
+		}
+		db.Create(&Flaky{Value: value})
+	}
+	w = serve(r, "GET", "/flaky.csv", "")
+	expectCode(t, w, http.StatusOK)
+	if lines := readCSV(t, w.Body.String()); len(lines) != exportFlushRows {
+		t.Fatalf("%d lines before the error", len(lines))
+	}
+	if trailer := w.Result().Trailer.Get(exportErrorTrailer); trailer == "" {
+		t.Fatalf("trailer %v", w.Result().Trailer)
+	}
+}
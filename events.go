@@ -0,0 +1,155 @@
+package gormcrud
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// keys of the events in the settings of gorm.DB
+const (
+	busKey     = "gormcrud:bus"
+	pendingKey = "gormcrud:pending_events"
+)
+
+// Event is one change of one entity done by the api
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Resource  string          `json:"resource"`
+	EntityID  string          `json:"entity_id"`
+	Operation string          `json:"operation"`
+	Principal string          `json:"principal,omitempty"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Time      time.Time       `json:"time"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// EventBus deliver the events to the subscribers after the commit of the
+// transaction of the operation
+type EventBus struct {
+	mu          sync.RWMutex
+	next        int
+	subscribers map[int]func(Event)
+	retain      int
+	log         []Event
+}
+
+// NewEventBus is constructor of EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[int]func(Event){}}
+}
+
+// Subscribe add the subscriber, it is called in the goroutine of the request
+// so it must not block. It returns the function that remove the subscriber.
+func (b *EventBus) Subscribe(f func(Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = f
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Retain keep in memory the last n events published, they are returned by
+// Since for the resume of the change feeds
+func (b *EventBus) Retain(n int) *EventBus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retain = n
+	return b
+}
+
+// Since return the events retained after the event with the id. It returns
+// false if the event is not retained anymore.
+func (b *EventBus) Since(id string) ([]Event, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := len(b.log) - 1; i >= 0; i-- {
+		if b.log[i].ID == id {
+			return append([]Event{}, b.log[i+1:]...), true
+		}
+	}
+	return nil, false
+}
+
+// Publish deliver the event to all the subscribers
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	if b.retain > 0 {
+		b.log = append(b.log, e)
+		if len(b.log) > b.retain {
+			b.log = b.log[len(b.log)-b.retain:]
+		}
+	}
+	b.mu.Unlock()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, f := range b.subscribers {
+		f(e)
+	}
+}
+
+// withBus set the bus in the settings of db
+func withBus(db *gorm.DB, bus *EventBus) *gorm.DB {
+	if bus == nil {
+		return db
+	}
+	return db.Set(busKey, bus)
+}
+
+// newEventID return a random id of event
+func newEventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newEvent return the event of the operation on the entity
+func newEvent(db *gorm.DB, operation string, entity interface{}) Event {
+	scope := db.NewScope(entity)
+	data, _ := json.Marshal(publicValue(context.Background(), entity))
+	e := Event{
+		ID:        newEventID(),
+		Type:      scope.TableName() + "." + operation,
+		Resource:  scope.TableName(),
+		EntityID:  fmt.Sprint(scope.PrimaryKeyValue()),
+		Operation: operation,
+		Time:      time.Now(),
+		Data:      data,
+	}
+	if principal := PrincipalFromDB(db); principal != nil {
+		e.Principal = principal.Subject
+	}
+	if tenant, ok := db.Get(tenantKey); ok {
+		e.Tenant = fmt.Sprint(tenant)
+	}
+	return e
+}
+
+// recordEvent add the event of the operation to the transaction. With an
+// outbox the event is only written in the outbox, that publishes it (use
+// BusPublisher to deliver it to the bus), otherwise it is published in the bus
+// after the commit.
+func recordEvent(db *gorm.DB, operation string, entity interface{}) error {
+	if _, ok := db.Get(outboxKey); ok {
+		return writeOutbox(db, newEvent(db, operation, entity))
+	}
+	pending, ok := db.Get(pendingKey)
+	if !ok {
+		return nil
+	}
+	events := pending.(*[]Event)
+	*events = append(*events, newEvent(db, operation, entity))
+	return nil
+}
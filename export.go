@@ -0,0 +1,183 @@
+package gormcrud
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The exports (CSV and NDJSON) read the rows from the db one by one with the
+// filter and the param sort of the query string, they never load the whole
+// collection. Each entity runs the authorization and AfterRead of All, the
+// entities rejected are skipped. The rows are read without the preload of
+// All, so the relations are not in the exports (the lists of All in NDJSON,
+// with the header Accept, have them). The export stops when the client
+// cancel the request. The error of the export is the response when the body
+// was not sent yet, after that the rows sent are kept and the message of the
+// error is in the trailer X-Export-Error.
+
+// exportFlushRows is the number of rows written before flushing the export
+const exportFlushRows = 100
+
+// ndjsonMediaType is the media type of the export in NDJSON
+const ndjsonMediaType = "application/x-ndjson"
+
+// exportErrorTrailer is the trailer with the error of the export
+const exportErrorTrailer = "X-Export-Error"
+
+// exportWriter is the response of one export, it knows if the body was sent
+type exportWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+// newExportWriter declare the trailer of the error, it must be called
+// before writing the body
+func newExportWriter(w http.ResponseWriter) *exportWriter {
+	w.Header().Set("Trailer", exportErrorTrailer)
+	return &exportWriter{ResponseWriter: w}
+}
+
+func (w *exportWriter) Write(data []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *exportWriter) Flush() {
+	w.started = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// fail write the error of the export in the response or in the trailer
+func (w *exportWriter) fail(err error) {
+	if !w.started {
+		w.Header().Del("Trailer")
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "application/json")
+		WriteError(w.ResponseWriter, err)
+		return
+	}
+	w.Header().Set(exportErrorTrailer, toErrorCrud(err).Message)
+}
+
+// export is the query of one export
+type export struct {
+	r        *http.Request
+	db       *gorm.DB
+	entities reflect.Value
+	rows     *sql.Rows
+	table    string
+}
+
+// newExport validate the filter and the sort of the request and run the
+// query of the rows
+func newExport(r *http.Request, db *gorm.DB, elem interface{}) (*export, error) {
+	db, err := requestDB(db, r)
+	if err != nil {
+		return nil, err
+	}
+	entity := reflect.New(reflect.TypeOf(elem)).Interface()
+	filter, err := ParseFilter(r.Context(), db, entity, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	order, err := parseSort(r.Context(), db, entity, r.URL.Query().Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	entities := reflect.New(reflect.SliceOf(reflect.TypeOf(elem)))
+	if err := authorize(r.Context(), db, OpAll, entities.Interface()); err != nil {
+		return nil, err
+	}
+	scope := db.NewScope(entity)
+	if len(order) == 0 && scope.PrimaryKey() != "" {
+		order = append(order, scope.QuotedTableName()+"."+scope.Quote(scope.PrimaryKey()))
+	}
+	query, err := Trashed(db, r, entity)
+	if err != nil {
+		return nil, err
+	}
+	query = filter.Apply(ScopeTenant(query, entity), entity).Model(entity)
+	if len(order) > 0 {
+		query = query.Order(strings.Join(order, ", "))
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	return &export{r: r, db: db, entities: entities, rows: rows, table: scope.TableName()}, nil
+}
+
+// each call write with the json of each entity and flush every
+// exportFlushRows rows, flush write the buffer of the format before the
+// flush of the response
+func (e *export) each(w http.ResponseWriter, write func(data json.RawMessage) error, flush func() error) error {
+	defer e.rows.Close()
+	ctx := e.r.Context()
+	flusher, _ := w.(http.Flusher)
+	for n := 1; e.rows.Next(); n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e.entities.Elem().Set(reflect.MakeSlice(e.entities.Elem().Type(), 1, 1))
+		row := e.entities.Elem().Index(0).Addr().Interface()
+		if err := e.db.ScanRows(e.rows, row); err != nil {
+			return err
+		}
+		if authorize(ctx, e.db, OpAll, e.entities.Interface()) != nil ||
+			afterRead(ctx, e.db, e.entities.Interface()) != nil {
+			continue
+		}
+		data, err := json.Marshal(publicValue(ctx, row))
+		if err != nil {
+			return err
+		}
+		if err := write(data); err != nil {
+			return err
+		}
+		if n%exportFlushRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return e.rows.Err()
+}
+
+// ExportNDJSON is operation for download the entities in NDJSON, one json
+// object for each line, without the relations
+func ExportNDJSON(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		e, err := newExport(r, db, elem)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		ew := newExportWriter(w)
+		newline := []byte{'\n'}
+		err = e.each(ew, func(data json.RawMessage) error {
+			if _, err := ew.Write(data); err != nil {
+				return err
+			}
+			_, err := ew.Write(newline)
+			return err
+		}, func() error { return nil })
+		if err != nil {
+			ew.fail(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package gormcrud
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func newNDJSONMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{}, &Flaky{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).NDJSON().Full().
+		NewMap("/member", Member{}, []Member{}).NDJSON().Full().
+		NewMap("/flaky", Flaky{}, []Flaky{}).NDJSON().Full()
+	return db, r
+}
+
+// readNDJSON return the objects of the lines of the body
+func readNDJSON(t *testing.T, body string) []map[string]interface{} {
+	t.Helper()
+	lines := []map[string]interface{}{}
+	in := bufio.NewScanner(strings.NewReader(body))
+	for in.Scan() {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(in.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %v: %s", err, in.Text())
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestExportNDJSON(t *testing.T) {
+	db, r := newNDJSONMux(t)
+	db.Create(&Note{Title: "b", Words: 2, Tags: []Tag{{Name: "x"}}})
+	db.Create(&Note{Title: "a", Words: 1})
+	db.Create(&Note{Title: "c", Words: 2})
+
+	w := serve(r, "GET", "/note.ndjson", "")
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("Content-Type") != ndjsonMediaType || w.Header().Get("Trailer") != exportErrorTrailer {
+		t.Fatalf("headers %v", w.Header())
+	}
+	lines := readNDJSON(t, w.Body.String())
+	if len(lines) != 3 || lines[0]["title"] != "b" || lines[2]["id"] != 3.0 {
+		t.Fatalf("lines %v", lines)
+	}
+	// the rows are read without the relations
+	if tags, _ := lines[0]["tags"].([]interface{}); len(tags) != 0 {
+		t.Fatalf("relations %v", lines[0])
+	}
+
+	w = serve(r, "GET", "/note.ndjson?words=2&sort=-title", "")
+	expectCode(t, w, http.StatusOK)
+	if lines = readNDJSON(t, w.Body.String()); len(lines) != 2 || lines[0]["title"] != "c" || lines[1]["title"] != "b" {
+		t.Fatalf("filter and sort %v", lines)
+	}
+	db.Delete(&Note{ID: 3})
+	w = serve(r, "GET", "/note.ndjson?trashed=only", "")
+	if lines = readNDJSON(t, w.Body.String()); len(lines) != 1 || lines[0]["title"] != "c" {
+		t.Fatalf("trashed %v", lines)
+	}
+
+	w = serve(r, "GET", "/note.ndjson?other=1", "")
+	expectCode(t, w, http.StatusBadRequest)
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("content type of the error %v", w.Header())
+	}
+	expectCode(t, serve(r, "GET", "/note.ndjson?sort=other", ""), http.StatusBadRequest)
+}
+
+func TestExportNDJSONFieldRules(t *testing.T) {
+	db, r := newNDJSONMux(t)
+	db.Create(&Member{Login: "x", Password: "secret", Note: "n"})
+
+	lines := readNDJSON(t, serve(r, "GET", "/member.ndjson", "").Body.String())
+	if _, ok := lines[0]["password"]; ok || lines[0]["note"] != "n" {
+		t.Fatalf("writeonly field %v", lines)
+	}
+	lines = readNDJSON(t, serve(withRoles(r, "guest"), "GET", "/member.ndjson", "").Body.String())
+	if _, ok := lines[0]["note"]; ok || lines[0]["login"] != "x" {
+		t.Fatalf("hidden field %v", lines)
+	}
+}
+
+func TestExportNDJSONError(t *testing.T) {
+	db, r := newNDJSONMux(t)
+	db.Create(&Flaky{Value: "fail"})
+
+	w := serve(r, "GET", "/flaky.ndjson", "")
+	expectCode(t, w, http.StatusInternalServerError)
+	var e ErrorCrud
+	decode(t, w, &e)
+	if e.Message == "" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("error %+v %v", e, w.Header())
+	}
+
+	db.Delete(Flaky{})
+	for i := 0; i < exportFlushRows+10; i++ {
+		value := flakyValue("ok")
+		if i == exportFlushRows+5 {
+			value = "fail"
+		}
+		db.Create(&Flaky{Value: value})
+	}
+	w = serve(r, "GET", "/flaky.ndjson", "")
+	expectCode(t, w, http.StatusOK)
+	if lines := readNDJSON(t, w.Body.String()); len(lines) != exportFlushRows+5 {
+		t.Fatalf("%d lines before the error", len(lines))
+	}
+	if trailer := w.Result().Trailer.Get(exportErrorTrailer); !strings.Contains(trailer, "value fail") {
+		t.Fatalf("trailer %v", w.Result().Trailer)
+	}
+}
@@ -0,0 +1,377 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The tag crud set the permissions of the field:
+//
+//	crud:"readonly"     the client can not write the field
+//	crud:"writeonly"    the field is not in the responses
+//	crud:"hidden"       the client can not write or read the field
+//	crud:"create_only"  the client can write the field only on create
+//
+// Each permission can apply only to some roles of the request (see WithRoles)
+// with crud:"readonly:user|guest" or to all the roles except some with
+// crud:"hidden:!admin". The permissions are separated with comma.
+
+// Permissions of the tag crud
+const (
+	permReadonly   = "readonly"
+	permWriteonly  = "writeonly"
+	permHidden     = "hidden"
+	permCreateOnly = "create_only"
+)
+
+type contextRoles struct{}
+
+// WithRoles return the context with the roles used by the tag crud
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, contextRoles{}, roles)
+}
+
+// Roles return the roles of the context
+func Roles(ctx context.Context) []string {
+	roles, _ := ctx.Value(contextRoles{}).([]string)
+	return roles
+}
+
+// permission is one permission of the tag crud
+type permission struct {
+	name   string
+	roles  []string
+	except bool
+}
+
+// applies return true if the permission applies to the roles
+func (p permission) applies(roles []string) bool {
+	if len(p.roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		for _, r := range p.roles {
+			if role == r {
+				return !p.except
+			}
+		}
+	}
+	return p.except
+}
+
+// permissions is the parsed tag crud of one field
+type permissions []permission
+
+func parsePermissions(tag string) permissions {
+	var perms permissions
+	for _, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		p := permission{name: item}
+		if i := strings.Index(item, ":"); i >= 0 {
+			p.name = item[:i]
+			roles := item[i+1:]
+			if strings.HasPrefix(roles, "!") {
+				p.except = true
+				roles = roles[1:]
+			}
+			p.roles = strings.Split(roles, "|")
+		}
+		perms = append(perms, p)
+	}
+	return perms
+}
+
+func (perms permissions) has(name string, roles []string) bool {
+	for _, p := range perms {
+		if p.name == name && p.applies(roles) {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead return true if the field is in the responses
+func (perms permissions) canRead(roles []string) bool {
+	return !perms.has(permHidden, roles) && !perms.has(permWriteonly, roles)
+}
+
+// canWrite return true if the client can write the field
+func (perms permissions) canWrite(roles []string, create bool) bool {
+	if perms.has(permHidden, roles) || perms.has(permReadonly, roles) {
+		return false
+	}
+	return create || !perms.has(permCreateOnly, roles)
+}
+
+var fieldRulesCache sync.Map
+
+// hasFieldRules return true if the type or the types of its fields use the
+// tag crud
+func hasFieldRules(t reflect.Type) bool {
+	if cached, ok := fieldRulesCache.Load(t); ok {
+		return cached.(bool)
+	}
+	has := hasFieldRulesVisit(t, map[reflect.Type]bool{})
+	fieldRulesCache.Store(t, has)
+	return has
+}
+
+func hasFieldRulesVisit(t reflect.Type, visited map[reflect.Type]bool) bool {
+	t = baseType(t)
+	if t.Kind() != reflect.Struct || visited[t] {
+		return false
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if _, ok := f.Tag.Lookup("crud"); ok {
+			return true
+		}
+		if f.Type != timeType && hasFieldRulesVisit(f.Type, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyWriteRules restore the fields that the client can not write: on
+// create they are zero and on update they have the value saved in the db
+func applyWriteRules(ctx context.Context, db *gorm.DB, entity interface{}, create bool) error {
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if !hasFieldRules(v.Type()) {
+		return nil
+	}
+	var saved reflect.Value
+	if !create {
+		scope := db.NewScope(entity)
+		exist := reflect.New(v.Type())
+		ret := db.New().Unscoped().Where(scope.PrimaryKey()+" = ?", scope.PrimaryKeyValue()).First(exist.Interface())
+		if ret.Error != nil {
+			return ret.Error
+		}
+		saved = exist.Elem()
+	}
+	restoreFields(v, saved, Roles(ctx), create)
+	return nil
+}
+
+func restoreFields(v reflect.Value, saved reflect.Value, roles []string, create bool) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			var savedField reflect.Value
+			if saved.IsValid() {
+				savedField = saved.Field(i)
+			}
+			restoreFields(v.Field(i), savedField, roles, create)
+			continue
+		}
+		tag, ok := f.Tag.Lookup("crud")
+		if !ok || parsePermissions(tag).canWrite(roles, create) {
+			continue
+		}
+		if saved.IsValid() {
+			v.Field(i).Set(saved.Field(i))
+		} else {
+			v.Field(i).Set(reflect.Zero(f.Type))
+		}
+	}
+}
+
+// publicValue return the entity without the fields that the roles of the
+// context can not read
+func publicValue(ctx context.Context, entity interface{}) interface{} {
+	if entity == nil || !hasFieldRules(reflect.TypeOf(entity)) {
+		return entity
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return entity
+	}
+	out, err := filterJSON(data, reflect.ValueOf(entity), Roles(ctx))
+	if err != nil {
+		return entity
+	}
+	return json.RawMessage(out)
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// filterJSON remove from the json of v the fields that the roles can not read
+func filterJSON(data []byte, v reflect.Value, roles []string) ([]byte, error) {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return data, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type().Implements(marshalerType) || reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		return data, nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if !hasFieldRules(v.Type()) {
+			return data, nil
+		}
+		return filterObject(data, v, roles)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 || !hasFieldRules(v.Type()) {
+			return data, nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil || len(items) != v.Len() {
+			return data, err
+		}
+		var out bytes.Buffer
+		out.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			filtered, err := filterJSON(item, v.Index(i), roles)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(filtered)
+		}
+		out.WriteByte(']')
+		return out.Bytes(), nil
+	}
+	return data, nil
+}
+
+// jsonField is the field of struct for one key of json object
+type jsonField struct {
+	value reflect.Value
+	perms permissions
+}
+
+// jsonFields return the fields of the struct by the name in json
+func jsonFields(v reflect.Value, fields map[string]jsonField) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && reflect.Indirect(v.Field(i)).Kind() == reflect.Struct {
+			if embedded := reflect.Indirect(v.Field(i)); embedded.IsValid() {
+				jsonFields(embedded, fields)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = jsonField{value: v.Field(i), perms: parsePermissions(f.Tag.Get("crud"))}
+	}
+}
+
+// filterObject remove the keys of the json object keeping the order
+func filterObject(data []byte, v reflect.Value, roles []string) ([]byte, error) {
+	fields := map[string]jsonField{}
+	jsonFields(v, fields)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.WriteByte('{')
+	first := true
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if field, ok := fields[key]; ok {
+			if !field.perms.canRead(roles) {
+				continue
+			}
+			if raw, err = filterJSON(raw, field.value, roles); err != nil {
+				return nil, err
+			}
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		out.Write(name)
+		out.WriteByte(':')
+		out.Write(raw)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// resourceProperty is one field in json of the entity, the relations are
+// the associations of gorm
+type resourceProperty struct {
+	name     string
+	goName   string
+	column   string
+	typ      reflect.Type
+	key      bool
+	relation bool
+}
+
+// resourceProperties return the properties of the struct t that the roles can
+// read
+func resourceProperties(db *gorm.DB, t reflect.Type, roles []string) []resourceProperty {
+	return structProperties(db, t, func(perms permissions) bool { return perms.canRead(roles) })
+}
+
+// writableProperties return all the properties of the struct t, the entity
+// decoded is saved with the rules of the fields (see applyWriteRules)
+func writableProperties(db *gorm.DB, t reflect.Type) []resourceProperty {
+	return structProperties(db, t, func(permissions) bool { return true })
+}
+
+// structProperties return the properties of the struct t with the
+// permissions accepted by keep
+func structProperties(db *gorm.DB, t reflect.Type, keep func(permissions) bool) []resourceProperty {
+	var props []resourceProperty
+	for _, field := range db.NewScope(reflect.New(baseType(t)).Interface()).Fields() {
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsNormal && field.Relationship == nil) {
+			continue
+		}
+		if !keep(parsePermissions(field.Tag.Get("crud"))) {
+			continue
+		}
+		prop := resourceProperty{
+			name:     strings.Split(tag, ",")[0],
+			goName:   field.Name,
+			column:   field.DBName,
+			typ:      field.Struct.Type,
+			key:      field.IsPrimaryKey,
+			relation: field.Relationship != nil,
+		}
+		if prop.name == "" {
+			prop.name = field.Name
+		}
+		props = append(props, prop)
+	}
+	return props
+}
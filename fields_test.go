@@ -0,0 +1,151 @@
+package gormcrud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+type Member struct {
+	ID       uint   `gorm:"primary_key" json:"id"`
+	Login    string `json:"login" crud:"create_only"`
+	Password string `json:"password" crud:"writeonly"`
+	Role     string `json:"role" crud:"readonly:!admin"`
+	Note     string `json:"note" crud:"hidden:guest"`
+	Name     string `json:"name"`
+}
+
+// withRoles set the roles on the context of the requests
+func withRoles(h http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(WithRoles(r.Context(), roles...)))
+	})
+}
+
+func newMemberMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/member", Member{}, []Member{}).Full()
+	return db, r
+}
+
+func TestParsePermissions(t *testing.T) {
+	perms := parsePermissions("readonly:user|guest, hidden:!admin")
+	want := permissions{
+		{name: "readonly", roles: []string{"user", "guest"}},
+		{name: "hidden", roles: []string{"admin"}, except: true},
+	}
+	if !reflect.DeepEqual(perms, want) {
+		t.Fatalf("permissions %+v", perms)
+	}
+	if perms.canWrite([]string{"admin"}, true) != true || perms.canWrite([]string{"user", "admin"}, true) {
+		t.Fatal("canWrite")
+	}
+	if perms.canRead(nil) || !perms.canRead([]string{"admin"}) {
+		t.Fatal("canRead")
+	}
+	createOnly := parsePermissions("create_only")
+	if !createOnly.canWrite(nil, true) || createOnly.canWrite(nil, false) {
+		t.Fatal("create_only")
+	}
+}
+
+func TestWriteRules(t *testing.T) {
+	db, r := newMemberMux(t)
+	expectCode(t, serve(r, "POST", "/member", `{"login":"a","password":"p","role":"admin","name":"x"}`), http.StatusOK)
+	var member Member
+	db.First(&member, 1)
+	if member.Login != "a" || member.Password != "p" || member.Role != "" {
+		t.Fatalf("created %+v", member)
+	}
+
+	expectCode(t, serve(r, "POST", "/member", `{"id":1,"login":"b","password":"q","role":"admin","name":"y"}`), http.StatusOK)
+	member = Member{}
+	db.First(&member, 1)
+	if member.Login != "a" || member.Password != "q" || member.Role != "" || member.Name != "y" {
+		t.Fatalf("updated %+v", member)
+	}
+
+	admin := withRoles(r, "admin")
+	expectCode(t, serve(admin, "POST", "/member", `{"id":1,"login":"a","role":"admin"}`), http.StatusOK)
+	member = Member{}
+	db.First(&member, 1)
+	if member.Role != "admin" {
+		t.Fatalf("role of admin not written %+v", member)
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	db, r := newMemberMux(t)
+	db.Create(&Member{Login: "a", Password: "p", Note: "n"})
+
+	read := func(h http.Handler, url string) map[string]interface{} {
+		w := serve(h, "GET", url, "")
+		expectCode(t, w, http.StatusOK)
+		var fields map[string]interface{}
+		decode(t, w, &fields)
+		return fields
+	}
+	fields := read(r, "/member/1")
+	if _, ok := fields["password"]; ok {
+		t.Fatalf("writeonly field in the response %v", fields)
+	}
+	if fields["note"] != "n" {
+		t.Fatalf("note %v", fields)
+	}
+	if _, ok := read(withRoles(r, "guest"), "/member/1")["note"]; ok {
+		t.Fatal("hidden field for guest")
+	}
+
+	var list []map[string]interface{}
+	decode(t, serve(r, "GET", "/member", ""), &list)
+	if _, ok := list[0]["password"]; ok || len(list) != 1 {
+		t.Fatalf("writeonly field in the list %v", list)
+	}
+	var page struct{ Records []map[string]interface{} }
+	decode(t, serve(r, "GET", "/member.page?page=1&limit=5", ""), &page)
+	if _, ok := page.Records[0]["password"]; ok {
+		t.Fatalf("writeonly field in the page %v", page.Records)
+	}
+}
+
+func TestPublicValue(t *testing.T) {
+	type Group struct {
+		Name    string   `json:"name"`
+		Members []Member `json:"members"`
+	}
+	group := &Group{Name: "g", Members: []Member{{ID: 1, Password: "p"}}}
+	data, err := json.Marshal(publicValue(WithRoles(context.Background(), "guest"), group))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"g","members":[{"id":1,"login":"","role":"","name":""}]}`
+	if string(data) != want {
+		t.Fatalf("public value %s", data)
+	}
+	note := &Note{}
+	if publicValue(context.Background(), note) != interface{}(note) {
+		t.Fatal("entity without rules changed")
+	}
+}
+
+func TestResourceProperties(t *testing.T) {
+	db := openTestDB(t)
+	var names []string
+	for _, prop := range resourceProperties(db, reflect.TypeOf(Member{}), []string{"guest"}) {
+		names = append(names, prop.name)
+	}
+	if !reflect.DeepEqual(names, []string{"id", "login", "role", "name"}) {
+		t.Fatalf("properties %v", names)
+	}
+	for _, prop := range resourceProperties(db, reflect.TypeOf(Note{}), nil) {
+		if prop.name == "tags" && !prop.relation {
+			t.Fatal("tags is not a relation")
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The filter of the lists is in the query string with the names of the fields
+// in json: ?title=a is equal and ?id[gt]=3 use the operator in brackets. The
+// operators are eq, ne, gt, gte, lt, lte, like (with % and _) and in (values
+// separated with comma). The fields hidden by the tag crud can not be used.
+// The filter is always accepted by the exports, the count, the aggregation
+// and the change feed, and by All and Page of the resources mapped with
+// Filterable. The exports are sorted with ?sort=-updated_at,title (the
+// prefix - is the descending order).
+
+// filterKey is the key in the settings of gorm.DB of the resources with the
+// filter on All and Page (see MapperGormCrud.Filterable)
+const filterKey = "gormcrud:filter"
+
+// withFilter set the filter of All and Page in the settings of db
+func withFilter(db *gorm.DB, filtering bool) *gorm.DB {
+	if !filtering {
+		return db
+	}
+	return db.Set(filterKey, true)
+}
+
+// listFilter return the filter of the query for All and Page, it is empty if
+// the resource is not filterable
+func listFilter(db *gorm.DB, r *http.Request, entity interface{}) (Filter, error) {
+	if _, ok := db.Get(filterKey); !ok {
+		return nil, nil
+	}
+	return ParseFilter(r.Context(), db, entity, r.URL.Query())
+}
+
+// filterOps are the operators of the filter in SQL
+var filterOps = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+}
+
+// reservedParams are the params of the query string that are not filters
+var reservedParams = map[string]bool{
+	"page":    true,
+	"limit":   true,
+	"trashed": true,
+	"purge":   true,
+	"sort":    true,
+}
+
+// Condition is one condition of the filter
+type Condition struct {
+	Field  string
+	Column string
+	Op     string
+	Value  string
+}
+
+// Filter is the list of conditions of the query string, all must match
+type Filter []Condition
+
+// filterField return the column of the field in json of elem if the field
+// can be read by the roles
+func filterField(db *gorm.DB, elem interface{}, name string, roles []string) (string, bool) {
+	for _, field := range db.NewScope(elem).Fields() {
+		if !field.IsNormal {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		jsonName := strings.Split(tag, ",")[0]
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		if jsonName == name {
+			return field.DBName, parsePermissions(field.Tag.Get("crud")).canRead(roles)
+		}
+	}
+	return "", false
+}
+
+// ParseFilter return the filter of the query for the entities of elem. The
+// params that are not reserved must be fields that the roles can read, with
+// a known operator, otherwise the error is 400.
+func ParseFilter(ctx context.Context, db *gorm.DB, elem interface{}, query url.Values) (Filter, error) {
+	var filter Filter
+	roles := Roles(ctx)
+	for key, values := range query {
+		name, op := key, "eq"
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:i], key[i+1:len(key)-1]
+		}
+		if reservedParams[name] {
+			continue
+		}
+		column, ok := filterField(db, elem, name, roles)
+		if !ok {
+			return nil, ErrorCrud{Message: "Invalid filter " + key, Code: http.StatusBadRequest}
+		}
+		if _, ok := filterOps[op]; !ok {
+			return nil, ErrorCrud{Message: "Invalid filter " + key, Code: http.StatusBadRequest}
+		}
+		for _, value := range values {
+			filter = append(filter, Condition{Field: name, Column: column, Op: op, Value: value})
+		}
+	}
+	return filter, nil
+}
+
+// Apply add the conditions of the filter to the query of entity
+func (filter Filter) Apply(db *gorm.DB, entity interface{}) *gorm.DB {
+	scope := db.NewScope(entity)
+	for _, c := range filter {
+		column := scope.QuotedTableName() + "." + scope.Quote(c.Column)
+		if c.Op == "in" {
+			db = db.Where(column+" IN (?)", strings.Split(c.Value, ","))
+			continue
+		}
+		db = db.Where(column+" "+filterOps[c.Op]+" ?", c.Value)
+	}
+	return db
+}
+
+// parseSort return the order of the param sort for the entities of elem
+func parseSort(ctx context.Context, db *gorm.DB, elem interface{}, sort string) ([]string, error) {
+	var order []string
+	scope := db.NewScope(elem)
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		direction := "asc"
+		if strings.HasPrefix(name, "-") {
+			name, direction = name[1:], "desc"
+		}
+		column, ok := filterField(db, elem, name, Roles(ctx))
+		if !ok {
+			return nil, ErrorCrud{Message: "Invalid sort " + name, Code: http.StatusBadRequest}
+		}
+		order = append(order, scope.QuotedTableName()+"."+scope.Quote(column)+" "+direction)
+	}
+	return order, nil
+}
+
+// Match return true if the json object match all the conditions
+func (filter Filter) Match(data json.RawMessage) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	fields := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&fields) != nil {
+		return false
+	}
+	for _, c := range filter {
+		if !c.match(fields[c.Field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// match compare the value in json with the condition, the numbers as numbers
+// and the other values as strings
+func (c Condition) match(value interface{}) bool {
+	s := "null"
+	if value != nil {
+		s = fmt.Sprint(value)
+	}
+	switch c.Op {
+	case "in":
+		for _, v := range strings.Split(c.Value, ",") {
+			if compareValue(value, s, v) == 0 {
+				return true
+			}
+		}
+		return false
+	case "like":
+		pattern := regexp.QuoteMeta(c.Value)
+		pattern = strings.Replace(pattern, "%", ".*", -1)
+		pattern = strings.Replace(pattern, "_", ".", -1)
+		matched, _ := regexp.MatchString("(?is)^"+pattern+"$", s)
+		return matched
+	}
+	cmp := compareValue(value, s, c.Value)
+	switch c.Op {
+	case "ne":
+		return cmp != 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+func compareValue(value interface{}, s string, v string) int {
+	if n, ok := value.(json.Number); ok {
+		a, errA := n.Float64()
+		b, errB := strconv.ParseFloat(v, 64)
+		if errA == nil && errB == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	if b, ok := value.(bool); ok {
+		if parsed, err := strconv.ParseBool(v); err == nil && parsed == b {
+			return 0
+		}
+		return strings.Compare(s, v)
+	}
+	return strings.Compare(s, v)
+}
@@ -0,0 +1,139 @@
+package gormcrud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFilterOptIn(t *testing.T) {
+	db := openTestDB(t)
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).Full().
+		NewMap("/tag", Tag{}, []Tag{}).Filterable().Full()
+	db.Create(&Note{Title: "a"})
+	db.Create(&Note{Title: "b"})
+	db.Create(&Tag{Name: "a"})
+	db.Create(&Tag{Name: "b"})
+
+	var notes []Note
+	decode(t, serve(r, "GET", "/note?title=a&other=1", ""), &notes)
+	if len(notes) != 2 {
+		t.Fatalf("filter of the resource not filterable %+v", notes)
+	}
+	var tags []Tag
+	decode(t, serve(r, "GET", "/tag?name=a", ""), &tags)
+	if len(tags) != 1 || tags[0].Name != "a" {
+		t.Fatalf("tags %+v", tags)
+	}
+	var page struct{ Records []Tag }
+	decode(t, serve(r, "GET", "/tag.page?page=1&limit=5&name[ne]=a", ""), &page)
+	if len(page.Records) != 1 || page.Records[0].Name != "b" {
+		t.Fatalf("page %+v", page.Records)
+	}
+	expectCode(t, serve(r, "GET", "/tag?other=1", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "GET", "/tag.page?page=1&other=1", ""), http.StatusBadRequest)
+}
+
+func TestParseFilter(t *testing.T) {
+	db := openTestDB(t, &Member{})
+	for _, valid := range []string{"title=a", "words[gte]=3&id[in]=1,2", "title[like]=a%25&trashed=with&sort=-id"} {
+		query, _ := url.ParseQuery(valid)
+		if _, err := ParseFilter(context.Background(), db, &Note{}, query); err != nil {
+			t.Errorf("%s: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"other=1", "title[near]=a", "tags=1", "other[eq]=1"} {
+		query, _ := url.ParseQuery(invalid)
+		_, err := ParseFilter(context.Background(), db, &Note{}, query)
+		if errCrud, ok := err.(ErrorCrud); !ok || errCrud.Code != http.StatusBadRequest {
+			t.Errorf("%s: %v", invalid, err)
+		}
+	}
+	// the fields hidden for the roles are not filters
+	query := url.Values{"note": {"n"}}
+	if _, err := ParseFilter(WithRoles(context.Background(), "guest"), db, &Member{}, query); err == nil {
+		t.Fatal("filter of hidden field")
+	}
+	if _, err := ParseFilter(context.Background(), db, &Member{}, url.Values{"password": {"p"}}); err == nil {
+		t.Fatal("filter of writeonly field")
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	db := openTestDB(t)
+	for i, title := range []string{"apple", "banana", "avocado"} {
+		db.Create(&Note{Title: title, Words: i + 1})
+	}
+	cases := map[string][]string{
+		"title=banana":                 {"banana"},
+		"title[like]=a%25":             {"apple", "avocado"},
+		"words[gt]=1":                  {"banana", "avocado"},
+		"words[lte]=2&title[ne]=apple": {"banana"},
+		"id[in]=1,3":                   {"apple", "avocado"},
+	}
+	for raw, want := range cases {
+		query, _ := url.ParseQuery(raw)
+		filter, err := ParseFilter(context.Background(), db, &Note{}, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var notes []Note
+		filter.Apply(db, &notes).Order("id").Find(&notes)
+		var titles []string
+		for _, note := range notes {
+			titles = append(titles, note.Title)
+		}
+		if !reflect.DeepEqual(titles, want) {
+			t.Errorf("%s: %v", raw, titles)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	data := json.RawMessage(`{"title":"Apple","words":10,"done":true,"tag":null}`)
+	cases := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{{Field: "title", Op: "eq", Value: "Apple"}}, true},
+		{Filter{{Field: "title", Op: "eq", Value: "apple"}}, false},
+		{Filter{{Field: "title", Op: "like", Value: "a%"}}, true},
+		{Filter{{Field: "title", Op: "like", Value: "a_"}}, false},
+		{Filter{{Field: "words", Op: "gt", Value: "9"}}, true},
+		{Filter{{Field: "words", Op: "lt", Value: "9"}}, false},
+		{Filter{{Field: "words", Op: "gte", Value: "10"}}, true},
+		{Filter{{Field: "words", Op: "in", Value: "1,10"}}, true},
+		{Filter{{Field: "words", Op: "ne", Value: "10"}}, false},
+		{Filter{{Field: "done", Op: "eq", Value: "1"}}, true},
+		{Filter{{Field: "done", Op: "eq", Value: "false"}}, false},
+		{Filter{{Field: "tag", Op: "eq", Value: "null"}}, true},
+		{Filter{{Field: "title", Op: "eq", Value: "Apple"}, {Field: "words", Op: "eq", Value: "11"}}, false},
+		{Filter{}, true},
+	}
+	for _, c := range cases {
+		if c.filter.Match(data) != c.match {
+			t.Errorf("%+v match %v", c.filter, !c.match)
+		}
+	}
+	if (Filter{{Field: "a", Op: "eq"}}).Match(json.RawMessage("x")) {
+		t.Fatal("match of invalid json")
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	db := openTestDB(t)
+	order, err := parseSort(context.Background(), db, &Note{}, "-updated_at, title")
+	if err != nil || !reflect.DeepEqual(order, []string{`"note"."updated_at" desc`, `"note"."title" asc`}) {
+		t.Fatalf("order %v %v", order, err)
+	}
+	if _, err := parseSort(context.Background(), db, &Note{}, "other"); err == nil {
+		t.Fatal("sort of unknown field")
+	}
+}
@@ -0,0 +1,568 @@
+package gormcrud
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GenConfig is the configuration of GenerateGRPC
+type GenConfig struct {
+	// Dir is the directory of the files, the default is "."
+	Dir string
+	// Name is the name of the files (Name.proto and Name_server.go), the
+	// default is "gormcrud"
+	Name string
+	// ProtoPackage is the package of the proto
+	ProtoPackage string
+	// GoPackage is the option go_package ("path" or "path;name"), the server
+	// is generated in the package of the code of protoc-gen-go
+	GoPackage string
+}
+
+// GenerateGRPC write the proto of the entities with the service of each one
+// (Get, Page, Save and Delete) and the implementation of the servers with the
+// Service of gormcrud. It is for go generate:
+//
+//	//go:generate go run ./gen
+//	//go:generate protoc --go_out=. --go-grpc_out=. api/gormcrud.proto
+//
+// where the program gen calls GenerateGRPC with the entities. The server of
+// the entity Note is registered with
+//
+//	RegisterNoteServiceServer(s, &NoteServer{Service: mapper.NewMap("/note", Note{}, []Note{}).Service()})
+//
+// and it can be tested with one listener of bufconn. The metadata of the
+// calls are the headers of the authenticators and of the tenant resolvers.
+// The number of one field is its tag protobuf (`protobuf:"3"`), the fields
+// without the tag have the first numbers free in the order of the struct. Tag
+// all the fields when the clients are deployed, so the numbers do not change
+// when the struct changes.
+func GenerateGRPC(config GenConfig, entities ...interface{}) error {
+	if config.Dir == "" {
+		config.Dir = "."
+	}
+	if config.Name == "" {
+		config.Name = "gormcrud"
+	}
+	var proto, server bytes.Buffer
+	if err := WriteProto(&proto, config, entities...); err != nil {
+		return err
+	}
+	if err := WriteGRPCServer(&server, config, entities...); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(config.Dir, config.Name+".proto"), proto.Bytes(), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(config.Dir, config.Name+"_server.go"), server.Bytes(), 0644)
+}
+
+// genMessage is the message of one struct
+type genMessage struct {
+	name    string
+	goType  reflect.Type
+	fields  []genField
+	skipped []string
+	err     error
+}
+
+// genField is one field of the message
+type genField struct {
+	number   int
+	name     string
+	goName   string
+	goType   reflect.Type
+	proto    string
+	message  string
+	repeated bool
+	optional bool
+	pointer  bool
+	time     bool
+}
+
+// genProtoTypes are the types of proto of the kinds of go
+var genProtoTypes = map[reflect.Kind]string{
+	reflect.Bool:    "bool",
+	reflect.String:  "string",
+	reflect.Int:     "int64",
+	reflect.Int8:    "int32",
+	reflect.Int16:   "int32",
+	reflect.Int32:   "int32",
+	reflect.Int64:   "int64",
+	reflect.Uint:    "uint64",
+	reflect.Uint8:   "uint32",
+	reflect.Uint16:  "uint32",
+	reflect.Uint32:  "uint32",
+	reflect.Uint64:  "uint64",
+	reflect.Float32: "float",
+	reflect.Float64: "double",
+}
+
+// genGoTypes are the types of go of the code of protoc-gen-go
+var genGoTypes = map[string]string{
+	"bool":   "bool",
+	"string": "string",
+	"int32":  "int32",
+	"int64":  "int64",
+	"uint32": "uint32",
+	"uint64": "uint64",
+	"float":  "float32",
+	"double": "float64",
+	"bytes":  "[]byte",
+}
+
+// genMessages return the messages of the entities and of their relations
+func genMessages(entities []interface{}) ([]*genMessage, error) {
+	var messages []*genMessage
+	seen := map[reflect.Type]bool{}
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		m := &genMessage{name: t.Name(), goType: t}
+		messages = append(messages, m)
+		genFields(m, t)
+		genNumbers(m)
+		for _, f := range m.fields {
+			if f.message != "" {
+				visit(baseType(f.goType))
+			}
+		}
+	}
+	for _, entity := range entities {
+		visit(baseType(reflect.TypeOf(entity)))
+	}
+	for _, m := range messages {
+		if m.err != nil {
+			return nil, m.err
+		}
+	}
+	return messages, nil
+}
+
+// genFields add the fields of the struct t (and of its embedded structs)
+func genFields(m *genMessage, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			genFields(m, sf.Type)
+			continue
+		}
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		f := genField{name: name, goName: sf.Name, goType: sf.Type}
+		if number, ok := sf.Tag.Lookup("protobuf"); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 1 || n > 536870911 || (n >= 19000 && n <= 19999) {
+				m.err = fmt.Errorf("gormcrud: invalid protobuf number %q of %s.%s", number, m.name, sf.Name)
+			}
+			f.number = n
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			f.pointer, ft = true, ft.Elem()
+		}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			f.repeated, ft = true, ft.Elem()
+			if ft.Kind() == reflect.Ptr {
+				f.pointer, ft = true, ft.Elem()
+			}
+		}
+		switch {
+		case !isIdent(name):
+			m.skipped = append(m.skipped, name+": invalid name")
+			continue
+		case ft == timeType && !f.repeated:
+			f.proto, f.time = "google.protobuf.Timestamp", true
+		case ft.Kind() == reflect.Struct && ft != timeType && ft.Name() != "":
+			f.proto, f.message = ft.Name(), ft.Name()
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Uint8 && !f.repeated && !f.pointer:
+			f.proto = "bytes"
+		case genProtoTypes[ft.Kind()] != "" && !(f.repeated && f.pointer):
+			f.proto = genProtoTypes[ft.Kind()]
+			if f.repeated && ft.String() != genGoTypes[f.proto] {
+				m.skipped = append(m.skipped, name+": unsupported type "+sf.Type.String())
+				continue
+			}
+			f.optional = f.pointer
+		default:
+			m.skipped = append(m.skipped, name+": unsupported type "+sf.Type.String())
+			continue
+		}
+		m.fields = append(m.fields, f)
+	}
+}
+
+// genNumbers set the numbers of the fields without the tag protobuf
+func genNumbers(m *genMessage) {
+	used := map[int]string{}
+	for _, f := range m.fields {
+		if f.number == 0 {
+			continue
+		}
+		if other, ok := used[f.number]; ok && m.err == nil {
+			m.err = fmt.Errorf("gormcrud: protobuf number %d of %s.%s is used by %s", f.number, m.name, f.goName, other)
+		}
+		used[f.number] = f.goName
+	}
+	next := 1
+	for i := range m.fields {
+		if m.fields[i].number != 0 {
+			continue
+		}
+		for used[next] != "" || (next >= 19000 && next <= 19999) {
+			next++
+		}
+		m.fields[i].number = next
+		used[next] = m.fields[i].goName
+	}
+}
+
+func isIdent(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteProto write the proto of the entities (see GenerateGRPC)
+func WriteProto(w io.Writer, config GenConfig, entities ...interface{}) error {
+	messages, err := genMessages(entities)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gormcrud. DO NOT EDIT.\n\nsyntax = \"proto3\";\n\n")
+	if config.ProtoPackage != "" {
+		fmt.Fprintf(&b, "package %s;\n\n", config.ProtoPackage)
+	}
+	if config.GoPackage != "" {
+		fmt.Fprintf(&b, "option go_package = %q;\n\n", config.GoPackage)
+	}
+	if genUsesTime(messages) {
+		b.WriteString("import \"google/protobuf/timestamp.proto\";\n\n")
+	}
+	for _, m := range messages {
+		fmt.Fprintf(&b, "message %s {\n", m.name)
+		for _, f := range m.fields {
+			label := ""
+			if f.repeated {
+				label = "repeated "
+			} else if f.optional {
+				label = "optional "
+			}
+			fmt.Fprintf(&b, "  %s%s %s = %d;\n", label, f.proto, f.name, f.number)
+		}
+		for _, skipped := range m.skipped {
+			fmt.Fprintf(&b, "  // skipped %s\n", skipped)
+		}
+		b.WriteString("}\n\n")
+	}
+	for _, entity := range entities {
+		name := baseType(reflect.TypeOf(entity)).Name()
+		fmt.Fprintf(&b, "message Get%sRequest {\n  string id = 1;\n}\n\n", name)
+		fmt.Fprintf(&b, "message Page%sRequest {\n  int32 page = 1;\n  int32 limit = 2;\n  map<string, string> filter = 3;\n}\n\n", name)
+		fmt.Fprintf(&b, "message Page%sResponse {\n  repeated %s records = 1;\n  int64 total_record = 2;\n  int32 total_page = 3;\n  int32 page = 4;\n  int32 limit = 5;\n}\n\n", name, name)
+		fmt.Fprintf(&b, "message Delete%sRequest {\n  string id = 1;\n  bool purge = 2;\n}\n\n", name)
+		fmt.Fprintf(&b, "service %sService {\n", name)
+		fmt.Fprintf(&b, "  rpc Get(Get%sRequest) returns (%s);\n", name, name)
+		fmt.Fprintf(&b, "  rpc Page(Page%sRequest) returns (Page%sResponse);\n", name, name)
+		fmt.Fprintf(&b, "  rpc Save(%s) returns (%s);\n", name, name)
+		fmt.Fprintf(&b, "  rpc Delete(Delete%sRequest) returns (%s);\n", name, name)
+		b.WriteString("}\n\n")
+	}
+	_, err = w.Write(bytes.TrimRight(b.Bytes(), "\n"))
+	if err == nil {
+		_, err = w.Write([]byte("\n"))
+	}
+	return err
+}
+
+func genUsesTime(messages []*genMessage) bool {
+	for _, m := range messages {
+		for _, f := range m.fields {
+			if f.time {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goCamelCase return the name of go of the field of proto as protoc-gen-go
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_' && i == 0:
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && s[i+1] >= 'a' && s[i+1] <= 'z':
+		case c >= '0' && c <= '9':
+			b = append(b, c)
+		default:
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && s[i+1] >= 'a' && s[i+1] <= 'z'; i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+// genWriter write the code of the server
+type genWriter struct {
+	bytes.Buffer
+	imports map[string]string
+}
+
+// typeName return the name of the type of go with the alias of its package
+func (g *genWriter) typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.typeName(t.Elem())
+	case reflect.Slice:
+		if t.Name() == "" {
+			return "[]" + g.typeName(t.Elem())
+		}
+	}
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	alias, ok := g.imports[t.PkgPath()]
+	if !ok {
+		alias = fmt.Sprintf("m%d", len(g.imports))
+		g.imports[t.PkgPath()] = alias
+	}
+	return alias + "." + t.Name()
+}
+
+// WriteGRPCServer write the implementation of the servers of the entities
+// (see GenerateGRPC)
+func WriteGRPCServer(w io.Writer, config GenConfig, entities ...interface{}) error {
+	messages, err := genMessages(entities)
+	if err != nil {
+		return err
+	}
+	pkg := path.Base(config.GoPackage)
+	if i := strings.Index(config.GoPackage, ";"); i >= 0 {
+		pkg = config.GoPackage[i+1:]
+	}
+	if pkg == "" || pkg == "." {
+		pkg = "pb"
+	}
+	pkg = strings.Replace(pkg, "-", "_", -1)
+	g := &genWriter{imports: map[string]string{}}
+
+	g.WriteString(`// request return the request of gormcrud with the metadata of the call
+func request(ctx context.Context) *http.Request {
+	header := http.Header{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return gormcrud.NewRequest(ctx, header)
+}
+
+// grpcError return the status of the error of gormcrud
+func grpcError(err error) error {
+	e, ok := err.(gormcrud.ErrorCrud)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Unknown
+	switch e.Code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusPreconditionFailed:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Error(code, e.Message)
+}
+`)
+	for _, entity := range entities {
+		t := baseType(reflect.TypeOf(entity))
+		name, goName := t.Name(), g.typeName(t)
+		conv := lowerFirst(name)
+		fmt.Fprintf(g, `
+// %[1]sServer is the %[1]sServiceServer of the Service of gormcrud
+type %[1]sServer struct {
+	Unimplemented%[1]sServiceServer
+	Service *gormcrud.Service
+}
+
+// Get return the entity with the id
+func (s *%[1]sServer) Get(ctx context.Context, req *Get%[1]sRequest) (*%[1]s, error) {
+	entity, err := s.Service.Get(request(ctx), req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return %[3]sToProto(entity.(*%[2]s)), nil
+}
+
+// Page return the page of the entities with the filter of the lists
+func (s *%[1]sServer) Page(ctx context.Context, req *Page%[1]sRequest) (*Page%[1]sResponse, error) {
+	query := url.Values{}
+	for key, value := range req.GetFilter() {
+		query.Set(key, value)
+	}
+	page, err := s.Service.Page(request(ctx), int(req.GetPage()), int(req.GetLimit()), query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &Page%[1]sResponse{
+		TotalRecord: int64(page.TotalRecord),
+		TotalPage:   int32(page.TotalPage),
+		Page:        int32(page.Page),
+		Limit:       int32(page.Limit),
+	}
+	records := *page.Records.(*[]%[2]s)
+	for i := range records {
+		res.Records = append(res.Records, %[3]sToProto(&records[i]))
+	}
+	return res, nil
+}
+
+// Save create or update the entity
+func (s *%[1]sServer) Save(ctx context.Context, req *%[1]s) (*%[1]s, error) {
+	entity, err := s.Service.Save(request(ctx), %[3]sFromProto(req))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return %[3]sToProto(entity.(*%[2]s)), nil
+}
+
+// Delete delete the entity with the id, purge delete it permanently
+func (s *%[1]sServer) Delete(ctx context.Context, req *Delete%[1]sRequest) (*%[1]s, error) {
+	entity, err := s.Service.Delete(request(ctx), req.GetId(), req.GetPurge())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return %[3]sToProto(entity.(*%[2]s)), nil
+}
+`, name, goName, conv)
+	}
+	for _, m := range messages {
+		g.writeConverters(m)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gormcrud. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	out.WriteString("\t\"context\"\n\t\"net/http\"\n\t\"net/url\"\n\n")
+	out.WriteString("\t\"github.com/gopher1980/gormcrud\"\n")
+	out.WriteString("\t\"google.golang.org/grpc/codes\"\n\t\"google.golang.org/grpc/metadata\"\n\t\"google.golang.org/grpc/status\"\n")
+	if genUsesTime(messages) {
+		out.WriteString("\t\"google.golang.org/protobuf/types/known/timestamppb\"\n")
+	}
+	for importPath, alias := range g.imports {
+		fmt.Fprintf(&out, "\t%s %q\n", alias, importPath)
+	}
+	out.WriteString(")\n\n")
+	out.Write(g.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// writeConverters write the conversion of the struct to the message and of
+// the message to the struct
+func (g *genWriter) writeConverters(m *genMessage) {
+	conv := lowerFirst(m.name)
+	goName := g.typeName(m.goType)
+	fmt.Fprintf(g, "\nfunc %sToProto(e *%s) *%s {\n\tif e == nil {\n\t\treturn nil\n\t}\n\tp := &%s{}\n", conv, goName, m.name, m.name)
+	for _, f := range m.fields {
+		pf, ef := "p."+goCamelCase(f.name), "e."+f.goName
+		switch {
+		case f.message != "" && f.repeated && f.pointer:
+			fmt.Fprintf(g, "\tfor _, v := range %s {\n\t\t%s = append(%s, %sToProto(v))\n\t}\n", ef, pf, pf, lowerFirst(f.message))
+		case f.message != "" && f.repeated:
+			fmt.Fprintf(g, "\tfor i := range %s {\n\t\t%s = append(%s, %sToProto(&%s[i]))\n\t}\n", ef, pf, pf, lowerFirst(f.message), ef)
+		case f.message != "" && f.pointer:
+			fmt.Fprintf(g, "\t%s = %sToProto(%s)\n", pf, lowerFirst(f.message), ef)
+		case f.message != "":
+			fmt.Fprintf(g, "\t%s = %sToProto(&%s)\n", pf, lowerFirst(f.message), ef)
+		case f.time && f.pointer:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\t%s = timestamppb.New(*%s)\n\t}\n", ef, pf, ef)
+		case f.time:
+			fmt.Fprintf(g, "\t%s = timestamppb.New(%s)\n", pf, ef)
+		case f.optional:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\tv := %s(*%s)\n\t\t%s = &v\n\t}\n", ef, genGoTypes[f.proto], ef, pf)
+		case f.repeated || f.proto == "bytes":
+			fmt.Fprintf(g, "\t%s = %s\n", pf, ef)
+		default:
+			fmt.Fprintf(g, "\t%s = %s(%s)\n", pf, genGoTypes[f.proto], ef)
+		}
+	}
+	g.WriteString("\treturn p\n}\n")
+
+	fmt.Fprintf(g, "\nfunc %sFromProto(p *%s) *%s {\n\te := &%s{}\n\tif p == nil {\n\t\treturn e\n\t}\n", conv, m.name, goName, goName)
+	for _, f := range m.fields {
+		pf, ef := "p."+goCamelCase(f.name), "e."+f.goName
+		switch {
+		case f.message != "" && f.repeated && f.pointer:
+			fmt.Fprintf(g, "\tfor _, v := range %s {\n\t\t%s = append(%s, %sFromProto(v))\n\t}\n", pf, ef, ef, lowerFirst(f.message))
+		case f.message != "" && f.repeated:
+			fmt.Fprintf(g, "\tfor _, v := range %s {\n\t\t%s = append(%s, *%sFromProto(v))\n\t}\n", pf, ef, ef, lowerFirst(f.message))
+		case f.message != "" && f.pointer:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\t%s = %sFromProto(%s)\n\t}\n", pf, ef, lowerFirst(f.message), pf)
+		case f.message != "":
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\t%s = *%sFromProto(%s)\n\t}\n", pf, ef, lowerFirst(f.message), pf)
+		case f.time && f.pointer:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\tt := %s.AsTime()\n\t\t%s = &t\n\t}\n", pf, pf, ef)
+		case f.time:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\t%s = %s.AsTime()\n\t}\n", pf, ef, pf)
+		case f.optional:
+			fmt.Fprintf(g, "\tif %s != nil {\n\t\tv := %s(*%s)\n\t\t%s = &v\n\t}\n", pf, g.typeName(f.goType.Elem()), pf, ef)
+		case f.repeated || f.proto == "bytes":
+			fmt.Fprintf(g, "\t%s = %s\n", ef, pf)
+		default:
+			fmt.Fprintf(g, "\t%s = %s(%s)\n", ef, g.typeName(f.goType), pf)
+		}
+	}
+	g.WriteString("\treturn e\n}\n")
+}
@@ -0,0 +1,353 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+type GenTag struct {
+	ID   uint   `gorm:"primary_key" json:"id" protobuf:"1"`
+	Name string `json:"name" protobuf:"2"`
+}
+
+type GenNote struct {
+	ID        uint           `gorm:"primary_key" json:"id" protobuf:"1"`
+	CreatedAt time.Time      `json:"created_at" protobuf:"4"`
+	DeletedAt *time.Time     `json:"deleted_at" protobuf:"5"`
+	Title     string         `json:"title" protobuf:"2"`
+	Words     *int           `json:"words"`
+	Data      []byte         `json:"data"`
+	Tags      []GenTag       `json:"tags" gorm:"many2many:gen_tag_note;" protobuf:"3"`
+	Meta      map[string]int `json:"meta"`
+}
+
+// golden compare got with the file of testdata, -update writes it
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(file, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file (go test -run %s -update):\n%s", name, t.Name(), got)
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	config := GenConfig{ProtoPackage: "notes", GoPackage: "example.com/notes/pb;pb"}
+	var proto, server bytes.Buffer
+	if err := WriteProto(&proto, config, GenNote{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteGRPCServer(&server, config, GenNote{}); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "gormcrud.proto.golden", proto.Bytes())
+	golden(t, "gormcrud_server.go.golden", server.Bytes())
+}
+
+// genStruct return the value of one struct with the fields (name -> tag)
+func genStruct(names []string, tags []string) interface{} {
+	var fields []reflect.StructField
+	for i, name := range names {
+		fields = append(fields, reflect.StructField{Name: name, Type: reflect.TypeOf(""), Tag: reflect.StructTag(tags[i])})
+	}
+	return reflect.New(reflect.StructOf(fields)).Elem().Interface()
+}
+
+func TestGenerateNumbers(t *testing.T) {
+	numbers := func(entity interface{}) map[string]int {
+		messages, err := genMessages([]interface{}{entity})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]int{}
+		for _, f := range messages[0].fields {
+			got[f.name] = f.number
+		}
+		return got
+	}
+	want := map[string]int{"A": 2, "B": 1, "C": 3, "D": 4}
+	if got := numbers(genStruct([]string{"A", "B", "C", "D"}, []string{``, `protobuf:"1"`, `protobuf:"3"`, ``})); !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers %v", got)
+	}
+	// the tagged fields keep their numbers when the struct changes
+	want = map[string]int{"X": 2, "B": 1, "C": 3, "A": 4, "D": 5}
+	if got := numbers(genStruct([]string{"X", "B", "C", "A", "D"}, []string{``, `protobuf:"1"`, `protobuf:"3"`, ``, ``})); !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers %v", got)
+	}
+	want = map[string]int{"ID": 1, "Title": 2, "Tags": 3, "CreatedAt": 4, "DeletedAt": 5, "Words": 6, "Data": 7}
+	messages, _ := genMessages([]interface{}{GenNote{}})
+	for _, f := range messages[0].fields {
+		if want[f.goName] != f.number {
+			t.Errorf("%s = %d", f.goName, f.number)
+		}
+	}
+
+	for _, tags := range [][]string{
+		{`protobuf:"1"`, `protobuf:"1"`},
+		{`protobuf:"0"`, ``},
+		{`protobuf:"x"`, ``},
+		{`protobuf:"19000"`, ``},
+		{`protobuf:"536870912"`, ``},
+	} {
+		entity := genStruct([]string{"A", "B"}, tags)
+		if _, err := genMessages([]interface{}{entity}); err == nil || !strings.Contains(err.Error(), "protobuf number") {
+			t.Errorf("%v: %v", tags, err)
+		}
+		if err := WriteProto(ioutil.Discard, GenConfig{}, entity); err == nil {
+			t.Errorf("%v: WriteProto without error", tags)
+		}
+		if err := WriteGRPCServer(ioutil.Discard, GenConfig{}, entity); err == nil {
+			t.Errorf("%v: WriteGRPCServer without error", tags)
+		}
+	}
+}
+
+// The test of the server generated runs the steps of go generate in the
+// directory grpctest of the module: the program gen writes the proto and the
+// server, the proto is compiled by protocompile for protoc-gen-go and
+// protoc-gen-go-grpc, and the test of the package pb calls the server on one
+// listener of bufconn.
+
+var grpcFiles = map[string]string{
+	"model/model.go": `package model
+
+import "time"
+
+type GenTag struct {
+	ID   uint   ` + "`gorm:\"primary_key\" json:\"id\" protobuf:\"1\"`" + `
+	Name string ` + "`json:\"name\" protobuf:\"2\"`" + `
+}
+
+type GenNote struct {
+	ID        uint       ` + "`gorm:\"primary_key\" json:\"id\" protobuf:\"1\"`" + `
+	CreatedAt time.Time  ` + "`json:\"created_at\" protobuf:\"4\"`" + `
+	DeletedAt *time.Time ` + "`json:\"deleted_at\" protobuf:\"5\"`" + `
+	Title     string     ` + "`json:\"title\" protobuf:\"2\"`" + `
+	Words     *int       ` + "`json:\"words\"`" + `
+	Data      []byte     ` + "`json:\"data\"`" + `
+	Tags      []GenTag   ` + "`json:\"tags\" gorm:\"many2many:gen_tag_note;\" protobuf:\"3\"`" + `
+}
+`,
+	"gen/main.go": `package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/gopher1980/gormcrud"
+	"github.com/gopher1980/gormcrud/grpctest/model"
+)
+
+func main() {
+	config := gormcrud.GenConfig{Dir: os.Args[1], ProtoPackage: "notes", GoPackage: "github.com/gopher1980/gormcrud/grpctest/pb;pb"}
+	if err := gormcrud.GenerateGRPC(config, model.GenNote{}); err != nil {
+		log.Fatal(err)
+	}
+}
+`,
+	"pb/server_test.go": `package pb
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/gopher1980/gormcrud"
+	"github.com/gopher1980/gormcrud/grpctest/model"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&model.GenNote{}, &model.GenTag{})
+	service := gormcrud.MapMux(mux.NewRouter(), db).NewMap("/note", model.GenNote{}, []model.GenNote{}).Filterable().Service()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterGenNoteServiceServer(s, &GenNoteServer{Service: service})
+	go s.Serve(lis)
+	defer s.Stop()
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewGenNoteServiceClient(conn)
+	ctx := context.Background()
+
+	words := int64(3)
+	saved, err := client.Save(ctx, &GenNote{Title: "a", Words: &words, Data: []byte("x")})
+	if err != nil || saved.GetId() != 1 || saved.GetCreatedAt() == nil {
+		t.Fatalf("save %v %v", saved, err)
+	}
+	if _, err := client.Save(ctx, &GenNote{Title: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.Get(ctx, &GetGenNoteRequest{Id: "1"})
+	if err != nil || got.GetTitle() != "a" || got.Words == nil || got.GetWords() != 3 || string(got.GetData()) != "x" || got.DeletedAt != nil {
+		t.Fatalf("get %v %v", got, err)
+	}
+	page, err := client.Page(ctx, &PageGenNoteRequest{Page: 1, Limit: 10, Filter: map[string]string{"title": "b"}})
+	if err != nil || page.GetTotalRecord() != 1 || len(page.GetRecords()) != 1 || page.GetRecords()[0].GetTitle() != "b" {
+		t.Fatalf("page %v %v", page, err)
+	}
+	if _, err := client.Page(ctx, &PageGenNoteRequest{Filter: map[string]string{"other": "b"}}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid filter %v", err)
+	}
+	if _, err := client.Get(ctx, &GetGenNoteRequest{Id: "9"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("get not found %v", err)
+	}
+	if deleted, err := client.Delete(ctx, &DeleteGenNoteRequest{Id: "1"}); err != nil || deleted.GetId() != 1 {
+		t.Fatalf("delete %v %v", deleted, err)
+	}
+	if _, err := client.Get(ctx, &GetGenNoteRequest{Id: "1"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("get deleted %v", err)
+	}
+}
+`,
+}
+
+// goCommand run the command go in the module, env is added to the
+// environment
+func goCommand(t *testing.T, env []string, args ...string) error {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("go %s: %s", strings.Join(args, " "), out)
+	}
+	return err
+}
+
+// protocRequest compile the proto of dir and return the request of the
+// plugins of protoc
+func protocRequest(t *testing.T, dir string, name string) *pluginpb.CodeGeneratorRequest {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{dir}}),
+	}
+	files, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: []string{name}, Parameter: proto.String("paths=source_relative")}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		req.ProtoFile = append(req.ProtoFile, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(files[0])
+	return req
+}
+
+// runPlugin run the plugin of protoc and write its files in dir
+func runPlugin(t *testing.T, plugin string, req *pluginpb.CodeGeneratorRequest, dir string) {
+	in, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(plugin)
+	cmd.Stdin = bytes.NewReader(in)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v", plugin, err)
+	}
+	var res pluginpb.CodeGeneratorResponse
+	if err := proto.Unmarshal(out, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		t.Fatalf("%s: %s", plugin, res.GetError())
+	}
+	for _, f := range res.File {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.GetName()), []byte(f.GetContent()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGenerateGRPCServe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("it builds the plugins of protoc and the server")
+	}
+	dir := "grpctest"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	for name, src := range grpcFiles {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := goCommand(t, nil, "run", "./grpctest/gen", "grpctest/pb"); err != nil {
+		t.Fatal(err)
+	}
+
+	bin, err := filepath.Abs(filepath.Join(dir, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// protoc-gen-go is of the version of protobuf of the module,
+	// protoc-gen-go-grpc is of its own module and it is installed out of the
+	// module, without its flags
+	if err := goCommand(t, nil, "build", "-o", bin+"/", "google.golang.org/protobuf/cmd/protoc-gen-go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goCommand(t, []string{"GOBIN=" + bin, "GOFLAGS="}, "install", "google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1"); err != nil {
+		t.Skipf("protoc-gen-go-grpc is not available: %v", err)
+	}
+	req := protocRequest(t, filepath.Join(dir, "pb"), "gormcrud.proto")
+	runPlugin(t, filepath.Join(bin, "protoc-gen-go"), req, filepath.Join(dir, "pb"))
+	runPlugin(t, filepath.Join(bin, "protoc-gen-go-grpc"), req, filepath.Join(dir, "pb"))
+
+	if err := goCommand(t, nil, "test", "./grpctest/pb"); err != nil {
+		t.Fatal(err)
+	}
+}
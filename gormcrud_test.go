@@ -0,0 +1,92 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// The tests run the operations on a sqlite in memory with the entities of
+// this file, each test open its own db.
+
+type Note struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	Title     string     `json:"title"`
+	Words     int        `json:"words"`
+	Tags      []Tag      `json:"tags" gorm:"many2many:tag_note;"`
+}
+
+type Tag struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	Name      string     `json:"name"`
+}
+
+// openTestDB return a new db in memory with the tables of the entities
+func openTestDB(t *testing.T, entities ...interface{}) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection, each connection of sqlite in memory is a new db
+	db.DB().SetMaxOpenConns(1)
+	db.SingularTable(true)
+	if err := db.AutoMigrate(append([]interface{}{&Note{}, &Tag{}}, entities...)...).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestMux return the db and the router of mux with notes and tags
+func newTestMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t)
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).Full().
+		NewMap("/tag", Tag{}, []Tag{}).Full()
+	return db, r
+}
+
+// serve run the request on the handler, header is pairs of name and value
+func serve(h http.Handler, method string, url string, body string, header ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, url, reader)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// expectCode fail the test if the response has not the status code
+func expectCode(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("status %d, want %d: %s", w.Code, code, w.Body.String())
+	}
+}
+
+// decode decode the body of the response in v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid json %v: %s", err, w.Body.String())
+	}
+}
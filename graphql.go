@@ -0,0 +1,749 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GraphQL is the endpoint of GraphQL of the resources registered with the
+// mapper. For the entity Note the schema has the queries note(id) and
+// noteList(filter, page, limit) and the mutations saveNote(input),
+// deleteNote(id, purge), linkNote(id, field, ids) and unlinkNote(id, field,
+// ids). The filter has the fields of the filter of the lists with the
+// operator as suffix (title_like, id_gt). The relations selected are loaded
+// with one query for each relation, scoped by the tenant and authorized with
+// the policy of their resource, up to MaxDepth levels. The lists return at
+// most MaxLimit entities. GET without query return the schema.
+type GraphQL struct {
+	MaxDepth  int
+	MaxLimit  int
+	mu        sync.RWMutex
+	resources []*gqlResource
+	types     map[string]*gqlType
+	order     []string
+}
+
+// gqlResource is one resource registered with the settings of its mapper
+type gqlResource struct {
+	db   *gorm.DB
+	elem interface{}
+	typ  *gqlType
+}
+
+// gqlType is the type of object of one struct
+type gqlType struct {
+	goType reflect.Type
+	name   string
+	fields []*gqlField
+	byName map[string]*gqlField
+}
+
+// gqlField is one field of the type, relations have the type of object
+type gqlField struct {
+	name   string
+	goName string
+	scalar string
+	object string
+	list   bool
+}
+
+// gqlError is one error of the response
+type gqlError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// gqlResponse is the response of the endpoint
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// NewGraphQL is constructor of GraphQL
+func NewGraphQL() *GraphQL {
+	return &GraphQL{MaxDepth: 5, MaxLimit: 100, types: map[string]*gqlType{}}
+}
+
+// register add the resource of elem, db has the settings of the mapper
+func (gql *GraphQL) register(db *gorm.DB, elem interface{}) {
+	gql.mu.Lock()
+	defer gql.mu.Unlock()
+	typ := gql.buildType(db, reflect.TypeOf(elem))
+	gql.resources = append(gql.resources, &gqlResource{db: db, elem: elem, typ: typ})
+}
+
+// buildType return the type of object of the struct t and of its relations
+func (gql *GraphQL) buildType(db *gorm.DB, t reflect.Type) *gqlType {
+	t = baseType(t)
+	if typ, ok := gql.types[t.Name()]; ok {
+		return typ
+	}
+	typ := &gqlType{goType: t, name: t.Name(), byName: map[string]*gqlField{}}
+	gql.types[typ.name] = typ
+	gql.order = append(gql.order, typ.name)
+	for _, field := range db.NewScope(reflect.New(t).Interface()).Fields() {
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsNormal && field.Relationship == nil) {
+			continue
+		}
+		f := &gqlField{name: strings.Split(tag, ",")[0], goName: field.Name}
+		if f.name == "" {
+			f.name = field.Name
+		}
+		if field.Relationship != nil {
+			ft := field.Struct.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			f.list = ft.Kind() == reflect.Slice
+			f.object = gql.buildType(db, ft).name
+		} else if field.IsPrimaryKey {
+			f.scalar = "ID"
+		} else {
+			f.scalar = gqlScalar(field.Struct.Type)
+		}
+		typ.fields = append(typ.fields, f)
+		typ.byName[f.name] = f
+	}
+	return typ
+}
+
+// gqlScalar return the scalar of the type of one field
+func gqlScalar(t reflect.Type) string {
+	t = baseType(t)
+	if t == timeType {
+		return "Time"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "Int"
+	case reflect.Float32, reflect.Float64:
+		return "Float"
+	case reflect.Bool:
+		return "Boolean"
+	case reflect.String:
+		return "String"
+	}
+	return "JSON"
+}
+
+// lowerFirst return the name with the first letter in lower case
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// SDL return the schema in the language of GraphQL
+func (gql *GraphQL) SDL() string {
+	gql.mu.RLock()
+	defer gql.mu.RUnlock()
+	var b strings.Builder
+	b.WriteString("scalar Time\n\nscalar JSON\n")
+	for _, name := range gql.order {
+		typ := gql.types[name]
+		fmt.Fprintf(&b, "\ntype %s {\n", typ.name)
+		for _, f := range typ.fields {
+			switch {
+			case f.object != "" && f.list:
+				fmt.Fprintf(&b, "  %s: [%s]\n", f.name, f.object)
+			case f.object != "":
+				fmt.Fprintf(&b, "  %s: %s\n", f.name, f.object)
+			case f.scalar == "ID":
+				fmt.Fprintf(&b, "  %s: ID!\n", f.name)
+			default:
+				fmt.Fprintf(&b, "  %s: %s\n", f.name, f.scalar)
+			}
+		}
+		b.WriteString("}\n")
+	}
+	for _, res := range gql.resources {
+		name := res.typ.name
+		fmt.Fprintf(&b, "\ninput %sInput {\n", name)
+		for _, f := range res.typ.fields {
+			if f.object == "" {
+				fmt.Fprintf(&b, "  %s: %s\n", f.name, f.scalar)
+			}
+		}
+		fmt.Fprintf(&b, "}\n\ninput %sFilter {\n", name)
+		for _, f := range res.typ.fields {
+			if f.object != "" {
+				continue
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", f.name, f.scalar)
+			for _, op := range []string{"ne", "gt", "gte", "lt", "lte"} {
+				fmt.Fprintf(&b, "  %s_%s: %s\n", f.name, op, f.scalar)
+			}
+			if f.scalar == "String" {
+				fmt.Fprintf(&b, "  %s_like: String\n", f.name)
+			}
+			fmt.Fprintf(&b, "  %s_in: [%s]\n", f.name, f.scalar)
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString("\ntype Query {\n")
+	for _, res := range gql.resources {
+		name := res.typ.name
+		fmt.Fprintf(&b, "  %s(id: ID!): %s\n", lowerFirst(name), name)
+		fmt.Fprintf(&b, "  %sList(filter: %sFilter, page: Int, limit: Int): [%s]\n", lowerFirst(name), name, name)
+	}
+	b.WriteString("}\n\ntype Mutation {\n")
+	for _, res := range gql.resources {
+		name := res.typ.name
+		fmt.Fprintf(&b, "  save%s(input: %sInput!): %s\n", name, name, name)
+		fmt.Fprintf(&b, "  delete%s(id: ID!, purge: Boolean): %s\n", name, name)
+		fmt.Fprintf(&b, "  link%s(id: ID!, field: String!, ids: [ID!]!): %s\n", name, name)
+		fmt.Fprintf(&b, "  unlink%s(id: ID!, field: String!, ids: [ID!]!): %s\n", name, name)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Serve is the endpoint of GraphQL, POST with {"query", "variables",
+// "operationName"} or GET with the same params in the query string
+func (gql *GraphQL) Serve(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			json.Unmarshal([]byte(vars), &req.Variables)
+		}
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, gql.SDL())
+			return
+		}
+	} else {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			WriteError(w, ErrorCrud{Message: "Invalid Request", Code: http.StatusBadRequest})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gql.execute(r, req.Query, req.Variables, req.OperationName))
+}
+
+// gqlExec is the execution of one operation
+type gqlExec struct {
+	gql    *GraphQL
+	r      *http.Request
+	doc    *gqlDocument
+	vars   map[string]interface{}
+	errors []gqlError
+}
+
+// execute run the operation of the query with the principal, the tenant and
+// the authorization of r
+func (gql *GraphQL) execute(r *http.Request, query string, variables map[string]interface{}, operationName string) gqlResponse {
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{Message: err.Error()}}}
+	}
+	var op *gqlOperation
+	for _, o := range doc.operations {
+		if operationName == "" || o.name == operationName {
+			if op != nil {
+				return gqlResponse{Errors: []gqlError{{Message: "operationName is required"}}}
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return gqlResponse{Errors: []gqlError{{Message: "Unknown operation " + operationName}}}
+	}
+	if op.kind == "mutation" && r.Method == http.MethodGet {
+		return gqlResponse{Errors: []gqlError{{Message: "mutation is not allowed with GET"}}}
+	}
+	vars := map[string]interface{}{}
+	for name, value := range op.variables {
+		vars[name] = value
+	}
+	for name, value := range variables {
+		vars[name] = value
+	}
+	gql.mu.RLock()
+	defer gql.mu.RUnlock()
+	e := &gqlExec{gql: gql, r: r, doc: doc, vars: vars}
+	root := "Query"
+	if op.kind == "mutation" {
+		root = "Mutation"
+	}
+	data := &Object{}
+	for _, sel := range e.collect(op.selections, root) {
+		path := []interface{}{sel.key()}
+		if sel.name == "__typename" {
+			data.Set(sel.key(), root)
+			continue
+		}
+		value, err := e.resolveRoot(root, sel, path)
+		if err != nil {
+			e.fail(err, path)
+		}
+		data.Set(sel.key(), value)
+	}
+	return gqlResponse{Data: data, Errors: e.errors}
+}
+
+// fail add the error of the path to the response
+func (e *gqlExec) fail(err error, path []interface{}) {
+	errCrud := toErrorCrud(err)
+	e.errors = append(e.errors, gqlError{
+		Message:    errCrud.Message,
+		Path:       path,
+		Extensions: map[string]interface{}{"code": errCrud.Code},
+	})
+}
+
+// collect return the fields of the selections with the fragments of the
+// type expanded
+func (e *gqlExec) collect(selections []gqlSelection, typeName string) []gqlSelection {
+	var fields []gqlSelection
+	seen := map[string]bool{}
+	var visit func([]gqlSelection)
+	visit = func(selections []gqlSelection) {
+		for _, sel := range selections {
+			switch {
+			case sel.spread != "":
+				if fragment, ok := e.doc.fragments[sel.spread]; ok && fragment.on == typeName {
+					visit(fragment.selections)
+				}
+			case sel.inline:
+				if sel.on == "" || sel.on == typeName {
+					visit(sel.selections)
+				}
+			case !seen[sel.key()]:
+				seen[sel.key()] = true
+				fields = append(fields, sel)
+			}
+		}
+	}
+	visit(selections)
+	return fields
+}
+
+// arg return the argument of the field with the variables resolved
+func (e *gqlExec) arg(sel gqlSelection, name string) interface{} {
+	return e.value(sel.arguments[name])
+}
+
+func (e *gqlExec) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case gqlVariable:
+		return e.vars[string(t)]
+	case gqlEnum:
+		return string(t)
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, item := range t {
+			list[i] = e.value(item)
+		}
+		return list
+	case map[string]interface{}:
+		object := map[string]interface{}{}
+		for k, item := range t {
+			object[k] = e.value(item)
+		}
+		return object
+	}
+	return v
+}
+
+// gqlString return the value of one argument as string
+func gqlString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case json.Number:
+		return t.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// resolveRoot resolve one field of Query or Mutation
+func (e *gqlExec) resolveRoot(root string, sel gqlSelection, path []interface{}) (interface{}, error) {
+	for _, res := range e.gql.resources {
+		name := res.typ.name
+		if root == "Query" {
+			switch sel.name {
+			case lowerFirst(name):
+				return e.get(res, sel, path)
+			case lowerFirst(name) + "List":
+				return e.list(res, sel, path)
+			}
+			continue
+		}
+		switch sel.name {
+		case "save" + name:
+			return e.save(res, sel, path)
+		case "delete" + name:
+			return e.delete(res, sel, path)
+		case "link" + name:
+			return e.link(res, sel, path, "link")
+		case "unlink" + name:
+			return e.link(res, sel, path, "unlink")
+		}
+	}
+	if strings.HasPrefix(sel.name, "__") {
+		return nil, ErrorCrud{Message: "Introspection is not supported, GET the endpoint for the schema", Code: http.StatusBadRequest}
+	}
+	return nil, ErrorCrud{Message: "Cannot query field " + sel.name + " on type " + root, Code: http.StatusBadRequest}
+}
+
+// preload add the preload of the relations selected, gorm loads each
+// relation of all the entities with one query, scoped by the tenant of db
+func (e *gqlExec) preload(db *gorm.DB, typ *gqlType, selections []gqlSelection, prefix string, depth int) (*gorm.DB, error) {
+	for _, sel := range e.collect(selections, typ.name) {
+		f, ok := typ.byName[sel.name]
+		if !ok || f.object == "" {
+			continue
+		}
+		if depth >= e.gql.MaxDepth {
+			return nil, ErrorCrud{Message: fmt.Sprintf("Relations are limited to %d levels", e.gql.MaxDepth), Code: http.StatusBadRequest}
+		}
+		related := reflect.New(e.gql.types[f.object].goType).Interface()
+		tenant, scoped := db.Get(tenantKey)
+		db = db.Preload(prefix+f.goName, func(preload *gorm.DB) *gorm.DB {
+			if scoped {
+				preload = preload.Set(tenantKey, tenant)
+			}
+			return ScopeTenant(preload, related)
+		})
+		var err error
+		if db, err = e.preload(db, e.gql.types[f.object], sel.selections, prefix+f.goName+".", depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// authorizeRelations authorize the relations selected of the entity (or
+// entities) v with the policy of the resource of each relation
+func (e *gqlExec) authorizeRelations(typ *gqlType, selections []gqlSelection, v reflect.Value) error {
+	v = reflect.Indirect(v)
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			if err := e.authorizeRelations(typ, selections, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, sel := range e.collect(selections, typ.name) {
+		f, ok := typ.byName[sel.name]
+		if !ok || f.object == "" {
+			continue
+		}
+		related := v.FieldByName(f.goName)
+		if related.Kind() == reflect.Ptr && related.IsNil() {
+			continue
+		}
+		if related.Kind() != reflect.Ptr {
+			related = related.Addr()
+		}
+		op := OpGet
+		if f.list {
+			op = OpAll
+		}
+		if err := authorize(e.r.Context(), e.resourceDB(f.object), op, related.Interface()); err != nil {
+			return err
+		}
+		if err := e.authorizeRelations(e.gql.types[f.object], sel.selections, related); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceDB return the db with the policy of the resource of the type, the
+// types not registered have only the authorization of the entity
+func (e *gqlExec) resourceDB(typeName string) *gorm.DB {
+	for _, res := range e.gql.resources {
+		if res.typ.name == typeName {
+			return res.db
+		}
+	}
+	return e.gql.resources[0].db.New()
+}
+
+// load read the entity by id with the relations selected
+func (e *gqlExec) load(db *gorm.DB, res *gqlResource, sel gqlSelection, entity interface{}, id string) (bool, error) {
+	table := db.NewScope(entity).QuotedTableName()
+	db, err := e.preload(db, res.typ, sel.selections, "", 0)
+	if err != nil {
+		return false, err
+	}
+	ret := ScopeTenant(db, entity).Where(table+".id = ?", id).First(entity)
+	if ret.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, e.authorizeRelations(res.typ, sel.selections, reflect.ValueOf(entity))
+}
+
+// complete return the entity (or entities) of typ with the fields selected
+func (e *gqlExec) complete(typ *gqlType, selections []gqlSelection, entity interface{}, path []interface{}) interface{} {
+	data, err := json.Marshal(publicValue(e.r.Context(), entity))
+	if err != nil {
+		e.fail(err, path)
+		return nil
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.Decode(&value)
+	return e.completeValue(typ, selections, value, path)
+}
+
+func (e *gqlExec) completeValue(typ *gqlType, selections []gqlSelection, value interface{}, path []interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.completeValue(typ, selections, item, append(path[:len(path):len(path)], i))
+		}
+		return list
+	case map[string]interface{}:
+		object := &Object{}
+		for _, sel := range e.collect(selections, typ.name) {
+			fieldPath := append(path[:len(path):len(path)], sel.key())
+			if sel.name == "__typename" {
+				object.Set(sel.key(), typ.name)
+				continue
+			}
+			f, ok := typ.byName[sel.name]
+			if !ok {
+				e.fail(ErrorCrud{Message: "Cannot query field " + sel.name + " on type " + typ.name, Code: http.StatusBadRequest}, fieldPath)
+				object.Set(sel.key(), nil)
+				continue
+			}
+			switch {
+			case f.object != "":
+				object.Set(sel.key(), e.completeValue(e.gql.types[f.object], sel.selections, v[f.name], fieldPath))
+			case f.scalar == "ID" && v[f.name] != nil:
+				object.Set(sel.key(), gqlString(v[f.name]))
+			default:
+				object.Set(sel.key(), v[f.name])
+			}
+		}
+		return object
+	}
+	return nil
+}
+
+// requestDB return the db of the resource for the request
+func (e *gqlExec) requestDB(res *gqlResource) (*gorm.DB, error) {
+	return requestDB(res.db, e.r)
+}
+
+func (e *gqlExec) get(res *gqlResource, sel gqlSelection, path []interface{}) (interface{}, error) {
+	db, err := e.requestDB(res)
+	if err != nil {
+		return nil, err
+	}
+	entity := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	if found, err := e.load(db, res, sel, entity, gqlString(e.arg(sel, "id"))); !found || err != nil {
+		return nil, err
+	}
+	if err := authorize(e.r.Context(), db, OpGet, entity); err != nil {
+		return nil, err
+	}
+	if err := afterRead(e.r.Context(), db, entity); err != nil {
+		return nil, err
+	}
+	return e.complete(res.typ, sel.selections, entity, path), nil
+}
+
+// filterQuery return the query string of the filter of the lists for the
+// argument filter of GraphQL
+func filterQuery(filter interface{}) (url.Values, error) {
+	query := url.Values{}
+	object, ok := filter.(map[string]interface{})
+	if filter != nil && !ok {
+		return nil, ErrorCrud{Message: "Invalid filter", Code: http.StatusBadRequest}
+	}
+	for key, value := range object {
+		name, op := key, "eq"
+		if i := strings.LastIndex(key, "_"); i > 0 {
+			if _, ok := filterOps[key[i+1:]]; ok {
+				name, op = key[:i], key[i+1:]
+			}
+		}
+		if list, ok := value.([]interface{}); ok {
+			values := make([]string, len(list))
+			for i, item := range list {
+				values[i] = gqlString(item)
+			}
+			query.Add(name+"["+op+"]", strings.Join(values, ","))
+			continue
+		}
+		if value == nil {
+			value = "null"
+		}
+		query.Add(name+"["+op+"]", gqlString(value))
+	}
+	return query, nil
+}
+
+func (e *gqlExec) list(res *gqlResource, sel gqlSelection, path []interface{}) (interface{}, error) {
+	db, err := e.requestDB(res)
+	if err != nil {
+		return nil, err
+	}
+	entities := reflect.New(reflect.SliceOf(reflect.TypeOf(res.elem))).Interface()
+	query, err := filterQuery(e.arg(sel, "filter"))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := ParseFilter(e.r.Context(), db, entities, query)
+	if err != nil {
+		return nil, err
+	}
+	db = filter.Apply(ScopeTenant(db, entities), entities)
+	limit, _ := strconv.Atoi(gqlString(e.arg(sel, "limit")))
+	if limit <= 0 || limit > e.gql.MaxLimit {
+		limit = e.gql.MaxLimit
+	}
+	page, _ := strconv.Atoi(gqlString(e.arg(sel, "page")))
+	if page < 1 {
+		page = 1
+	}
+	db = db.Order(db.NewScope(entities).QuotedTableName() + ".id desc").Offset((page - 1) * limit).Limit(limit)
+	preload, err := e.preload(db, res.typ, sel.selections, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if ret := preload.Find(entities); ret.Error != nil {
+		return nil, ret.Error
+	}
+	if err := authorize(e.r.Context(), db, OpAll, entities); err != nil {
+		return nil, err
+	}
+	if err := e.authorizeRelations(res.typ, sel.selections, reflect.ValueOf(entities)); err != nil {
+		return nil, err
+	}
+	if err := afterRead(e.r.Context(), db, entities); err != nil {
+		return nil, err
+	}
+	return e.complete(res.typ, sel.selections, entities, path), nil
+}
+
+func (e *gqlExec) save(res *gqlResource, sel gqlSelection, path []interface{}) (interface{}, error) {
+	db := res.db.Set("gorm:auto_preload", true).
+		Set("gorm:association_autoupdate", false).
+		Set("gorm:association_autocreate", false)
+	db, err := requestDB(db, e.r)
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(e.arg(sel, "input"))
+	if err != nil {
+		return nil, err
+	}
+	entity := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	if err := json.Unmarshal(input, entity); err != nil {
+		return nil, ErrorCrud{Message: "Invalid input: " + err.Error(), Code: http.StatusBadRequest}
+	}
+	if _, err := saveEntity(e.r, db, entity); err != nil {
+		return nil, err
+	}
+	saved := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	id := fmt.Sprint(db.NewScope(entity).PrimaryKeyValue())
+	if found, err := e.load(db.Set("gorm:auto_preload", false), res, sel, saved, id); !found || err != nil {
+		return nil, err
+	}
+	return e.complete(res.typ, sel.selections, saved, path), nil
+}
+
+func (e *gqlExec) delete(res *gqlResource, sel gqlSelection, path []interface{}) (interface{}, error) {
+	db, err := e.requestDB(res)
+	if err != nil {
+		return nil, err
+	}
+	purge, _ := e.arg(sel, "purge").(bool)
+	if purge {
+		db = db.Unscoped()
+	}
+	// entity is the result with the relations selected, deleteEntity read
+	// again the entity in its transaction
+	id := gqlString(e.arg(sel, "id"))
+	entity := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	if found, err := e.load(db, res, sel, entity, id); !found || err != nil {
+		if err == nil {
+			err = ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}
+		}
+		return nil, err
+	}
+	if err := deleteEntity(e.r, db, reflect.New(reflect.TypeOf(res.elem)).Interface(), id, purge); err != nil {
+		return nil, err
+	}
+	return e.complete(res.typ, sel.selections, entity, path), nil
+}
+
+func (e *gqlExec) link(res *gqlResource, sel gqlSelection, path []interface{}, op string) (interface{}, error) {
+	db := res.db.Set("gorm:auto_preload", true).
+		Set("gorm:association_autoupdate", false).
+		Set("gorm:association_autocreate", false)
+	db, err := requestDB(db, e.r)
+	if err != nil {
+		return nil, err
+	}
+	id := gqlString(e.arg(sel, "id"))
+	field, ok := res.typ.byName[gqlString(e.arg(sel, "field"))]
+	if !ok || field.object == "" {
+		return nil, ErrorCrud{Message: "Field Not Found", Code: http.StatusBadRequest}
+	}
+	ids, _ := e.arg(sel, "ids").([]interface{})
+	links := url.Values{}
+	for _, child := range ids {
+		links.Add(field.goName, gqlString(child))
+	}
+	root := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	result, notFound, err := linkEntity(e.r, db, root, id, op, links)
+	if err != nil && err != errLinkRollback {
+		return nil, err
+	}
+	if err == errLinkRollback {
+		var messages []string
+		for _, status := range result {
+			if status.Status == "err" {
+				messages = append(messages, status.Message)
+			}
+		}
+		code := http.StatusConflict
+		if notFound {
+			code = http.StatusNotFound
+		}
+		return nil, ErrorCrud{Message: strings.Join(messages, "; "), Code: code}
+	}
+	linked := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	if found, err := e.load(db.Set("gorm:auto_preload", false), res, sel, linked, id); !found || err != nil {
+		return nil, err
+	}
+	return e.complete(res.typ, sel.selections, linked, path), nil
+}
@@ -0,0 +1,417 @@
+package gormcrud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The parser of the subset of GraphQL of the endpoint: the operations query
+// and mutation with variables, the fields with alias and arguments, the
+// fragments and the inline fragments. The directives are not supported.
+
+// gqlDocument is one parsed document
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is one operation of the document
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  map[string]interface{}
+	selections []gqlSelection
+}
+
+// gqlFragment is one fragment of the document
+type gqlFragment struct {
+	on         string
+	selections []gqlSelection
+}
+
+// gqlSelection is one field, fragment spread (spread) or inline fragment
+// (inline with on)
+type gqlSelection struct {
+	alias      string
+	name       string
+	arguments  map[string]interface{}
+	selections []gqlSelection
+	spread     string
+	inline     bool
+	on         string
+}
+
+// gqlVariable is the reference to one variable in the arguments
+type gqlVariable string
+
+// gqlEnum is the value of enum in the arguments
+type gqlEnum string
+
+// key return the key of the field in the result
+func (sel gqlSelection) key() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+// gqlToken is one token of the document
+type gqlToken struct {
+	kind  byte // 'n' name, 'i' int, 'f' float, 's' string, 'p' punctuator, 0 end
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	src   string
+	pos   int
+	token gqlToken
+}
+
+// parseGraphQL parse the document
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(gqlSyntaxError); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	p := &gqlParser{src: src}
+	p.next()
+	doc = &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.token.kind != 0 {
+		switch {
+		case p.is('p', "{"):
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: p.selectionSet()})
+		case p.is('n', "query"), p.is('n', "mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.is('n', "fragment"):
+			p.next()
+			name := p.name()
+			p.expectName("on")
+			doc.fragments[name] = &gqlFragment{on: p.name(), selections: p.selectionSet()}
+		default:
+			p.fail("unexpected %q", p.token.value)
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("no operation")
+	}
+	return doc, nil
+}
+
+// gqlSyntaxError is the error of syntax of the document
+type gqlSyntaxError struct {
+	message string
+}
+
+func (e gqlSyntaxError) Error() string {
+	return e.message
+}
+
+func (p *gqlParser) fail(format string, args ...interface{}) {
+	panic(gqlSyntaxError{fmt.Sprintf("Syntax Error at %d: ", p.token.pos) + fmt.Sprintf(format, args...)})
+}
+
+func (p *gqlParser) is(kind byte, value string) bool {
+	return p.token.kind == kind && p.token.value == value
+}
+
+func (p *gqlParser) expect(value string) {
+	if !p.is('p', value) {
+		p.fail("expected %q, found %q", value, p.token.value)
+	}
+	p.next()
+}
+
+func (p *gqlParser) expectName(value string) {
+	if !p.is('n', value) {
+		p.fail("expected %q, found %q", value, p.token.value)
+	}
+	p.next()
+}
+
+func (p *gqlParser) name() string {
+	if p.token.kind != 'n' {
+		p.fail("expected name, found %q", p.token.value)
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	op := &gqlOperation{kind: p.name(), variables: map[string]interface{}{}}
+	if p.token.kind == 'n' {
+		op.name = p.name()
+	}
+	if p.is('p', "(") {
+		p.next()
+		for !p.is('p', ")") {
+			p.expect("$")
+			name := p.name()
+			p.expect(":")
+			p.typeRef()
+			op.variables[name] = nil
+			if p.is('p', "=") {
+				p.next()
+				op.variables[name] = p.value(true)
+			}
+		}
+		p.next()
+	}
+	op.selections = p.selectionSet()
+	return op
+}
+
+// typeRef skip the type of one variable, the values are checked by the
+// resolvers
+func (p *gqlParser) typeRef() {
+	if p.is('p', "[") {
+		p.next()
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	if p.is('p', "!") {
+		p.next()
+	}
+}
+
+func (p *gqlParser) selectionSet() []gqlSelection {
+	p.expect("{")
+	var selections []gqlSelection
+	for !p.is('p', "}") {
+		if p.token.kind == 0 {
+			p.fail("unexpected end")
+		}
+		if p.is('p', "...") {
+			p.next()
+			if p.is('n', "on") {
+				p.next()
+				on := p.name()
+				selections = append(selections, gqlSelection{inline: true, on: on, selections: p.selectionSet()})
+			} else if p.is('p', "{") {
+				selections = append(selections, gqlSelection{inline: true, selections: p.selectionSet()})
+			} else {
+				selections = append(selections, gqlSelection{spread: p.name()})
+			}
+			continue
+		}
+		sel := gqlSelection{name: p.name()}
+		if p.is('p', ":") {
+			p.next()
+			sel.alias, sel.name = sel.name, p.name()
+		}
+		if p.is('p', "(") {
+			p.next()
+			sel.arguments = map[string]interface{}{}
+			for !p.is('p', ")") {
+				name := p.name()
+				p.expect(":")
+				sel.arguments[name] = p.value(false)
+			}
+			p.next()
+		}
+		if p.is('p', "@") {
+			p.fail("directives are not supported")
+		}
+		if p.is('p', "{") {
+			sel.selections = p.selectionSet()
+		}
+		selections = append(selections, sel)
+	}
+	p.next()
+	return selections
+}
+
+// value parse one value, constant is true for the default of the variables
+func (p *gqlParser) value(constant bool) interface{} {
+	token := p.token
+	switch token.kind {
+	case 'i':
+		p.next()
+		i, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			p.fail("invalid int %s", token.value)
+		}
+		return float64(i)
+	case 'f':
+		p.next()
+		f, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			p.fail("invalid float %s", token.value)
+		}
+		return f
+	case 's':
+		p.next()
+		return token.value
+	case 'n':
+		p.next()
+		switch token.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(token.value)
+	}
+	switch token.value {
+	case "$":
+		if constant {
+			p.fail("unexpected variable")
+		}
+		p.next()
+		return gqlVariable(p.name())
+	case "[":
+		p.next()
+		list := []interface{}{}
+		for !p.is('p', "]") {
+			if p.token.kind == 0 {
+				p.fail("unexpected end")
+			}
+			list = append(list, p.value(constant))
+		}
+		p.next()
+		return list
+	case "{":
+		p.next()
+		object := map[string]interface{}{}
+		for !p.is('p', "}") {
+			name := p.name()
+			p.expect(":")
+			object[name] = p.value(constant)
+		}
+		p.next()
+		return object
+	}
+	p.fail("unexpected %q", token.value)
+	return nil
+}
+
+// next read the next token, it skips the spaces, the commas and the comments
+func (p *gqlParser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.token = gqlToken{pos: start}
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.token = gqlToken{kind: 'n', value: p.src[start:p.pos], pos: start}
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.pos++
+		kind := byte('i')
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' || ((c == '+' || c == '-') && kind == 'f') {
+				kind = 'f'
+			} else if c < '0' || c > '9' {
+				break
+			}
+			p.pos++
+		}
+		p.token = gqlToken{kind: kind, value: p.src[start:p.pos], pos: start}
+	case c == '"':
+		p.token = gqlToken{kind: 's', value: p.str(), pos: start}
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.token = gqlToken{kind: 'p', value: "...", pos: start}
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.pos++
+		p.token = gqlToken{kind: 'p', value: string(c), pos: start}
+	default:
+		p.token = gqlToken{kind: 'p', value: string(c), pos: start}
+		p.fail("unexpected character %q", c)
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// str read the string at pos with the escapes of GraphQL
+func (p *gqlParser) str() string {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated string")
+		}
+		value := p.src[p.pos+3 : p.pos+3+end]
+		p.pos += end + 6
+		return value
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			return b.String()
+		}
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteRune(r)
+			p.pos += size
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		switch e := p.src[p.pos]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if p.pos+5 > len(p.src) {
+				p.fail("invalid escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos+1:p.pos+5], 16, 32)
+			if err != nil {
+				p.fail("invalid escape")
+			}
+			b.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			b.WriteByte(e)
+		}
+		p.pos++
+	}
+}
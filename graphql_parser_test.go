@@ -0,0 +1,67 @@
+package gormcrud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# the notes
+		query Notes($id: ID! = 1, $tags: [String]) {
+			alias: note(id: $id, filter: {title_like: "a\u00e9\n", words: [1, -2.5e1, true, null, ENUM]}) {
+				id, ...fields
+				... on Note { title }
+				... { words }
+			}
+		}
+		fragment fields on Note { title }
+		mutation { saveNote(input: {title: """raw "x" \n"""}) { id } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 2 || doc.fragments["fields"].on != "Note" {
+		t.Fatalf("document %+v", doc)
+	}
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Notes" || !reflect.DeepEqual(op.variables, map[string]interface{}{"id": 1.0, "tags": nil}) {
+		t.Fatalf("operation %+v", op)
+	}
+	sel := op.selections[0]
+	if sel.key() != "alias" || sel.name != "note" || sel.arguments["id"] != gqlVariable("id") {
+		t.Fatalf("selection %+v", sel)
+	}
+	filter := map[string]interface{}{"title_like": "a\u00e9\n", "words": []interface{}{1.0, -25.0, true, nil, gqlEnum("ENUM")}}
+	if !reflect.DeepEqual(sel.arguments["filter"], filter) {
+		t.Fatalf("arguments %#v", sel.arguments["filter"])
+	}
+	if len(sel.selections) != 4 || sel.selections[1].spread != "fields" || sel.selections[2].on != "Note" || !sel.selections[3].inline {
+		t.Fatalf("selections %+v", sel.selections)
+	}
+	mutation := doc.operations[1]
+	if mutation.kind != "mutation" || mutation.selections[0].arguments["input"].(map[string]interface{})["title"] != `raw "x" \n` {
+		t.Fatalf("mutation %+v", mutation)
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"{ note(id: 1) ",
+		"{ note(id: 1) { id } ",
+		`{ note(id: "a) { id } }`,
+		`{ note(id: "\u12") { id } }`,
+		"{ note @include(if: true) { id } }",
+		"{ note(id: %) { id } }",
+		"query($id: ID = $other) { note { id } }",
+		"subscription { note { id } }",
+		"fragment f { id }",
+		"{ note(ids: [1, 2) { id } }",
+	} {
+		if _, err := parseGraphQL(src); err == nil {
+			t.Errorf("%q parsed", src)
+		} else if _, ok := err.(gqlSyntaxError); !ok {
+			t.Errorf("%q: %T %v", src, err, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package gormcrud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Project has the tasks of its tenant
+type Project struct {
+	ID       uint   `gorm:"primary_key" json:"id"`
+	TenantID uint   `json:"tenant_id"`
+	Name     string `json:"name"`
+	Tasks    []Task `json:"tasks"`
+}
+
+type Task struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	TenantID  uint   `json:"tenant_id"`
+	ProjectID uint   `json:"project_id"`
+	Title     string `json:"title"`
+	Secret    string `json:"secret" crud:"writeonly"`
+}
+
+// gqlResult is the response of the endpoint in the tests
+type gqlResult struct {
+	Data   map[string]json.RawMessage
+	Errors []gqlError
+}
+
+func newGraphQLMux(t *testing.T) (*gorm.DB, *mux.Router, *GraphQL) {
+	db := openTestDB(t, &Project{}, &Task{})
+	gql := NewGraphQL()
+	r := mux.NewRouter()
+	private := func(ctx context.Context, op Operation, entity interface{}) error {
+		if tasks, ok := entity.(*[]Task); ok {
+			for _, task := range *tasks {
+				if task.Title == "private" {
+					return errors.New("private task")
+				}
+			}
+		}
+		return nil
+	}
+	MapMux(r, db).TenantBy(TenantFromHeader("X-Tenant")).
+		NewMap("/project", Project{}, []Project{}).GraphQL(gql).
+		NewMap("/task", Task{}, []Task{}).Authorize(private).GraphQL(gql).
+		NewMap("/note", Note{}, []Note{}).GraphQL(gql).
+		NewMap("/tag", Tag{}, []Tag{}).GraphQL(gql).
+		GraphQLAt("/graphql", gql)
+	return db, r, gql
+}
+
+func queryGraphQL(t *testing.T, r http.Handler, tenant string, query string) gqlResult {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	w := serve(r, "POST", "/graphql", string(body), "X-Tenant", tenant)
+	expectCode(t, w, http.StatusOK)
+	var result gqlResult
+	decode(t, w, &result)
+	return result
+}
+
+func TestGraphQL(t *testing.T) {
+	db, r, _ := newGraphQLMux(t)
+	db.Create(&Note{Title: "a", Tags: []Tag{{Name: "x"}, {Name: "y"}}})
+	db.Create(&Note{Title: "b"})
+
+	result := queryGraphQL(t, r, "1", `query Notes($id: ID!) { note(id: 1) { title tags { name } }
+		first: noteList(limit: 1) { id __typename } }`)
+	if len(result.Errors) != 0 {
+		t.Fatalf("errors %+v", result.Errors)
+	}
+	if string(result.Data["note"]) != `{"title":"a","tags":[{"name":"x"},{"name":"y"}]}` {
+		t.Fatalf("note %s", result.Data["note"])
+	}
+	if string(result.Data["first"]) != `[{"id":"2","__typename":"Note"}]` {
+		t.Fatalf("list %s", result.Data["first"])
+	}
+
+	result = queryGraphQL(t, r, "1", `mutation { saveNote(input: {title: "c"}) { id title }
+		linkNote(id: 3, field: "tags", ids: [1]) { tags { id } } }`)
+	if len(result.Errors) != 0 || string(result.Data["linkNote"]) != `{"tags":[{"id":"1"}]}` {
+		t.Fatalf("mutation %+v %s", result.Errors, result.Data["linkNote"])
+	}
+	result = queryGraphQL(t, r, "1", `{ other }`)
+	if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != float64(http.StatusBadRequest) {
+		t.Fatalf("unknown field %+v", result.Errors)
+	}
+}
+
+func TestGraphQLRelationsScoped(t *testing.T) {
+	db, r, _ := newGraphQLMux(t)
+	db.Create(&Project{TenantID: 1, Name: "p"})
+	db.Create(&Task{TenantID: 1, ProjectID: 1, Title: "t", Secret: "s"})
+	// the task of other tenant with the project of tenant 1
+	db.Create(&Task{TenantID: 2, ProjectID: 1, Title: "leak"})
+
+	for _, query := range []string{`{ project(id: 1) { tasks { title secret } } }`, `{ projectList { tasks { title secret } } }`} {
+		result := queryGraphQL(t, r, "1", query)
+		data := string(result.Data["project"]) + string(result.Data["projectList"])
+		if len(result.Errors) != 0 || strings.Contains(data, "leak") || !strings.Contains(data, `"title":"t"`) {
+			t.Fatalf("%s: %+v %s", query, result.Errors, data)
+		}
+		if !strings.Contains(data, `"secret":null`) {
+			t.Fatalf("writeonly field of the relation %s", data)
+		}
+	}
+	if result := queryGraphQL(t, r, "2", `{ project(id: 1) { name } }`); string(result.Data["project"]) != "null" {
+		t.Fatalf("project of other tenant %s", result.Data["project"])
+	}
+}
+
+func TestGraphQLRelationsAuthorized(t *testing.T) {
+	db, r, _ := newGraphQLMux(t)
+	db.Create(&Project{TenantID: 1, Name: "p"})
+	db.Create(&Task{TenantID: 1, ProjectID: 1, Title: "private"})
+
+	result := queryGraphQL(t, r, "1", `{ project(id: 1) { tasks { title } } }`)
+	if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != float64(http.StatusForbidden) || string(result.Data["project"]) != "null" {
+		t.Fatalf("relation not authorized %+v %s", result.Errors, result.Data["project"])
+	}
+	if result = queryGraphQL(t, r, "1", `{ projectList { name } }`); len(result.Errors) != 0 {
+		t.Fatalf("relation not selected %+v", result.Errors)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	db, r, gql := newGraphQLMux(t)
+	for i := 0; i < 3; i++ {
+		db.Create(&Note{Title: "n"})
+	}
+	gql.MaxLimit = 2
+	result := queryGraphQL(t, r, "1", `{ noteList(limit: 10) { id } }`)
+	if string(result.Data["noteList"]) != `[{"id":"3"},{"id":"2"}]` {
+		t.Fatalf("limit %s", result.Data["noteList"])
+	}
+	if result = queryGraphQL(t, r, "1", `{ noteList(page: 2) { id } }`); string(result.Data["noteList"]) != `[{"id":"1"}]` {
+		t.Fatalf("page %s", result.Data["noteList"])
+	}
+
+	gql.MaxDepth = 1
+	if result = queryGraphQL(t, r, "1", `{ projectList { tasks { id } } }`); len(result.Errors) != 0 {
+		t.Fatalf("one level %+v", result.Errors)
+	}
+	gql.MaxDepth = 0
+	result = queryGraphQL(t, r, "1", `{ project(id: 1) { tasks { id } } }`)
+	if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != float64(http.StatusBadRequest) {
+		t.Fatalf("depth %+v", result.Errors)
+	}
+}
+
+func TestGraphQLServe(t *testing.T) {
+	_, r, _ := newGraphQLMux(t)
+	w := serve(r, "GET", "/graphql", "")
+	expectCode(t, w, http.StatusOK)
+	for _, want := range []string{"type Note {", "  tags: [Tag]", "noteList(filter: NoteFilter, page: Int, limit: Int): [Note]", "  title_like: String"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("schema without %q:\n%s", want, w.Body.String())
+		}
+	}
+	w = serve(r, "GET", "/graphql?query="+strings.Replace("mutation { deleteNote(id: 1) { id } }", " ", "%20", -1), "", "X-Tenant", "1")
+	if !strings.Contains(w.Body.String(), "mutation is not allowed with GET") {
+		t.Fatalf("mutation with GET %s", w.Body.String())
+	}
+	w = serve(r, "POST", "/graphql", "{ noteList { id } }", "Content-Type", "application/graphql", "X-Tenant", "1")
+	if strings.TrimSpace(w.Body.String()) != `{"data":{"noteList":[]}}` {
+		t.Fatalf("application/graphql %s", w.Body.String())
+	}
+	expectCode(t, serve(r, "POST", "/graphql", "x", "X-Tenant", "1"), http.StatusBadRequest)
+}
+
+func TestFilterQuery(t *testing.T) {
+	query, err := filterQuery(map[string]interface{}{"title_like": "a%", "id_in": []interface{}{1.0, 2.0}, "words": nil, "other_x": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("title[like]") != "a%" || query.Get("id[in]") != "1,2" || query.Get("words[eq]") != "null" || query.Get("other_x[eq]") != "v" {
+		t.Fatalf("query %v", query)
+	}
+	if _, err := filterQuery("x"); err == nil {
+		t.Fatal("filter not object")
+	}
+}
@@ -0,0 +1,182 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/biezhi/gorm-paginator/pagination"
+	"github.com/jinzhu/gorm"
+)
+
+// The hypermedia mode of the mapper answer Get, All and Page in HAL
+// (application/hal+json). The entity has "_links" with self, collection and
+// the templates of the urls of LinkUrl to link and unlink each association
+// ("link:tags", "unlink:tags") and the relations preloaded are in
+// "_embedded". The lists have the entities in "_embedded" with the name of
+// the table and the page has the links self, first, last, prev and next.
+
+const halKey = "gormcrud:hal"
+
+const halMediaType = "application/hal+json"
+
+// halLink is one link of _links
+type halLink struct {
+	Href      string `json:"href"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+// withHAL set the path of the resource for the hypermedia mode in the
+// settings of db
+func withHAL(db *gorm.DB, base string) *gorm.DB {
+	return db.Set(halKey, base)
+}
+
+// isHAL return true if the resource is in the hypermedia mode
+func isHAL(db *gorm.DB) bool {
+	_, ok := db.Get(halKey)
+	return ok
+}
+
+// halResource return the object of HAL of the json of one entity of the
+// struct t with the fields that the roles can read, the related entities have
+// not _links (base is empty)
+func halResource(db *gorm.DB, t reflect.Type, data json.RawMessage, base string, roles []string) *Object {
+	fields := map[string]json.RawMessage{}
+	json.Unmarshal(data, &fields)
+	props := resourceProperties(db, baseType(t), roles)
+	res := &Object{}
+	if base != "" {
+		id := ""
+		for _, prop := range props {
+			if prop.key {
+				id = jsonID(fields[prop.name])
+			}
+		}
+		self := base + "/" + url.PathEscape(id)
+		links := &Object{}
+		links.Set("self", halLink{Href: self})
+		links.Set("collection", halLink{Href: base})
+		for _, prop := range props {
+			if prop.relation && isList(prop.typ) {
+				param := strings.ToLower(prop.goName)
+				links.Set("link:"+prop.name, halLink{Href: self + "/link?" + param + "={id}", Templated: true})
+				links.Set("unlink:"+prop.name, halLink{Href: self + "/unlink?" + param + "={id}", Templated: true})
+			}
+		}
+		res.Set("_links", links)
+	}
+	embedded := &Object{}
+	for _, prop := range props {
+		raw, ok := fields[prop.name]
+		if !ok {
+			continue
+		}
+		if !prop.relation {
+			res.Set(prop.name, raw)
+			continue
+		}
+		if isList(prop.typ) {
+			var items []json.RawMessage
+			json.Unmarshal(raw, &items)
+			related := []*Object{}
+			for _, item := range items {
+				related = append(related, halResource(db, prop.typ, item, "", roles))
+			}
+			embedded.Set(prop.name, related)
+		} else if string(raw) != "null" {
+			embedded.Set(prop.name, halResource(db, prop.typ, raw, "", roles))
+		}
+	}
+	if len(*embedded) > 0 {
+		res.Set("_embedded", embedded)
+	}
+	return res
+}
+
+// halEntity return the object of HAL of the entity for the request
+func halEntity(db *gorm.DB, r *http.Request, entity interface{}) (*Object, error) {
+	base, _ := db.Get(halKey)
+	data, err := json.Marshal(publicValue(r.Context(), entity))
+	if err != nil {
+		return nil, err
+	}
+	return halResource(db, reflect.TypeOf(entity), data, base.(string), Roles(r.Context())), nil
+}
+
+// halList return the object of HAL of the slice of the entities with the
+// links, the entities are in _embedded with the name of the table
+func halList(db *gorm.DB, r *http.Request, entities interface{}, links *Object) (*Object, error) {
+	base, _ := db.Get(halKey)
+	var items []json.RawMessage
+	data, err := json.Marshal(publicValue(r.Context(), entities))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	t, roles := reflect.TypeOf(entities), Roles(r.Context())
+	resources := []*Object{}
+	for _, item := range items {
+		resources = append(resources, halResource(db, t, item, base.(string), roles))
+	}
+	embedded := &Object{}
+	embedded.Set(db.NewScope(entities).TableName(), resources)
+	res := &Object{}
+	res.Set("_links", links)
+	res.Set("_embedded", embedded)
+	return res, nil
+}
+
+// writeHALList write the list of All
+func writeHALList(w http.ResponseWriter, r *http.Request, db *gorm.DB, entities interface{}) {
+	links := &Object{}
+	links.Set("self", halLink{Href: r.URL.RequestURI()})
+	res, err := halList(db, r, entities, links)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", halMediaType)
+	json.NewEncoder(w).Encode(res)
+}
+
+// writeHALPage write the page of Page with the links of the pages and the
+// counters of the pagination
+func writeHALPage(w http.ResponseWriter, r *http.Request, db *gorm.DB, entities interface{}, page *pagination.Paginator) {
+	pageLink := func(n int) halLink {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		q.Set("limit", strconv.Itoa(page.Limit))
+		return halLink{Href: r.URL.Path + "?" + q.Encode()}
+	}
+	links := &Object{}
+	links.Set("self", pageLink(page.Page))
+	links.Set("first", pageLink(1))
+	if page.Page > 1 {
+		links.Set("prev", pageLink(page.PrevPage))
+	}
+	if page.Page < page.TotalPage {
+		links.Set("next", pageLink(page.NextPage))
+	}
+	last := page.TotalPage
+	if last < 1 {
+		last = 1
+	}
+	links.Set("last", pageLink(last))
+	res, err := halList(db, r, entities, links)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res.Set("total_record", page.TotalRecord)
+	res.Set("total_page", page.TotalPage)
+	res.Set("page", page.Page)
+	res.Set("limit", page.Limit)
+	w.Header().Set("Content-Type", halMediaType)
+	json.NewEncoder(w).Encode(res)
+}
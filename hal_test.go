@@ -0,0 +1,173 @@
+package gormcrud
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// halBroken is one value that fails to encode in json
+type halBroken string
+
+func (halBroken) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("broken value")
+}
+
+type Broken struct {
+	ID    uint      `gorm:"primary_key" json:"id"`
+	Value halBroken `json:"value"`
+}
+
+// halResponse is the object of HAL decoded by the tests
+type halResponse struct {
+	Links       map[string]halLink       `json:"_links"`
+	Embedded    map[string][]halResponse `json:"_embedded"`
+	Title       string                   `json:"title"`
+	Login       string                   `json:"login"`
+	TotalRecord int                      `json:"total_record"`
+	TotalPage   int                      `json:"total_page"`
+}
+
+func newHALMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{}, &Broken{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).HAL().Full().
+		NewMap("/member", Member{}, []Member{}).HAL().Full().
+		NewMap("/broken", Broken{}, []Broken{}).HAL().Full()
+	return db, r
+}
+
+// getHAL run the request and decode the object of HAL
+func getHAL(t *testing.T, h http.Handler, url string) halResponse {
+	t.Helper()
+	w := serve(h, "GET", url, "")
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("Content-Type") != halMediaType {
+		t.Fatalf("content type %v", w.Header())
+	}
+	var res halResponse
+	decode(t, w, &res)
+	return res
+}
+
+func TestHAL(t *testing.T) {
+	db, r := newHALMux(t)
+	db.Create(&Note{Title: "a", Tags: []Tag{{Name: "x"}, {Name: "y"}}})
+
+	res := getHAL(t, r, "/note/1")
+	if res.Title != "a" || res.Links["self"].Href != "/note/1" || res.Links["collection"].Href != "/note" {
+		t.Fatalf("entity %+v", res)
+	}
+	if link := res.Links["link:tags"]; link.Href != "/note/1/link?tags={id}" || !link.Templated {
+		t.Fatalf("link of tags %+v", link)
+	}
+	if link := res.Links["unlink:tags"]; link.Href != "/note/1/unlink?tags={id}" || !link.Templated {
+		t.Fatalf("unlink of tags %+v", link)
+	}
+	tags := res.Embedded["tags"]
+	if len(tags) != 2 || tags[1].Links != nil {
+		t.Fatalf("embedded tags %+v", res.Embedded)
+	}
+
+	expectCode(t, serve(r, "GET", "/note/9", ""), http.StatusNotFound)
+}
+
+func TestHALList(t *testing.T) {
+	db, r := newHALMux(t)
+
+	res := getHAL(t, r, "/note")
+	if notes, ok := res.Embedded["note"]; !ok || len(notes) != 0 || res.Links["self"].Href != "/note" {
+		t.Fatalf("empty list %+v", res)
+	}
+	for _, title := range []string{"a", "b", "c"} {
+		db.Create(&Note{Title: title})
+	}
+	res = getHAL(t, r, "/note?x=1")
+	if notes := res.Embedded["note"]; len(notes) != 3 || notes[2].Links["self"].Href != "/note/3" || res.Links["self"].Href != "/note?x=1" {
+		t.Fatalf("list %+v", res)
+	}
+
+	res = getHAL(t, r, "/note.page?page=1&limit=2")
+	if len(res.Embedded["note"]) != 2 || res.TotalRecord != 3 || res.TotalPage != 2 {
+		t.Fatalf("page %+v", res)
+	}
+	for name, href := range map[string]string{
+		"self":  "/note.page?limit=2&page=1",
+		"first": "/note.page?limit=2&page=1",
+		"next":  "/note.page?limit=2&page=2",
+		"last":  "/note.page?limit=2&page=2",
+	} {
+		if res.Links[name].Href != href {
+			t.Errorf("link %s %+v", name, res.Links[name])
+		}
+	}
+	if _, ok := res.Links["prev"]; ok {
+		t.Errorf("prev of the first page %+v", res.Links)
+	}
+	res = getHAL(t, r, "/note.page?page=2&limit=2")
+	if _, ok := res.Links["next"]; ok || res.Links["prev"].Href != "/note.page?limit=2&page=1" || len(res.Embedded["note"]) != 1 {
+		t.Fatalf("last page %+v", res)
+	}
+}
+
+func TestHALFieldRules(t *testing.T) {
+	db, r := newHALMux(t)
+	db.Create(&Member{Login: "x", Password: "secret", Note: "n", Name: "y"})
+
+	w := serve(withRoles(r, "guest"), "GET", "/member/1", "")
+	expectCode(t, w, http.StatusOK)
+	var member map[string]interface{}
+	decode(t, w, &member)
+	if _, ok := member["password"]; ok {
+		t.Fatalf("writeonly field %v", member)
+	}
+	if _, ok := member["note"]; ok || member["name"] != "y" {
+		t.Fatalf("hidden field %v", member)
+	}
+	res := getHAL(t, withRoles(r, "guest"), "/member")
+	if len(res.Embedded["member"]) != 1 || res.Embedded["member"][0].Login != "x" {
+		t.Fatalf("list %+v", res)
+	}
+}
+
+func TestHALRoles(t *testing.T) {
+	db := openTestDB(t, &Vault{})
+	db.Create(&Vault{Name: "v", Code: "c", Tags: []Tag{{Name: "x"}}})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/vault", Vault{}, []Vault{}).HAL().Full()
+
+	// the fields and the relations of the admin are in the object of HAL
+	var vault map[string]interface{}
+	decode(t, serve(withRoles(r, "admin"), "GET", "/vault/1", ""), &vault)
+	if embedded, _ := vault["_embedded"].(map[string]interface{}); vault["code"] != "c" || embedded["tags"] == nil {
+		t.Fatalf("vault for the admin %v", vault)
+	}
+	res := getHAL(t, withRoles(r, "admin"), "/vault")
+	if len(res.Embedded["vault"]) != 1 || len(res.Embedded["vault"][0].Embedded["tags"]) != 1 {
+		t.Fatalf("list for the admin %+v", res)
+	}
+
+	vault = nil
+	decode(t, serve(withRoles(r, "guest"), "GET", "/vault/1", ""), &vault)
+	if _, ok := vault["code"]; ok || vault["_embedded"] != nil || vault["name"] != "v" {
+		t.Fatalf("vault for the guest %v", vault)
+	}
+}
+
+func TestHALEncodingError(t *testing.T) {
+	db, r := newHALMux(t)
+	db.Create(&Broken{Value: "x"})
+	for _, url := range []string{"/broken/1", "/broken", "/broken.page?page=1&limit=2"} {
+		w := serve(r, "GET", url, "")
+		expectCode(t, w, http.StatusInternalServerError)
+		var e ErrorCrud
+		decode(t, w, &e)
+		if e.Code != http.StatusInternalServerError || e.Message == "" {
+			t.Errorf("%s: %s", url, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package gormcrud
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The hooks are detected on the entity like ValidateSave and ValidateDelete.
+// They run inside the transaction of the operation and an error returned by
+// a hook abort the operation (use ErrorCrud to choose the status code).
+// The methods have the prefix Crud because gorm call by itself the methods
+// BeforeCreate, AfterCreate, ... of the model.
+
+// BeforeCreate is interface for hook before insert entity
+type BeforeCreate interface {
+	CrudBeforeCreate(ctx context.Context, db *gorm.DB) error
+}
+
+// AfterCreate is interface for hook after insert entity
+type AfterCreate interface {
+	CrudAfterCreate(ctx context.Context, db *gorm.DB) error
+}
+
+// BeforeUpdate is interface for hook before update entity
+type BeforeUpdate interface {
+	CrudBeforeUpdate(ctx context.Context, db *gorm.DB) error
+}
+
+// AfterUpdate is interface for hook after update entity
+type AfterUpdate interface {
+	CrudAfterUpdate(ctx context.Context, db *gorm.DB) error
+}
+
+// BeforeDelete is interface for hook before delete entity
+type BeforeDelete interface {
+	CrudBeforeDelete(ctx context.Context, db *gorm.DB) error
+}
+
+// AfterDelete is interface for hook after delete entity
+type AfterDelete interface {
+	CrudAfterDelete(ctx context.Context, db *gorm.DB) error
+}
+
+// AfterRead is interface for hook after read entity (Get, All and Page)
+type AfterRead interface {
+	CrudAfterRead(ctx context.Context, db *gorm.DB) error
+}
+
+// isCreate return true if Save is going to insert the entity
+func isCreate(db *gorm.DB, entity interface{}) bool {
+	scope := db.NewScope(entity)
+	if scope.PrimaryKeyZero() {
+		return true
+	}
+	exist := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+	return db.Unscoped().Where(scope.PrimaryKey()+" = ?", scope.PrimaryKeyValue()).First(exist).RowsAffected == 0
+}
+
+// beforeSave run BeforeCreate or BeforeUpdate
+func beforeSave(ctx context.Context, db *gorm.DB, entity interface{}, create bool) error {
+	if create {
+		if hook, ok := entity.(BeforeCreate); ok {
+			return hook.CrudBeforeCreate(ctx, db)
+		}
+		return nil
+	}
+	if hook, ok := entity.(BeforeUpdate); ok {
+		return hook.CrudBeforeUpdate(ctx, db)
+	}
+	return nil
+}
+
+// afterSave run AfterCreate or AfterUpdate
+func afterSave(ctx context.Context, db *gorm.DB, entity interface{}, create bool) error {
+	if create {
+		if hook, ok := entity.(AfterCreate); ok {
+			return hook.CrudAfterCreate(ctx, db)
+		}
+		return nil
+	}
+	if hook, ok := entity.(AfterUpdate); ok {
+		return hook.CrudAfterUpdate(ctx, db)
+	}
+	return nil
+}
+
+// beforeDelete run BeforeDelete
+func beforeDelete(ctx context.Context, db *gorm.DB, entity interface{}) error {
+	if hook, ok := entity.(BeforeDelete); ok {
+		return hook.CrudBeforeDelete(ctx, db)
+	}
+	return nil
+}
+
+// afterDelete run AfterDelete
+func afterDelete(ctx context.Context, db *gorm.DB, entity interface{}) error {
+	if hook, ok := entity.(AfterDelete); ok {
+		return hook.CrudAfterDelete(ctx, db)
+	}
+	return nil
+}
+
+// afterRead run AfterRead on the entity or on each entity of the slice
+func afterRead(ctx context.Context, db *gorm.DB, entity interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if v.Kind() != reflect.Slice {
+		if hook, ok := entity.(AfterRead); ok {
+			return hook.CrudAfterRead(ctx, db)
+		}
+		return nil
+	}
+	for i := 0; i < v.Len(); i++ {
+		if hook, ok := v.Index(i).Addr().Interface().(AfterRead); ok {
+			if err := hook.CrudAfterRead(ctx, db); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
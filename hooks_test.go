@@ -0,0 +1,123 @@
+package gormcrud
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Hooked record the hooks called in hookCalls, the name "reject" fails in
+// BeforeCreate and "fail" fails in AfterUpdate
+type Hooked struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Name  string `json:"name"`
+	Upper string `json:"upper" gorm:"-"`
+}
+
+var hookCalls []string
+
+func (h *Hooked) CrudBeforeCreate(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "before create")
+	if h.Name == "reject" {
+		return ErrorCrud{Message: "rejected", Code: http.StatusConflict}
+	}
+	return nil
+}
+
+func (h *Hooked) CrudAfterCreate(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "after create")
+	return nil
+}
+
+func (h *Hooked) CrudBeforeUpdate(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "before update")
+	return nil
+}
+
+func (h *Hooked) CrudAfterUpdate(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "after update")
+	if h.Name == "fail" {
+		return ErrorCrud{Message: "failed", Code: http.StatusUnprocessableEntity}
+	}
+	return nil
+}
+
+func (h *Hooked) CrudBeforeDelete(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "before delete")
+	return nil
+}
+
+func (h *Hooked) CrudAfterDelete(ctx context.Context, db *gorm.DB) error {
+	hookCalls = append(hookCalls, "after delete")
+	return nil
+}
+
+func (h *Hooked) CrudAfterRead(ctx context.Context, db *gorm.DB) error {
+	h.Upper = "read " + h.Name
+	return nil
+}
+
+func newHookedMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	hookCalls = nil
+	db := openTestDB(t, &Hooked{})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/hooked", Hooked{}, []Hooked{}).Full()
+	return db, r
+}
+
+func expectHooks(t *testing.T, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(hookCalls, want) {
+		t.Fatalf("hooks %v, want %v", hookCalls, want)
+	}
+	hookCalls = nil
+}
+
+func TestHooksSaveDelete(t *testing.T) {
+	_, r := newHookedMux(t)
+	expectCode(t, serve(r, "POST", "/hooked", `{"name":"a"}`), http.StatusOK)
+	expectHooks(t, "before create", "after create")
+	expectCode(t, serve(r, "POST", "/hooked", `{"id":1,"name":"b"}`), http.StatusOK)
+	expectHooks(t, "before update", "after update")
+	expectCode(t, serve(r, "DELETE", "/hooked/1", ""), http.StatusOK)
+	expectHooks(t, "before delete", "after delete")
+}
+
+func TestHooksAbort(t *testing.T) {
+	db, r := newHookedMux(t)
+	expectCode(t, serve(r, "POST", "/hooked", `{"name":"reject"}`), http.StatusConflict)
+	expectHooks(t, "before create")
+
+	expectCode(t, serve(r, "POST", "/hooked", `{"name":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/hooked", `{"id":1,"name":"fail"}`), http.StatusUnprocessableEntity)
+	var hooked Hooked
+	db.First(&hooked, 1)
+	if hooked.Name != "a" {
+		t.Fatalf("update not rolled back: %q", hooked.Name)
+	}
+	var count int
+	db.Model(&Hooked{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d rows", count)
+	}
+}
+
+func TestHooksAfterRead(t *testing.T) {
+	_, r := newHookedMux(t)
+	expectCode(t, serve(r, "POST", "/hooked", `{"name":"a"}`), http.StatusOK)
+
+	var hooked Hooked
+	decode(t, serve(r, "GET", "/hooked/1", ""), &hooked)
+	if hooked.Upper != "read a" {
+		t.Fatalf("Get without AfterRead: %+v", hooked)
+	}
+	var list []Hooked
+	decode(t, serve(r, "GET", "/hooked", ""), &list)
+	if len(list) != 1 || list[0].Upper != "read a" {
+		t.Fatalf("All without AfterRead: %+v", list)
+	}
+}
@@ -0,0 +1,518 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The JSON:API format of the mapper render the entities as resource objects
+// {"data": {"type", "id", "attributes", "relationships"}} with the relations
+// preloaded in "included" (all of them or the paths of ?include=tags). The
+// list has the filter of the lists in filter[title]=a and
+// filter[id][gt]=3 and the pagination in page[number] and page[size]. The
+// relations are changed with the relationship endpoints
+// /note/1/relationships/tags (POST link and DELETE unlink) and the errors
+// are {"errors": [{"status", "title"}]}.
+
+const jsonapiMediaType = "application/vnd.api+json"
+
+// jsonapiIdentifier is the identifier of one resource
+type jsonapiIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// jsonapiRelationship is one relationship of the resource, data is
+// *jsonapiIdentifier or []jsonapiIdentifier
+type jsonapiRelationship struct {
+	Links map[string]string `json:"links,omitempty"`
+	Data  interface{}       `json:"data"`
+}
+
+// jsonapiResource is one resource object
+type jsonapiResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    *Object                        `json:"attributes,omitempty"`
+	Relationships map[string]jsonapiRelationship `json:"relationships,omitempty"`
+	Links         map[string]string              `json:"links,omitempty"`
+}
+
+// jsonapiDocument is the top level document of the responses
+type jsonapiDocument struct {
+	Data     interface{}        `json:"data"`
+	Included []*jsonapiResource `json:"included,omitempty"`
+	Meta     interface{}        `json:"meta,omitempty"`
+	Links    map[string]string  `json:"links,omitempty"`
+}
+
+// jsonapiError is one error object
+type jsonapiError struct {
+	Status string      `json:"status"`
+	Title  string      `json:"title"`
+	Meta   interface{} `json:"meta,omitempty"`
+}
+
+// jsonAPI is the JSON:API format of one resource mapped on base
+type jsonAPI struct {
+	service *Service
+	base    string
+}
+
+// writeJSONAPI write the document with the status
+func writeJSONAPI(w http.ResponseWriter, status int, doc interface{}) {
+	w.Header().Set("Content-Type", jsonapiMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// writeJSONAPIError write the error as error object, meta is the meta of the
+// error or nil
+func writeJSONAPIError(w http.ResponseWriter, err error, meta interface{}) {
+	errCrud := toErrorCrud(err)
+	if errCrud.Code < 400 || errCrud.Code >= 600 {
+		errCrud.Code = http.StatusInternalServerError
+	}
+	if errCrud.Code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSONAPI(w, errCrud.Code, map[string][]jsonapiError{
+		"errors": {{Status: strconv.Itoa(errCrud.Code), Title: errCrud.Message, Meta: meta}},
+	})
+}
+
+// jsonapiType return the type of the resources of the struct t, it is the
+// name of the table
+func jsonapiType(db *gorm.DB, t reflect.Type) string {
+	return db.NewScope(reflect.New(baseType(t)).Interface()).TableName()
+}
+
+// jsonID return the text of the json value of one primary key
+func jsonID(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// isList return true if the type of the relation is a slice
+func isList(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Slice
+}
+
+// jsonapiEncoder build the resource objects of the json of the entities and
+// the included resources of their relations, with the attributes that the
+// roles can read
+type jsonapiEncoder struct {
+	db       *gorm.DB
+	base     string
+	root     reflect.Type
+	roles    []string
+	include  map[string]bool
+	included []*jsonapiResource
+	seen     map[string]bool
+}
+
+func newJSONAPIEncoder(db *gorm.DB, base string, root reflect.Type, roles []string, include map[string]bool) *jsonapiEncoder {
+	return &jsonapiEncoder{db: db, base: base, root: baseType(root), roles: roles, include: include, seen: map[string]bool{}}
+}
+
+// resource return the resource object of the json of one entity of the
+// struct t, path is the path of the relation from the primary data
+func (enc *jsonapiEncoder) resource(t reflect.Type, data json.RawMessage, path string) *jsonapiResource {
+	t = baseType(t)
+	fields := map[string]json.RawMessage{}
+	json.Unmarshal(data, &fields)
+	res := &jsonapiResource{Type: jsonapiType(enc.db, t), Attributes: &Object{}}
+	props := resourceProperties(enc.db, t, enc.roles)
+	for _, prop := range props {
+		if prop.key {
+			res.ID = jsonID(fields[prop.name])
+		}
+	}
+	for _, prop := range props {
+		raw, ok := fields[prop.name]
+		if !ok || prop.key {
+			continue
+		}
+		if !prop.relation {
+			res.Attributes.Set(prop.name, raw)
+			continue
+		}
+		childPath := strings.TrimPrefix(path+"."+prop.name, ".")
+		rel := jsonapiRelationship{}
+		if t == enc.root {
+			rel.Links = map[string]string{"self": enc.base + "/" + res.ID + "/relationships/" + prop.name}
+		}
+		if isList(prop.typ) {
+			var items []json.RawMessage
+			json.Unmarshal(raw, &items)
+			ids := []jsonapiIdentifier{}
+			for _, item := range items {
+				ids = append(ids, enc.add(prop.typ, item, childPath))
+			}
+			rel.Data = ids
+		} else if string(raw) != "null" {
+			id := enc.add(prop.typ, raw, childPath)
+			rel.Data = &id
+		}
+		if res.Relationships == nil {
+			res.Relationships = map[string]jsonapiRelationship{}
+		}
+		res.Relationships[prop.name] = rel
+	}
+	if t == enc.root {
+		res.Links = map[string]string{"self": enc.base + "/" + res.ID}
+	}
+	return res
+}
+
+// add add the related entity to the included resources if the path is
+// included and return its identifier
+func (enc *jsonapiEncoder) add(t reflect.Type, data json.RawMessage, path string) jsonapiIdentifier {
+	res := enc.resource(t, data, path)
+	id := jsonapiIdentifier{Type: res.Type, ID: res.ID}
+	if enc.include != nil && !enc.include[path] {
+		return id
+	}
+	if key := res.Type + "/" + res.ID; !enc.seen[key] {
+		enc.seen[key] = true
+		enc.included = append(enc.included, res)
+	}
+	return id
+}
+
+// document return the document of the entity or of the slice of the
+// entities, the primary resources are not in included
+func (enc *jsonapiEncoder) document(entity interface{}) (jsonapiDocument, error) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return jsonapiDocument{}, err
+	}
+	doc := jsonapiDocument{}
+	primary := map[string]bool{}
+	if isList(reflect.TypeOf(entity)) {
+		var items []json.RawMessage
+		json.Unmarshal(data, &items)
+		resources := []*jsonapiResource{}
+		for _, item := range items {
+			res := enc.resource(enc.root, item, "")
+			primary[res.Type+"/"+res.ID] = true
+			resources = append(resources, res)
+		}
+		doc.Data = resources
+	} else {
+		res := enc.resource(enc.root, data, "")
+		primary[res.Type+"/"+res.ID] = true
+		doc.Data = res
+	}
+	for _, res := range enc.included {
+		if !primary[res.Type+"/"+res.ID] {
+			doc.Included = append(doc.Included, res)
+		}
+	}
+	return doc, nil
+}
+
+// encoder return the encoder of the request with the paths of ?include
+func (api jsonAPI) encoder(r *http.Request) (*jsonapiEncoder, error) {
+	db, t := api.service.db, reflect.TypeOf(api.service.elem)
+	value := r.URL.Query().Get("include")
+	if value == "" {
+		return newJSONAPIEncoder(db, api.base, t, Roles(r.Context()), nil), nil
+	}
+	include := map[string]bool{}
+	for _, path := range strings.Split(value, ",") {
+		current := t
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			var found *resourceProperty
+			for _, prop := range resourceProperties(db, current, Roles(r.Context())) {
+				if prop.name == name && prop.relation {
+					found = &prop
+					break
+				}
+			}
+			if found == nil {
+				return nil, ErrorCrud{Message: "Invalid include " + path, Code: http.StatusBadRequest}
+			}
+			include[strings.Join(names[:i+1], ".")] = true
+			current = found.typ
+		}
+	}
+	return newJSONAPIEncoder(db, api.base, t, Roles(r.Context()), include), nil
+}
+
+// write write the document of the entity or of the slice of the entities
+func (api jsonAPI) write(w http.ResponseWriter, r *http.Request, status int, entity interface{}, meta interface{}, links map[string]string) {
+	enc, err := api.encoder(r)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	doc, err := enc.document(entity)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	doc.Meta, doc.Links = meta, links
+	writeJSONAPI(w, status, doc)
+}
+
+// listQuery return the filter of the lists of the params filter[...]
+func listQuery(r *http.Request) (url.Values, error) {
+	query := url.Values{}
+	for key, values := range r.URL.Query() {
+		switch {
+		case key == "sort":
+			return nil, ErrorCrud{Message: "The sort is not supported", Code: http.StatusBadRequest}
+		case strings.HasPrefix(key, "filter[") && strings.Contains(key, "]"):
+			end := strings.Index(key, "]")
+			query[key[len("filter["):end]+key[end+1:]] = values
+		}
+	}
+	return query, nil
+}
+
+// authenticate return the request with the principal of the service, the
+// encoding of the resources hides the fields with the roles of the principal
+func (api jsonAPI) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	r, _, err := api.service.request(r)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return r, false
+	}
+	return r, true
+}
+
+// List is the list of the resources, with page[number] or page[size] it is
+// one page with the meta of the pagination and the links of the pages
+func (api jsonAPI) List(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := api.authenticate(w, r)
+	if !ok {
+		return
+	}
+	query, err := listQuery(r)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	number, size := r.URL.Query().Get("page[number]"), r.URL.Query().Get("page[size]")
+	if number == "" && size == "" && !strings.HasSuffix(r.URL.Path, ".page") {
+		entities, err := api.service.All(r, query)
+		if err != nil {
+			writeJSONAPIError(w, err, nil)
+			return
+		}
+		api.write(w, r, http.StatusOK, entities, nil, map[string]string{"self": r.URL.RequestURI()})
+		return
+	}
+	page, _ := strconv.Atoi(number)
+	limit, _ := strconv.Atoi(size)
+	ret, err := api.service.Page(r, page, limit, query)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	pageLink := func(n int) string {
+		q := r.URL.Query()
+		q.Set("page[number]", strconv.Itoa(n))
+		q.Set("page[size]", strconv.Itoa(ret.Limit))
+		return r.URL.Path + "?" + q.Encode()
+	}
+	links := map[string]string{"self": pageLink(ret.Page), "first": pageLink(1), "last": pageLink(ret.TotalPage)}
+	if ret.Page > 1 {
+		links["prev"] = pageLink(ret.PrevPage)
+	}
+	if ret.Page < ret.TotalPage {
+		links["next"] = pageLink(ret.NextPage)
+	}
+	meta := map[string]int{"total_record": ret.TotalRecord, "total_page": ret.TotalPage, "page": ret.Page, "limit": ret.Limit}
+	api.write(w, r, http.StatusOK, ret.Records, meta, links)
+}
+
+// Get is the resource with the id
+func (api jsonAPI) Get(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := api.authenticate(w, r)
+	if !ok {
+		return
+	}
+	entity, err := api.service.Get(r, id)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	api.write(w, r, http.StatusOK, entity, nil, nil)
+}
+
+// Save create the resource (POST without id) or update the attributes of
+// the resource with the id (PATCH)
+func (api jsonAPI) Save(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := api.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var doc struct {
+		Data *struct {
+			Type          string                     `json:"type"`
+			ID            string                     `json:"id"`
+			Attributes    map[string]json.RawMessage `json:"attributes"`
+			Relationships map[string]json.RawMessage `json:"relationships"`
+		} `json:"data"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &doc); err != nil || doc.Data == nil {
+		writeJSONAPIError(w, ErrorCrud{Message: "Invalid Document", Code: http.StatusBadRequest}, nil)
+		return
+	}
+	resourceType := jsonapiType(api.service.db, reflect.TypeOf(api.service.elem))
+	if doc.Data.Type != resourceType || (id != "" && doc.Data.ID != id) {
+		writeJSONAPIError(w, ErrorCrud{Message: "Conflict of type or id", Code: http.StatusConflict}, nil)
+		return
+	}
+	if len(doc.Data.Relationships) > 0 {
+		writeJSONAPIError(w, ErrorCrud{Message: "The relationships are changed with the relationship endpoints", Code: http.StatusForbidden}, nil)
+		return
+	}
+	entity := api.service.New()
+	if id != "" {
+		// the attributes are applied on the row, the public entity has not
+		// the writeonly fields
+		var db *gorm.DB
+		var err error
+		if r, db, err = api.service.request(r); err != nil {
+			writeJSONAPIError(w, err, nil)
+			return
+		}
+		if ScopeTenant(db, entity).Where("id = ?", id).First(entity).RowsAffected == 0 {
+			writeJSONAPIError(w, ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}, nil)
+			return
+		}
+	}
+	fields := map[string]json.RawMessage{}
+	for _, prop := range writableProperties(api.service.db, reflect.TypeOf(api.service.elem)) {
+		if value, ok := doc.Data.Attributes[prop.name]; ok && !prop.key && !prop.relation {
+			fields[prop.name] = value
+		}
+		if prop.key && doc.Data.ID != "" {
+			fields[prop.name] = jsonapiKey(prop.typ, doc.Data.ID)
+		}
+	}
+	data, _ := json.Marshal(fields)
+	if err := json.Unmarshal(data, entity); err != nil {
+		writeJSONAPIError(w, ErrorCrud{Message: "Invalid Attributes: " + err.Error(), Code: http.StatusBadRequest}, nil)
+		return
+	}
+	saved, err := api.service.Save(r, entity)
+	if err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	status := http.StatusOK
+	if id == "" && doc.Data.ID == "" {
+		status = http.StatusCreated
+		w.Header().Set("Location", fmt.Sprint(api.base, "/", api.service.db.NewScope(saved).PrimaryKeyValue()))
+	}
+	api.write(w, r, status, saved, nil, nil)
+}
+
+// jsonapiKey return the json of the id for the primary key of the type t
+func jsonapiKey(t reflect.Type, id string) json.RawMessage {
+	switch baseType(t).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return json.RawMessage(id)
+		}
+	}
+	data, _ := json.Marshal(id)
+	return data
+}
+
+// Delete delete the resource with the id, ?purge=true delete it permanently
+func (api jsonAPI) Delete(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := api.service.Delete(r, id, r.URL.Query().Get("purge") == "true"); err != nil {
+		writeJSONAPIError(w, err, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Relationship is the relationship endpoint of the field: GET return the
+// identifiers, POST link and DELETE unlink the resources of the data
+func (api jsonAPI) Relationship(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := api.authenticate(w, r)
+	if !ok {
+		return
+	}
+	name := PathParam(r, "field")
+	var rel *resourceProperty
+	for _, prop := range resourceProperties(api.service.db, reflect.TypeOf(api.service.elem), Roles(r.Context())) {
+		if prop.name == name && prop.relation {
+			rel = &prop
+			break
+		}
+	}
+	if rel == nil {
+		writeJSONAPIError(w, ErrorCrud{Message: "Relationship Not Found", Code: http.StatusNotFound}, nil)
+		return
+	}
+	if r.Method == http.MethodGet {
+		entity, err := api.service.Get(r, id)
+		if err != nil {
+			writeJSONAPIError(w, err, nil)
+			return
+		}
+		doc, err := newJSONAPIEncoder(api.service.db, api.base, reflect.TypeOf(api.service.elem), Roles(r.Context()), map[string]bool{}).document(entity)
+		if err != nil {
+			writeJSONAPIError(w, err, nil)
+			return
+		}
+		relationship := doc.Data.(*jsonapiResource).Relationships[name]
+		writeJSONAPI(w, http.StatusOK, jsonapiDocument{Data: relationship.Data, Links: relationship.Links})
+		return
+	}
+	if !isList(rel.typ) {
+		writeJSONAPIError(w, ErrorCrud{Message: "Only the to-many relationships can be changed", Code: http.StatusForbidden}, nil)
+		return
+	}
+	var doc struct {
+		Data []jsonapiIdentifier `json:"data"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &doc); err != nil {
+		writeJSONAPIError(w, ErrorCrud{Message: "Invalid Document", Code: http.StatusBadRequest}, nil)
+		return
+	}
+	relatedType := jsonapiType(api.service.db, rel.typ)
+	links := url.Values{}
+	for _, identifier := range doc.Data {
+		if identifier.Type != relatedType {
+			writeJSONAPIError(w, ErrorCrud{Message: "Conflict of type " + identifier.Type, Code: http.StatusConflict}, nil)
+			return
+		}
+		links.Add(rel.goName, identifier.ID)
+	}
+	op := string(OpLink)
+	if r.Method == http.MethodDelete {
+		op = string(OpUnlink)
+	}
+	result, err := api.service.Link(r, id, op, links)
+	if err != nil {
+		writeJSONAPIError(w, err, result)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,292 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// jsonapiResponse is the document of the responses decoded by the tests
+type jsonapiResponse struct {
+	Data     json.RawMessage              `json:"data"`
+	Included []jsonapiResponseResource    `json:"included"`
+	Meta     map[string]int               `json:"meta"`
+	Links    map[string]string            `json:"links"`
+	Errors   []map[string]json.RawMessage `json:"errors"`
+}
+
+type jsonapiResponseResource struct {
+	Type          string                     `json:"type"`
+	ID            string                     `json:"id"`
+	Attributes    map[string]interface{}     `json:"attributes"`
+	Relationships map[string]json.RawMessage `json:"relationships"`
+	Links         map[string]string          `json:"links"`
+}
+
+func newJSONAPIMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).JSONAPI().Filterable().Full().
+		NewMap("/tag", Tag{}, []Tag{}).JSONAPI().Full().
+		NewMap("/member", Member{}, []Member{}).JSONAPI().Full()
+	return db, r
+}
+
+// serveJSONAPI run the request and decode the document, it checks the
+// status and the media type
+func serveJSONAPI(t *testing.T, h http.Handler, method string, url string, body string, code int) jsonapiResponse {
+	t.Helper()
+	w := serve(h, method, url, body, "Content-Type", jsonapiMediaType)
+	expectCode(t, w, code)
+	var doc jsonapiResponse
+	if code == http.StatusNoContent {
+		return doc
+	}
+	if w.Header().Get("Content-Type") != jsonapiMediaType {
+		t.Fatalf("content type %v", w.Header())
+	}
+	decode(t, w, &doc)
+	return doc
+}
+
+// jsonapiOne return the resource of the primary data
+func jsonapiOne(t *testing.T, doc jsonapiResponse) jsonapiResponseResource {
+	t.Helper()
+	var res jsonapiResponseResource
+	if err := json.Unmarshal(doc.Data, &res); err != nil {
+		t.Fatalf("data %s", doc.Data)
+	}
+	return res
+}
+
+// jsonapiMany return the resources of the primary data
+func jsonapiMany(t *testing.T, doc jsonapiResponse) []jsonapiResponseResource {
+	t.Helper()
+	var res []jsonapiResponseResource
+	if err := json.Unmarshal(doc.Data, &res); err != nil {
+		t.Fatalf("data %s", doc.Data)
+	}
+	return res
+}
+
+func TestJSONAPI(t *testing.T) {
+	_, r := newJSONAPIMux(t)
+
+	w := serve(r, "POST", "/note", `{"data":{"type":"note","attributes":{"title":"a","words":2}}}`)
+	expectCode(t, w, http.StatusCreated)
+	if w.Header().Get("Location") != "/note/1" {
+		t.Fatalf("location %v", w.Header())
+	}
+	var doc jsonapiResponse
+	decode(t, w, &doc)
+	note := jsonapiOne(t, doc)
+	if note.Type != "note" || note.ID != "1" || note.Attributes["title"] != "a" || note.Links["self"] != "/note/1" {
+		t.Fatalf("resource %+v", note)
+	}
+	if _, ok := note.Attributes["id"]; ok || string(note.Relationships["tags"]) != `{"links":{"self":"/note/1/relationships/tags"},"data":[]}` {
+		t.Fatalf("resource %+v", note)
+	}
+
+	doc = serveJSONAPI(t, r, "PATCH", "/note/1", `{"data":{"type":"note","id":"1","attributes":{"title":"b"}}}`, http.StatusOK)
+	if note = jsonapiOne(t, doc); note.Attributes["title"] != "b" || note.Attributes["words"] != 2.0 {
+		t.Fatalf("patch %+v", note)
+	}
+	if note = jsonapiOne(t, serveJSONAPI(t, r, "GET", "/note/1", "", http.StatusOK)); note.Attributes["title"] != "b" {
+		t.Fatalf("get %+v", note)
+	}
+	serveJSONAPI(t, r, "DELETE", "/note/1", "", http.StatusNoContent)
+	doc = serveJSONAPI(t, r, "GET", "/note/1", "", http.StatusNotFound)
+	if len(doc.Errors) != 1 || string(doc.Errors[0]["status"]) != `"404"` {
+		t.Fatalf("errors %+v", doc.Errors)
+	}
+}
+
+func TestJSONAPIErrors(t *testing.T) {
+	_, r := newJSONAPIMux(t)
+	serveJSONAPI(t, r, "POST", "/note", `{"data":{"type":"note","attributes":{"title":"a"}}}`, http.StatusCreated)
+	for _, c := range []struct {
+		method, url, body string
+		code              int
+	}{
+		{"POST", "/note", `{"data":`, http.StatusBadRequest},
+		{"POST", "/note", `{"meta":{}}`, http.StatusBadRequest},
+		{"POST", "/note", `{"data":{"type":"tag","attributes":{"title":"a"}}}`, http.StatusConflict},
+		{"POST", "/note", `{"data":{"type":"note","attributes":{"title":1}}}`, http.StatusBadRequest},
+		{"POST", "/note", `{"data":{"type":"note","relationships":{"tags":{"data":[]}}}}`, http.StatusForbidden},
+		{"PATCH", "/note/1", `{"data":{"type":"note","id":"2","attributes":{}}}`, http.StatusConflict},
+		{"PATCH", "/note/9", `{"data":{"type":"note","id":"9","attributes":{"title":"b"}}}`, http.StatusNotFound},
+		{"GET", "/note?sort=title", ``, http.StatusBadRequest},
+		{"GET", "/note?include=other", ``, http.StatusBadRequest},
+		{"GET", "/note?filter[other]=1", ``, http.StatusBadRequest},
+	} {
+		doc := serveJSONAPI(t, r, c.method, c.url, c.body, c.code)
+		if len(doc.Errors) != 1 || doc.Errors[0]["title"] == nil {
+			t.Errorf("%s %s: %+v", c.method, c.url, doc)
+		}
+	}
+}
+
+func TestJSONAPIList(t *testing.T) {
+	_, r := newJSONAPIMux(t)
+	for _, title := range []string{"a", "b", "c"} {
+		serveJSONAPI(t, r, "POST", "/note", `{"data":{"type":"note","attributes":{"title":"`+title+`"}}}`, http.StatusCreated)
+	}
+
+	doc := serveJSONAPI(t, r, "GET", "/note", "", http.StatusOK)
+	if notes := jsonapiMany(t, doc); len(notes) != 3 || doc.Links["self"] != "/note" || doc.Meta != nil {
+		t.Fatalf("list %+v", doc)
+	}
+	doc = serveJSONAPI(t, r, "GET", "/note?filter[title]=b", "", http.StatusOK)
+	if notes := jsonapiMany(t, doc); len(notes) != 1 || notes[0].Attributes["title"] != "b" {
+		t.Fatalf("filter %+v", notes)
+	}
+	doc = serveJSONAPI(t, r, "GET", "/note?filter[id][gt]=1", "", http.StatusOK)
+	if notes := jsonapiMany(t, doc); len(notes) != 2 {
+		t.Fatalf("filter with operator %+v", notes)
+	}
+
+	doc = serveJSONAPI(t, r, "GET", "/note?page[number]=1&page[size]=2", "", http.StatusOK)
+	if notes := jsonapiMany(t, doc); len(notes) != 2 || doc.Meta["total_record"] != 3 || doc.Meta["total_page"] != 2 {
+		t.Fatalf("page %+v", doc)
+	}
+	if doc.Links["next"] != "/note?page%5Bnumber%5D=2&page%5Bsize%5D=2" || doc.Links["prev"] != "" || doc.Links["last"] == "" {
+		t.Fatalf("links %+v", doc.Links)
+	}
+	doc = serveJSONAPI(t, r, "GET", "/note.page?page[number]=2&page[size]=2", "", http.StatusOK)
+	if notes := jsonapiMany(t, doc); len(notes) != 1 || doc.Links["next"] != "" || doc.Links["prev"] == "" {
+		t.Fatalf("last page %+v", doc)
+	}
+}
+
+func TestJSONAPIRelationships(t *testing.T) {
+	_, r := newJSONAPIMux(t)
+	serveJSONAPI(t, r, "POST", "/note", `{"data":{"type":"note","attributes":{"title":"a"}}}`, http.StatusCreated)
+	serveJSONAPI(t, r, "POST", "/tag", `{"data":{"type":"tag","attributes":{"name":"x"}}}`, http.StatusCreated)
+	serveJSONAPI(t, r, "POST", "/tag", `{"data":{"type":"tag","attributes":{"name":"y"}}}`, http.StatusCreated)
+
+	serveJSONAPI(t, r, "POST", "/note/1/relationships/tags", `{"data":[{"type":"tag","id":"1"},{"type":"tag","id":"2"}]}`, http.StatusNoContent)
+	doc := serveJSONAPI(t, r, "GET", "/note/1/relationships/tags", "", http.StatusOK)
+	if string(doc.Data) != `[{"type":"tag","id":"1"},{"type":"tag","id":"2"}]` || doc.Links["self"] != "/note/1/relationships/tags" {
+		t.Fatalf("relationship %s %v", doc.Data, doc.Links)
+	}
+
+	doc = serveJSONAPI(t, r, "GET", "/note/1", "", http.StatusOK)
+	if len(doc.Included) != 2 || doc.Included[0].Type != "tag" || doc.Included[0].Attributes["name"] != "x" {
+		t.Fatalf("included %+v", doc.Included)
+	}
+	doc = serveJSONAPI(t, r, "GET", "/note?include=tags", "", http.StatusOK)
+	if len(doc.Included) != 2 {
+		t.Fatalf("include %+v", doc.Included)
+	}
+
+	serveJSONAPI(t, r, "DELETE", "/note/1/relationships/tags", `{"data":[{"type":"tag","id":"1"}]}`, http.StatusNoContent)
+	doc = serveJSONAPI(t, r, "GET", "/note/1/relationships/tags", "", http.StatusOK)
+	if string(doc.Data) != `[{"type":"tag","id":"2"}]` {
+		t.Fatalf("unlink %s", doc.Data)
+	}
+
+	serveJSONAPI(t, r, "POST", "/note/1/relationships/tags", `{"data":[{"type":"note","id":"1"}]}`, http.StatusConflict)
+	serveJSONAPI(t, r, "POST", "/note/1/relationships/tags", `{"data":`, http.StatusBadRequest)
+	serveJSONAPI(t, r, "GET", "/note/1/relationships/other", "", http.StatusNotFound)
+	doc = serveJSONAPI(t, r, "POST", "/note/1/relationships/tags", `{"data":[{"type":"tag","id":"9"}]}`, http.StatusConflict)
+	if doc.Errors[0]["meta"] == nil {
+		t.Fatalf("status of the links %+v", doc.Errors)
+	}
+}
+
+func TestJSONAPIFieldRules(t *testing.T) {
+	db, r := newJSONAPIMux(t)
+	doc := serveJSONAPI(t, r, "POST", "/member", `{"data":{"type":"member","attributes":{"login":"x","password":"secret","note":"n"}}}`, http.StatusCreated)
+	if member := jsonapiOne(t, doc); member.Attributes["password"] != nil {
+		t.Fatalf("writeonly field %+v", member)
+	}
+
+	// the PATCH without the writeonly field keeps it
+	doc = serveJSONAPI(t, r, "PATCH", "/member/1", `{"data":{"type":"member","id":"1","attributes":{"name":"y","login":"z"}}}`, http.StatusOK)
+	var member Member
+	db.First(&member, 1)
+	if member.Password != "secret" || member.Name != "y" || member.Login != "x" || member.Note != "n" {
+		t.Fatalf("stored %+v", member)
+	}
+	serveJSONAPI(t, withRoles(r, "guest"), "PATCH", "/member/1", `{"data":{"type":"member","id":"1","attributes":{"name":"w"}}}`, http.StatusOK)
+	db.First(&member, 1)
+	if member.Password != "secret" || member.Note != "n" || member.Name != "w" {
+		t.Fatalf("stored after the guest %+v", member)
+	}
+
+	w := httptest.NewRecorder()
+	withRoles(r, "guest").ServeHTTP(w, httptest.NewRequest("GET", "/member/1", nil))
+	expectCode(t, w, http.StatusOK)
+	decode(t, w, &doc)
+	if member := jsonapiOne(t, doc); member.Attributes["note"] != nil || member.Attributes["name"] != "w" {
+		t.Fatalf("hidden field %+v", member)
+	}
+}
+
+// Vault has the field and the relation only for the admin
+type Vault struct {
+	ID   uint   `gorm:"primary_key" json:"id"`
+	Name string `json:"name"`
+	Code string `json:"code" crud:"hidden:!admin"`
+	Tags []Tag  `gorm:"many2many:vault_tag" json:"tags" crud:"hidden:!admin"`
+}
+
+func TestJSONAPIAuthn(t *testing.T) {
+	db := openTestDB(t, &Member{}, &Vault{})
+	db.Create(&Member{Login: "x", Note: "n", Name: "m"})
+	db.Create(&Vault{Name: "v", Code: "c"})
+	db.Create(&Tag{Name: "x"})
+	keys := map[string]*Principal{
+		"guest": {Subject: "g", Roles: []string{"guest"}},
+		"admin": {Subject: "a", Roles: []string{"admin"}},
+	}
+	r := mux.NewRouter()
+	MapMux(r, db).AuthenticateWith(APIKey("X-Api-Key", keys)).
+		NewMap("/member", Member{}, []Member{}).JSONAPI().Full().
+		NewMap("/vault", Vault{}, []Vault{}).JSONAPI().Full()
+	get := func(url string, key string, code int) jsonapiResponse {
+		t.Helper()
+		w := serve(r, "GET", url, "", "X-Api-Key", key)
+		expectCode(t, w, code)
+		var doc jsonapiResponse
+		decode(t, w, &doc)
+		return doc
+	}
+
+	// the fields are hidden with the roles of the principal of the mapper
+	if member := jsonapiOne(t, get("/member/1", "guest", http.StatusOK)); member.Attributes["note"] != nil || member.Attributes["name"] != "m" {
+		t.Fatalf("member for the guest %+v", member)
+	}
+	if members := jsonapiMany(t, get("/member", "guest", http.StatusOK)); len(members) != 1 || members[0].Attributes["note"] != nil {
+		t.Fatalf("members for the guest %+v", members)
+	}
+	if _, ok := jsonapiOne(t, get("/member/1", "admin", http.StatusOK)).Attributes["note"]; !ok {
+		t.Fatal("note hidden for the admin")
+	}
+	if vault := jsonapiOne(t, get("/vault/1", "admin", http.StatusOK)); vault.Attributes["code"] != "c" {
+		t.Fatalf("vault for the admin %+v", vault)
+	}
+	if vault := jsonapiOne(t, get("/vault/1", "guest", http.StatusOK)); vault.Attributes["code"] != nil || vault.Relationships["tags"] != nil {
+		t.Fatalf("vault for the guest %+v", vault)
+	}
+	w := serve(r, "POST", "/member", `{"data":{"type":"member","attributes":{"login":"y","note":"n"}}}`, "Content-Type", jsonapiMediaType, "X-Api-Key", "guest")
+	expectCode(t, w, http.StatusCreated)
+	var doc jsonapiResponse
+	decode(t, w, &doc)
+	if member := jsonapiOne(t, doc); member.Attributes["note"] != nil {
+		t.Fatalf("created for the guest %+v", member)
+	}
+
+	// the relations hidden are checked with the roles of the principal
+	expectCode(t, serve(r, "POST", "/vault/1/relationships/tags", `{"data":[{"type":"tag","id":"1"}]}`, "X-Api-Key", "admin"), http.StatusNoContent)
+	if doc := get("/vault/1/relationships/tags", "admin", http.StatusOK); string(doc.Data) != `[{"type":"tag","id":"1"}]` {
+		t.Fatalf("relationship for the admin %s", doc.Data)
+	}
+	get("/vault/1/relationships/tags", "guest", http.StatusNotFound)
+	expectCode(t, serve(r, "GET", "/vault/1", ""), http.StatusUnauthorized)
+}
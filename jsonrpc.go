@@ -0,0 +1,303 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// JSONRPC is the endpoint of JSON-RPC 2.0 of the resources registered with
+// the mapper. For the resource note the methods are note.get {"id"},
+// note.page {"page", "limit", "filter"} (the filter of the lists),
+// note.save (the params are the entity), note.delete {"id", "purge"},
+// note.link and note.unlink {"id", "links": {"tags": [1, 2]}}. The params are
+// by name and the batches are supported.
+type JSONRPC struct {
+	mu       sync.RWMutex
+	services map[string]*Service
+}
+
+// The codes of error of JSON-RPC, the errors of ErrorCrud are rpcServerError
+// with the status code in the data (rpcInternalError for the status 500)
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+)
+
+// rpcRequest is one call of the client, ID is nil for the notifications
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError is the error of one response
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcResponse is the response of one call, it has the result or the error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// MarshalJSON write the result only when there is not error, the result null
+// is written
+func (res rpcResponse) MarshalJSON() ([]byte, error) {
+	if res.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *rpcError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{res.JSONRPC, res.Error, res.ID})
+	}
+	type response rpcResponse
+	return json.Marshal(response(res))
+}
+
+// rpcErrorData is the data of the errors of ErrorCrud
+type rpcErrorData struct {
+	Status int                       `json:"status"`
+	Links  map[string]LinkStatusCrud `json:"links,omitempty"`
+}
+
+// NewJSONRPC is constructor of JSONRPC
+func NewJSONRPC() *JSONRPC {
+	return &JSONRPC{services: map[string]*Service{}}
+}
+
+// register add the methods of the resource of the service
+func (rpc *JSONRPC) register(s *Service) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	rpc.services[s.Resource()] = s
+}
+
+// Serve is the endpoint of JSON-RPC, POST with one call or with the array of
+// the calls of one batch
+func (rpc *JSONRPC) Serve(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	body, _ := ioutil.ReadAll(r.Body)
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			json.NewEncoder(w).Encode(rpcFailure(nil, rpcParseError, "Parse error", nil))
+			return
+		}
+		if len(batch) == 0 {
+			json.NewEncoder(w).Encode(rpcFailure(nil, rpcInvalidRequest, "Invalid Request", nil))
+			return
+		}
+		responses := []rpcResponse{}
+		for _, raw := range batch {
+			if res, ok := rpc.call(r, raw); ok {
+				responses = append(responses, res)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+	res, ok := rpc.call(r, body)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// rpcFailure return the response of error of the call with the id
+func rpcFailure(id json.RawMessage, code int, message string, data interface{}) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message, Data: data}, ID: id}
+}
+
+// call run one call, ok is false for the notifications that have not response
+func (rpc *JSONRPC) call(r *http.Request, raw json.RawMessage) (res rpcResponse, ok bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+			return rpcFailure(nil, rpcParseError, "Parse error", nil), true
+		}
+		return rpcFailure(nil, rpcInvalidRequest, "Invalid Request", nil), true
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, rpcInvalidRequest, "Invalid Request", nil), true
+	}
+	result, err := rpc.invoke(r, req.Method, req.Params)
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+	if err != nil {
+		return rpcErrorResponse(req.ID, err), true
+	}
+	return rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}, true
+}
+
+// rpcErrorResponse return the response of the error of the method
+func rpcErrorResponse(id json.RawMessage, err error) rpcResponse {
+	if e, ok := err.(*rpcError); ok {
+		return rpcResponse{JSONRPC: "2.0", Error: e, ID: id}
+	}
+	var links map[string]LinkStatusCrud
+	if e, ok := err.(rpcLinkError); ok {
+		err, links = e.ErrorCrud, e.links
+	}
+	errCrud := toErrorCrud(err)
+	code := rpcServerError
+	if errCrud.Code == http.StatusInternalServerError {
+		code = rpcInternalError
+	}
+	return rpcFailure(id, code, errCrud.Message, rpcErrorData{Status: errCrud.Code, Links: links})
+}
+
+// rpcLinkError is the error of one link with the status of each link
+type rpcLinkError struct {
+	ErrorCrud
+	links map[string]LinkStatusCrud
+}
+
+// invalidParams return the error of the params of the method
+func invalidParams(err error) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: "Invalid params: " + err.Error()}
+}
+
+// rpcID is the id of the entity in the params, it can be a number or a string
+type rpcID string
+
+// UnmarshalJSON read the number or the string
+func (id *rpcID) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		*id = rpcID(v)
+	case float64:
+		*id = rpcID(bytes.TrimSpace(data))
+	default:
+		return fmt.Errorf("invalid id %s", data)
+	}
+	return nil
+}
+
+// decodeParams decode the params of the call in v, the params must be one
+// object
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+	if params[0] != '{' {
+		return invalidParams(fmt.Errorf("the params must be one object"))
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams(err)
+	}
+	return nil
+}
+
+// invoke run the method with the params
+func (rpc *JSONRPC) invoke(r *http.Request, method string, params json.RawMessage) (interface{}, error) {
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "Method not found"}
+	}
+	rpc.mu.RLock()
+	s, ok := rpc.services[method[:dot]]
+	rpc.mu.RUnlock()
+	if !ok {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "Method not found"}
+	}
+	switch action := method[dot+1:]; action {
+	case "get":
+		var p struct {
+			ID rpcID `json:"id"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.Get(r, string(p.ID))
+	case "page":
+		var p struct {
+			Page   int               `json:"page"`
+			Limit  int               `json:"limit"`
+			Filter map[string]string `json:"filter"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		for key, value := range p.Filter {
+			query.Set(key, value)
+		}
+		page, err := s.Page(r, p.Page, p.Limit, query)
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	case "save":
+		entity := s.New()
+		if err := decodeParams(params, entity); err != nil {
+			return nil, err
+		}
+		return s.Save(r, entity)
+	case "delete":
+		var p struct {
+			ID    rpcID `json:"id"`
+			Purge bool  `json:"purge"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.Delete(r, string(p.ID), p.Purge)
+	case "link", "unlink":
+		var p struct {
+			ID    rpcID              `json:"id"`
+			Links map[string][]rpcID `json:"links"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		links := url.Values{}
+		for field, ids := range p.Links {
+			for _, id := range ids {
+				links.Add(field, string(id))
+			}
+		}
+		result, err := s.Link(r, string(p.ID), action, links)
+		if e, ok := err.(ErrorCrud); ok && result != nil {
+			return nil, rpcLinkError{ErrorCrud: e, links: result}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "Method not found"}
+}
@@ -0,0 +1,174 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// rpcResult is the response of one call decoded by the tests
+type rpcResult struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int          `json:"code"`
+		Message string       `json:"message"`
+		Data    rpcErrorData `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+func newRPCMux(t *testing.T) *mux.Router {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	rpc := NewJSONRPC()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).Filterable().JSONRPC(rpc).Full().
+		NewMap("/tag", Tag{}, []Tag{}).JSONRPC(rpc).Full().
+		NewMap("/member", Member{}, []Member{}).JSONRPC(rpc).Full().
+		JSONRPCAt("/rpc", rpc)
+	return r
+}
+
+// callRPC post the body and return the response of the call
+func callRPC(t *testing.T, r *mux.Router, body string) rpcResult {
+	t.Helper()
+	w := serve(r, "POST", "/rpc", body)
+	expectCode(t, w, http.StatusOK)
+	var res rpcResult
+	decode(t, w, &res)
+	return res
+}
+
+func TestJSONRPC(t *testing.T) {
+	r := newRPCMux(t)
+
+	res := callRPC(t, r, `{"jsonrpc":"2.0","method":"note.save","params":{"title":"a","words":2},"id":1}`)
+	var note Note
+	if res.Error != nil || json.Unmarshal(res.Result, &note) != nil || note.ID != 1 || string(res.ID) != "1" {
+		t.Fatalf("save %+v", res)
+	}
+	callRPC(t, r, `{"jsonrpc":"2.0","method":"note.save","params":{"title":"b"},"id":2}`)
+
+	for _, id := range []string{`1`, `"1"`} {
+		res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.get","params":{"id":`+id+`},"id":"x"}`)
+		if res.Error != nil || json.Unmarshal(res.Result, &note) != nil || note.Title != "a" || string(res.ID) != `"x"` {
+			t.Fatalf("get %s %+v", id, res)
+		}
+	}
+
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.page","params":{"page":1,"limit":10,"filter":{"title":"b"}},"id":3}`)
+	var page struct {
+		TotalRecord int    `json:"total_record"`
+		Records     []Note `json:"records"`
+	}
+	if res.Error != nil || json.Unmarshal(res.Result, &page) != nil || page.TotalRecord != 1 || page.Records[0].Title != "b" {
+		t.Fatalf("page %+v", res)
+	}
+
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.delete","params":{"id":1},"id":4}`)
+	if res.Error != nil {
+		t.Fatalf("delete %+v", res)
+	}
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.get","params":{"id":1},"id":5}`)
+	if res.Error == nil || res.Error.Code != rpcServerError || res.Error.Data.Status != http.StatusNotFound || string(res.Result) != "" {
+		t.Fatalf("get deleted %+v", res)
+	}
+}
+
+func TestJSONRPCPublicFields(t *testing.T) {
+	r := newRPCMux(t)
+	res := callRPC(t, r, `{"jsonrpc":"2.0","method":"member.save","params":{"login":"x","password":"p"},"id":1}`)
+	if res.Error != nil || len(res.Result) == 0 {
+		t.Fatalf("save %+v", res)
+	}
+	var member map[string]interface{}
+	json.Unmarshal(res.Result, &member)
+	if _, ok := member["password"]; ok && member["password"] != "" {
+		t.Fatalf("writeonly field in the result %s", res.Result)
+	}
+}
+
+func TestJSONRPCLink(t *testing.T) {
+	r := newRPCMux(t)
+	callRPC(t, r, `{"jsonrpc":"2.0","method":"note.save","params":{"title":"a"},"id":1}`)
+	callRPC(t, r, `{"jsonrpc":"2.0","method":"tag.save","params":{"name":"t"},"id":2}`)
+
+	res := callRPC(t, r, `{"jsonrpc":"2.0","method":"note.link","params":{"id":1,"links":{"tags":[1]}},"id":3}`)
+	if res.Error != nil {
+		t.Fatalf("link %+v", res)
+	}
+	var note Note
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.get","params":{"id":1},"id":4}`)
+	if json.Unmarshal(res.Result, &note) != nil || len(note.Tags) != 1 {
+		t.Fatalf("tags not linked %s", res.Result)
+	}
+
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.link","params":{"id":1,"links":{"tags":[9]}},"id":5}`)
+	if res.Error == nil || res.Error.Data.Status != http.StatusConflict || len(res.Error.Data.Links) == 0 {
+		t.Fatalf("link of one missing tag %+v", res)
+	}
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.unlink","params":{"id":"1","links":{"tags":["1"]}},"id":6}`)
+	if res.Error != nil {
+		t.Fatalf("unlink %+v", res)
+	}
+	res = callRPC(t, r, `{"jsonrpc":"2.0","method":"note.get","params":{"id":1},"id":7}`)
+	if json.Unmarshal(res.Result, &note) != nil || len(note.Tags) != 0 {
+		t.Fatalf("tags not unlinked %s", res.Result)
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	r := newRPCMux(t)
+	w := serve(r, "POST", "/rpc", `[
+		{"jsonrpc":"2.0","method":"note.save","params":{"title":"a"},"id":1},
+		{"jsonrpc":"2.0","method":"note.save","params":{"title":"b"}},
+		{"jsonrpc":"2.0","method":"note.other","id":2},
+		1
+	]`)
+	expectCode(t, w, http.StatusOK)
+	var batch []rpcResult
+	decode(t, w, &batch)
+	if len(batch) != 3 || batch[0].Error != nil || batch[1].Error.Code != rpcMethodNotFound || batch[2].Error.Code != rpcInvalidRequest || string(batch[2].ID) != "null" {
+		t.Fatalf("batch %s", w.Body.String())
+	}
+	// the notification is run without response
+	res := callRPC(t, r, `{"jsonrpc":"2.0","method":"note.get","params":{"id":2},"id":3}`)
+	if res.Error != nil {
+		t.Fatalf("notification not run %+v", res)
+	}
+
+	expectCode(t, serve(r, "POST", "/rpc", `[{"jsonrpc":"2.0","method":"note.save","params":{"title":"c"}}]`), http.StatusNoContent)
+	expectCode(t, serve(r, "POST", "/rpc", `{"jsonrpc":"2.0","method":"note.other"}`), http.StatusNoContent)
+	if res = callRPC(t, r, `[]`); res.Error == nil || res.Error.Code != rpcInvalidRequest {
+		t.Fatalf("empty batch %+v", res)
+	}
+	if res = callRPC(t, r, `[{"jsonrpc":"2.0"`); res.Error == nil || res.Error.Code != rpcParseError {
+		t.Fatalf("invalid batch %+v", res)
+	}
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	r := newRPCMux(t)
+	for body, code := range map[string]int{
+		`{"jsonrpc":"2.0"`: rpcParseError,
+		`"x"`:              rpcInvalidRequest,
+		`{"jsonrpc":"1.0","method":"note.get","id":1}`:                                rpcInvalidRequest,
+		`{"jsonrpc":"2.0","id":1}`:                                                    rpcInvalidRequest,
+		`{"jsonrpc":"2.0","method":"note","id":1}`:                                    rpcMethodNotFound,
+		`{"jsonrpc":"2.0","method":"other.get","id":1}`:                               rpcMethodNotFound,
+		`{"jsonrpc":"2.0","method":"note.other","id":1}`:                              rpcMethodNotFound,
+		`{"jsonrpc":"2.0","method":"note.get","params":[1],"id":1}`:                   rpcInvalidParams,
+		`{"jsonrpc":"2.0","method":"note.get","params":{"id":{}},"id":1}`:             rpcInvalidParams,
+		`{"jsonrpc":"2.0","method":"note.save","params":{"title":1},"id":1}`:          rpcInvalidParams,
+		`{"jsonrpc":"2.0","method":"note.page","params":{"filter":{"x":"1"}},"id":1}`: rpcServerError,
+		`{"jsonrpc":"2.0","method":"note.get","params":{"id":9},"id":1}`:              rpcServerError,
+	} {
+		res := callRPC(t, r, body)
+		if res.Error == nil || res.Error.Code != code || res.JSONRPC != "2.0" {
+			t.Errorf("%s: %+v", body, res)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
+)
+
+// LiveHub is the registry of the resources of the websocket endpoint. The
+// client send {"action": "subscribe", "topic": "t", "resource": "note",
+// "id": "1"} or with "filter": {"title[like]": "a%"} (the filter of the
+// lists) and {"action": "unsubscribe", "topic": "t"}. The server send the
+// messages "subscribed", "unsubscribed", "event" and "error" with the topic.
+// The server send ping every PingInterval and close the connection when the
+// client does not answer in PongWait.
+type LiveHub struct {
+	// CheckOrigin return true if the origin of the request is allowed, nil
+	// allows only the same host
+	CheckOrigin  func(r *http.Request) bool
+	PingInterval time.Duration
+	PongWait     time.Duration
+	mu           sync.RWMutex
+	resources    map[string]liveResource
+}
+
+// liveResource is one resource registered with the settings of its mapper
+type liveResource struct {
+	db   *gorm.DB
+	elem interface{}
+}
+
+// liveRequest is one message of the client
+type liveRequest struct {
+	Action   string            `json:"action"`
+	Topic    string            `json:"topic"`
+	Resource string            `json:"resource"`
+	ID       string            `json:"id"`
+	Filter   map[string]string `json:"filter"`
+}
+
+// liveMessage is one message of the server
+type liveMessage struct {
+	Type  string     `json:"type"`
+	Topic string     `json:"topic,omitempty"`
+	Event *Event     `json:"event,omitempty"`
+	Error *ErrorCrud `json:"error,omitempty"`
+}
+
+// liveTopic is one subscription of the connection
+type liveTopic struct {
+	sub feedSubscription
+	id  string
+}
+
+// NewLiveHub is constructor of LiveHub
+func NewLiveHub() *LiveHub {
+	return &LiveHub{PingInterval: 50 * time.Second, PongWait: time.Minute, resources: map[string]liveResource{}}
+}
+
+// register add the resource of elem, db has the settings of the mapper
+func (hub *LiveHub) register(db *gorm.DB, elem interface{}) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	resource := db.NewScope(reflect.New(reflect.TypeOf(elem)).Interface()).TableName()
+	hub.resources[resource] = liveResource{db: db, elem: elem}
+}
+
+// buses return the buses of the resources registered
+func (hub *LiveHub) buses() []*EventBus {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	seen := map[*EventBus]bool{}
+	var buses []*EventBus
+	for _, res := range hub.resources {
+		if value, ok := res.db.Get(busKey); ok && !seen[value.(*EventBus)] {
+			seen[value.(*EventBus)] = true
+			buses = append(buses, value.(*EventBus))
+		}
+	}
+	return buses
+}
+
+// subscribe return the topic of the request with the tenant, the principal
+// and the authorization of r
+func (hub *LiveHub) subscribe(r *http.Request, req liveRequest) (liveTopic, error) {
+	hub.mu.RLock()
+	res, ok := hub.resources[req.Resource]
+	hub.mu.RUnlock()
+	if !ok {
+		return liveTopic{}, ErrorCrud{Message: "Resource Not Found", Code: http.StatusNotFound}
+	}
+	db, err := requestDB(res.db, r)
+	if err != nil {
+		return liveTopic{}, err
+	}
+	entity := reflect.New(reflect.TypeOf(res.elem)).Interface()
+	if req.ID != "" {
+		if ScopeTenant(db, entity).Where("id = ?", req.ID).First(entity).RowsAffected == 0 {
+			return liveTopic{}, ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}
+		}
+	}
+	if err := authorize(r.Context(), db, OpEvents, entity); err != nil {
+		return liveTopic{}, err
+	}
+	query := url.Values{}
+	for key, value := range req.Filter {
+		query.Set(key, value)
+	}
+	filter, err := ParseFilter(r.Context(), db, entity, query)
+	if err != nil {
+		return liveTopic{}, err
+	}
+	return liveTopic{sub: newFeedSubscription(db, r, res.elem, filter), id: req.ID}, nil
+}
+
+// send write the message to the connection
+func (c *wsConn) send(m liveMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.writeText(data)
+}
+
+// sendError write the message of error of the topic
+func (c *wsConn) sendError(topic string, err error) error {
+	errCrud, ok := err.(ErrorCrud)
+	if !ok {
+		errCrud = ErrorCrud{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	return c.send(liveMessage{Type: "error", Topic: topic, Error: &errCrud})
+}
+
+// Serve is the websocket endpoint of the hub. When the client is too slow the
+// connection is closed with the status 1013 and it must subscribe again.
+func (hub *LiveHub) Serve(w http.ResponseWriter, r *http.Request, id string) {
+	conn := hub.upgradeWebsocket(w, r)
+	if conn == nil {
+		return
+	}
+	defer conn.conn.Close()
+
+	var mu sync.Mutex
+	topics := map[string]liveTopic{}
+	events := make(chan Event, feedBuffer)
+	overflow := make(chan struct{})
+	closed := make(chan struct{})
+	var once sync.Once
+	for _, bus := range hub.buses() {
+		unsubscribe := bus.Subscribe(func(e Event) {
+			select {
+			case events <- e:
+			default:
+				once.Do(func() { close(overflow) })
+			}
+		})
+		defer unsubscribe()
+	}
+
+	interval := hub.PingInterval
+	if interval <= 0 {
+		interval = 50 * time.Second
+	}
+	ping := time.NewTicker(interval)
+	defer ping.Stop()
+
+	go func() {
+		defer close(closed)
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var req liveRequest
+			if err := json.Unmarshal(message, &req); err != nil {
+				conn.sendError("", ErrorCrud{Message: "Invalid message", Code: http.StatusBadRequest})
+				continue
+			}
+			if req.Topic == "" {
+				req.Topic = req.Resource
+				if req.ID != "" {
+					req.Topic += "/" + req.ID
+				}
+			}
+			switch req.Action {
+			case "subscribe":
+				topic, err := hub.subscribe(r, req)
+				if err != nil {
+					conn.sendError(req.Topic, err)
+					continue
+				}
+				mu.Lock()
+				topics[req.Topic] = topic
+				mu.Unlock()
+				conn.send(liveMessage{Type: "subscribed", Topic: req.Topic})
+			case "unsubscribe":
+				mu.Lock()
+				delete(topics, req.Topic)
+				mu.Unlock()
+				conn.send(liveMessage{Type: "unsubscribed", Topic: req.Topic})
+			default:
+				conn.sendError(req.Topic, ErrorCrud{Message: "Invalid action " + req.Action, Code: http.StatusBadRequest})
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-overflow:
+			conn.close(websocket.CloseTryAgainLater, "too slow")
+			return
+		case <-ping.C:
+			if conn.ping() != nil {
+				return
+			}
+		case e := <-events:
+			mu.Lock()
+			var matched []string
+			var sent []Event
+			for name, topic := range topics {
+				if topic.id != "" && topic.id != e.EntityID {
+					continue
+				}
+				if visible, ok := topic.sub.visible(e); ok {
+					matched = append(matched, name)
+					sent = append(sent, visible)
+				}
+			}
+			mu.Unlock()
+			for i, name := range matched {
+				if conn.send(liveMessage{Type: "event", Topic: name, Event: &sent[i]}) != nil {
+					return
+				}
+			}
+		}
+	}
+}
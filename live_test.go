@@ -0,0 +1,161 @@
+package gormcrud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func newLiveServer(t *testing.T, hub *LiveHub) (*httptest.Server, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	MapMux(r, db).PublishTo(NewEventBus()).
+		NewMap("/note", Note{}, []Note{}).Live(hub).Full().
+		NewMap("/member", Member{}, []Member{}).Live(hub).Full().
+		LiveAt("/live", hub)
+	return httptest.NewServer(r), r
+}
+
+func dialLive(t *testing.T, srv *httptest.Server, header http.Header) *websocket.Conn {
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/live", header)
+	if err != nil {
+		t.Fatalf("dial %v %v", resp, err)
+	}
+	return conn
+}
+
+// readLive return the next message of the server
+func readLive(t *testing.T, conn *websocket.Conn) liveMessage {
+	t.Helper()
+	var m liveMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLive(t *testing.T) {
+	srv, r := newLiveServer(t, NewLiveHub())
+	defer srv.Close()
+	conn := dialLive(t, srv, nil)
+	defer conn.Close()
+
+	conn.WriteJSON(liveRequest{Action: "subscribe", Topic: "a", Resource: "note", Filter: map[string]string{"title": "a"}})
+	if m := readLive(t, conn); m.Type != "subscribed" || m.Topic != "a" {
+		t.Fatalf("message %+v", m)
+	}
+	conn.WriteJSON(liveRequest{Action: "subscribe", Resource: "member"})
+	if m := readLive(t, conn); m.Type != "subscribed" || m.Topic != "member" {
+		t.Fatalf("message %+v", m)
+	}
+
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	m := readLive(t, conn)
+	if m.Type != "event" || m.Topic != "a" || m.Event.Type != "note.create" || m.Event.EntityID != "2" {
+		t.Fatalf("message %+v", m)
+	}
+	expectCode(t, serve(r, "POST", "/member", `{"login":"x","password":"p"}`), http.StatusOK)
+	if m = readLive(t, conn); m.Topic != "member" || strings.Contains(string(m.Event.Data), "password") {
+		t.Fatalf("message %+v", m)
+	}
+
+	conn.WriteJSON(liveRequest{Action: "unsubscribe", Topic: "a"})
+	if m = readLive(t, conn); m.Type != "unsubscribed" {
+		t.Fatalf("message %+v", m)
+	}
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/member", `{"login":"y"}`), http.StatusOK)
+	if m = readLive(t, conn); m.Topic != "member" {
+		t.Fatalf("event of the topic unsubscribed %+v", m)
+	}
+}
+
+func TestLiveErrors(t *testing.T) {
+	srv, _ := newLiveServer(t, NewLiveHub())
+	defer srv.Close()
+	conn := dialLive(t, srv, nil)
+	defer conn.Close()
+
+	for _, req := range []liveRequest{
+		{Action: "subscribe", Resource: "other"},
+		{Action: "subscribe", Resource: "note", ID: "9"},
+		{Action: "subscribe", Resource: "note", Filter: map[string]string{"other": "1"}},
+		{Action: "other", Resource: "note"},
+	} {
+		conn.WriteJSON(req)
+		if m := readLive(t, conn); m.Type != "error" || m.Error == nil {
+			t.Fatalf("%+v: %+v", req, m)
+		}
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("x"))
+	if m := readLive(t, conn); m.Type != "error" || m.Error.Code != http.StatusBadRequest {
+		t.Fatalf("invalid message %+v", m)
+	}
+	conn.WriteMessage(websocket.BinaryMessage, []byte("x"))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+		t.Fatalf("binary message %v", err)
+	}
+}
+
+func TestLiveOrigin(t *testing.T) {
+	hub := NewLiveHub()
+	srv, r := newLiveServer(t, hub)
+	defer srv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/live", http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross origin %v %v", resp, err)
+	}
+	conn := dialLive(t, srv, http.Header{"Origin": {srv.URL}})
+	conn.Close()
+
+	hub.CheckOrigin = func(r *http.Request) bool { return r.Header.Get("Origin") == "http://app.example" }
+	conn = dialLive(t, srv, http.Header{"Origin": {"http://app.example"}})
+	conn.Close()
+
+	expectCode(t, serve(r, "GET", "/live", ""), http.StatusBadRequest)
+}
+
+func TestLivePing(t *testing.T) {
+	hub := NewLiveHub()
+	hub.PingInterval = 20 * time.Millisecond
+	hub.PongWait = 200 * time.Millisecond
+	srv, _ := newLiveServer(t, hub)
+	defer srv.Close()
+
+	// the client that reads answers the ping and it stays connected
+	conn := dialLive(t, srv, nil)
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	if e, ok := err.(interface{ Timeout() bool }); !ok || !e.Timeout() || pings < 2 {
+		t.Fatalf("pings %d %v", pings, err)
+	}
+	conn.Close()
+
+	// the client that does not answer is closed after PongWait
+	conn = dialLive(t, srv, nil)
+	defer conn.Close()
+	time.Sleep(400 * time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+				t.Fatal("connection not closed")
+			}
+			break
+		}
+	}
+}
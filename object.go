@@ -0,0 +1,60 @@
+package gormcrud
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Object is the ordered object of the codecs and of the responses written
+// with the fields in order (GraphQL, HAL, OData, JSON:API, the aggregation).
+
+// Field is one field of Object
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Object is one object of the values of the codecs, the fields are in order
+type Object []Field
+
+// Set set the value of the field, the new fields are added at the end
+func (o *Object) Set(name string, value interface{}) {
+	for i, f := range *o {
+		if f.Name == name {
+			(*o)[i].Value = value
+			return
+		}
+	}
+	*o = append(*o, Field{Name: name, Value: value})
+}
+
+// Get return the value of the field
+func (o Object) Get(name string) (interface{}, bool) {
+	for _, f := range o {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// MarshalJSON write the fields in order
+func (o Object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.Name)
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
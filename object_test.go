@@ -0,0 +1,26 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestObject(t *testing.T) {
+	o := &Object{}
+	o.Set("b", 1)
+	o.Set("a", &Object{{Name: "x", Value: nil}})
+	o.Set("b", 2)
+	data, err := json.Marshal(o)
+	if err != nil || string(data) != `{"b":2,"a":{"x":null}}` {
+		t.Fatalf("json %s %v", data, err)
+	}
+	if v, ok := o.Get("b"); !ok || v != 2 {
+		t.Fatalf("get %v %v", v, ok)
+	}
+	if _, ok := o.Get("c"); ok {
+		t.Fatal("get of missing field")
+	}
+	if _, err := json.Marshal(Object{{Name: "f", Value: func() {}}}); err == nil {
+		t.Fatal("value not marshaled")
+	}
+}
@@ -0,0 +1,747 @@
+package gormcrud
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The OData mode of All and Page answer the requests with the system query
+// options of OData v4 ($filter, $orderby, $top, $skip, $select, $expand and
+// $count) or with the header OData-MaxVersion. The properties are the fields
+// in json of the entity and the relations are navigation properties that are
+// in the response only with $expand. The filter has the operators eq, ne,
+// gt, ge, lt, le, in, and, or, not and the functions contains, startswith
+// and endswith. The document $metadata is generated from the structs.
+
+const odataKey = "gormcrud:odata"
+
+// odataNamespace is the namespace of the types of $metadata
+const odataNamespace = "Default"
+
+// withOData set the path of the resource for the OData mode in the settings
+// of db, the empty path is the mode off
+func withOData(db *gorm.DB, base string) *gorm.DB {
+	if base == "" {
+		return db
+	}
+	return db.Set(odataKey, base)
+}
+
+// isOData return true if the request must be answered in the OData mode
+func isOData(db *gorm.DB, r *http.Request) bool {
+	if _, ok := db.Get(odataKey); !ok {
+		return false
+	}
+	if r.Header.Get("OData-MaxVersion") != "" || r.Header.Get("OData-Version") != "" {
+		return true
+	}
+	for key := range r.URL.Query() {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// odataQuery is the parsed system query options of one request
+type odataQuery struct {
+	props   []resourceProperty
+	where   string
+	args    []interface{}
+	orderBy []string
+	top     int
+	skip    int
+	selects map[string]bool
+	expand  map[string]bool
+	count   bool
+}
+
+// property return the property with the name
+func (q *odataQuery) property(name string) (resourceProperty, bool) {
+	for _, prop := range q.props {
+		if prop.name == name {
+			return prop, true
+		}
+	}
+	return resourceProperty{}, false
+}
+
+// odataInvalid return the error 400 of the query
+func odataInvalid(format string, args ...interface{}) error {
+	return ErrorCrud{Message: fmt.Sprintf(format, args...), Code: http.StatusBadRequest}
+}
+
+// parseODataQuery return the query of the options of the query string for
+// the entities of elem
+func parseODataQuery(ctx context.Context, db *gorm.DB, elem interface{}, query url.Values) (*odataQuery, error) {
+	scope := db.NewScope(elem)
+	q := &odataQuery{props: resourceProperties(db, reflect.TypeOf(elem), Roles(ctx)), top: -1}
+	for key, values := range query {
+		if !strings.HasPrefix(key, "$") {
+			continue
+		}
+		value := strings.TrimSpace(values[0])
+		switch key {
+		case "$filter":
+			where, args, err := parseODataFilter(scope, q, value)
+			if err != nil {
+				return nil, err
+			}
+			q.where, q.args = where, args
+		case "$orderby":
+			for _, item := range strings.Split(value, ",") {
+				parts := strings.Fields(item)
+				if len(parts) == 0 || len(parts) > 2 {
+					return nil, odataInvalid("Invalid $orderby %s", item)
+				}
+				prop, ok := q.property(parts[0])
+				if !ok || prop.relation {
+					return nil, odataInvalid("Invalid property %s in $orderby", parts[0])
+				}
+				direction := "ASC"
+				if len(parts) == 2 {
+					switch parts[1] {
+					case "asc":
+					case "desc":
+						direction = "DESC"
+					default:
+						return nil, odataInvalid("Invalid $orderby %s", item)
+					}
+				}
+				q.orderBy = append(q.orderBy, scope.QuotedTableName()+"."+scope.Quote(prop.column)+" "+direction)
+			}
+		case "$top", "$skip":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, odataInvalid("Invalid %s %s", key, value)
+			}
+			if key == "$top" {
+				q.top = n
+			} else {
+				q.skip = n
+			}
+		case "$select":
+			q.selects = map[string]bool{}
+			for _, name := range strings.Split(value, ",") {
+				name = strings.TrimSpace(name)
+				if _, ok := q.property(name); !ok && name != "*" {
+					return nil, odataInvalid("Invalid property %s in $select", name)
+				}
+				q.selects[name] = true
+			}
+		case "$expand":
+			q.expand = map[string]bool{}
+			for _, name := range strings.Split(value, ",") {
+				name = strings.TrimSpace(name)
+				if name == "*" {
+					for _, prop := range q.props {
+						q.expand[prop.name] = prop.relation
+					}
+					continue
+				}
+				if strings.Contains(name, "(") || strings.Contains(name, "/") {
+					return nil, odataInvalid("The options of $expand are not supported")
+				}
+				if prop, ok := q.property(name); !ok || !prop.relation {
+					return nil, odataInvalid("Invalid navigation property %s in $expand", name)
+				}
+				q.expand[name] = true
+			}
+		case "$count":
+			count, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, odataInvalid("Invalid $count %s", value)
+			}
+			q.count = count
+		case "$format":
+			if value != "json" && !strings.HasPrefix(value, "application/json") {
+				return nil, ErrorCrud{Message: "Unsupported $format " + value, Code: http.StatusNotAcceptable}
+			}
+		default:
+			return nil, odataInvalid("Unsupported system query option %s", key)
+		}
+	}
+	if len(q.orderBy) == 0 && scope.PrimaryKey() != "" {
+		q.orderBy = append(q.orderBy, scope.QuotedTableName()+"."+scope.Quote(scope.PrimaryKey()))
+	}
+	return q, nil
+}
+
+// selected return true if the property is in the response
+func (q *odataQuery) selected(prop resourceProperty) bool {
+	if prop.relation {
+		return q.expand[prop.name]
+	}
+	return q.selects == nil || q.selects["*"] || q.selects[prop.name]
+}
+
+// apply add the filter and the relations expanded to the query, the
+// relations are scoped by the tenant of db
+func (q *odataQuery) apply(db *gorm.DB) *gorm.DB {
+	db = db.Set("gorm:auto_preload", false)
+	if q.where != "" {
+		db = db.Where(q.where, q.args...)
+	}
+	tenant, scoped := db.Get(tenantKey)
+	for _, prop := range q.props {
+		if prop.relation && q.expand[prop.name] {
+			related := reflect.New(baseType(prop.typ)).Interface()
+			db = db.Preload(prop.goName, func(preload *gorm.DB) *gorm.DB {
+				if scoped {
+					preload = preload.Set(tenantKey, tenant)
+				}
+				return ScopeTenant(preload, related)
+			})
+		}
+	}
+	return db
+}
+
+// authorizeExpand authorize the relations expanded of the entities with the
+// policy of db
+func (q *odataQuery) authorizeExpand(ctx context.Context, db *gorm.DB, entities interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(entities))
+	for i := 0; i < v.Len(); i++ {
+		entity := reflect.Indirect(v.Index(i))
+		for _, prop := range q.props {
+			if !prop.relation || !q.expand[prop.name] {
+				continue
+			}
+			related := entity.FieldByName(prop.goName)
+			if related.Kind() == reflect.Ptr && related.IsNil() {
+				continue
+			}
+			if related.Kind() != reflect.Ptr {
+				related = related.Addr()
+			}
+			op := OpGet
+			if related.Elem().Kind() == reflect.Slice {
+				op = OpAll
+			}
+			if err := authorize(ctx, db, op, related.Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// project return the objects of the json of the entities with the
+// properties selected
+func (q *odataQuery) project(data []byte) ([]*Object, error) {
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	values := []*Object{}
+	for _, row := range rows {
+		value := &Object{}
+		for _, prop := range q.props {
+			if v, ok := row[prop.name]; ok && q.selected(prop) {
+				value.Set(prop.name, v)
+			}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// writeODataError write the error in the format of OData
+func writeODataError(w http.ResponseWriter, err error) {
+	errCrud := toErrorCrud(err)
+	if errCrud.Code < 400 || errCrud.Code >= 600 {
+		errCrud.Code = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errCrud.Code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": strconv.Itoa(errCrud.Code), "message": errCrud.Message},
+	})
+}
+
+// odataList answer the list of All and Page in the OData mode, elem is the
+// slice of the entities
+func odataList(w http.ResponseWriter, r *http.Request, db *gorm.DB, elem interface{}, op Operation) {
+	w.Header().Set("OData-Version", "4.0")
+	entities := reflect.New(reflect.TypeOf(elem)).Interface()
+	q, err := parseODataQuery(r.Context(), db, entities, r.URL.Query())
+	if err != nil {
+		writeODataError(w, err)
+		return
+	}
+	if db, err = Trashed(db, r, entities); err != nil {
+		writeODataError(w, err)
+		return
+	}
+	db = ScopeTenant(q.apply(db), entities)
+	var count int
+	if q.count {
+		if err := db.Model(entities).Count(&count).Error; err != nil {
+			writeODataError(w, err)
+			return
+		}
+	}
+	list := db.Order(strings.Join(q.orderBy, ", "))
+	if q.top >= 0 {
+		list = list.Limit(q.top)
+	} else if q.skip > 0 {
+		// OFFSET needs LIMIT in SQLite and MySQL
+		list = list.Limit(int64(math.MaxInt64))
+	}
+	if q.skip > 0 {
+		list = list.Offset(q.skip)
+	}
+	if err := list.Find(entities).Error; err != nil {
+		writeODataError(w, err)
+		return
+	}
+	if err := authorize(r.Context(), db, op, entities); err != nil {
+		writeODataError(w, err)
+		return
+	}
+	if err := q.authorizeExpand(r.Context(), db, entities); err != nil {
+		writeODataError(w, err)
+		return
+	}
+	if err := afterRead(r.Context(), db, entities); err != nil {
+		writeODataError(w, err)
+		return
+	}
+	data, err := json.Marshal(publicValue(r.Context(), entities))
+	if err != nil {
+		writeODataError(w, err)
+		return
+	}
+	values, err := q.project(data)
+	if err != nil {
+		writeODataError(w, err)
+		return
+	}
+	base, _ := db.Get(odataKey)
+	res := &Object{}
+	res.Set("@odata.context", fmt.Sprintf("%s/$metadata#%s", base, db.NewScope(entities).TableName()))
+	if q.count {
+		res.Set("@odata.count", count)
+	}
+	res.Set("value", values)
+	w.Header().Set("Content-Type", "application/json;odata.metadata=minimal")
+	json.NewEncoder(w).Encode(res)
+}
+
+// odataToken is one token of $filter
+type odataToken struct {
+	kind  byte // 'n' name, 's' string, 'l' number or date, 'p' punctuator, 0 end
+	value string
+	pos   int
+}
+
+// odataParser is the parser of $filter, it writes the SQL of the condition
+// with the arguments
+type odataParser struct {
+	scope *gorm.Scope
+	query *odataQuery
+	src   string
+	pos   int
+	token odataToken
+	args  []interface{}
+}
+
+// odataOps are the operators of comparison of $filter in SQL
+var odataOps = map[string]string{
+	"eq": "=",
+	"ne": "<>",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+// parseODataFilter return the condition in SQL of $filter with the arguments
+func parseODataFilter(scope *gorm.Scope, q *odataQuery, src string) (where string, args []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(ErrorCrud)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	p := &odataParser{scope: scope, query: q, src: src}
+	p.next()
+	where = p.or()
+	if p.token.kind != 0 {
+		p.fail("unexpected %q", p.token.value)
+	}
+	return where, p.args, nil
+}
+
+func (p *odataParser) fail(format string, args ...interface{}) {
+	panic(odataInvalid("Invalid $filter at %d: "+format, append([]interface{}{p.token.pos}, args...)...))
+}
+
+func (p *odataParser) is(kind byte, value string) bool {
+	return p.token.kind == kind && p.token.value == value
+}
+
+func (p *odataParser) expect(value string) {
+	if !p.is('p', value) {
+		p.fail("expected %q, found %q", value, p.token.value)
+	}
+	p.next()
+}
+
+func (p *odataParser) or() string {
+	where := p.and()
+	for p.is('n', "or") {
+		p.next()
+		where = where + " OR " + p.and()
+	}
+	return where
+}
+
+func (p *odataParser) and() string {
+	where := p.unary()
+	for p.is('n', "and") {
+		p.next()
+		where = where + " AND " + p.unary()
+	}
+	return where
+}
+
+func (p *odataParser) unary() string {
+	if p.is('n', "not") {
+		p.next()
+		return "NOT (" + p.unary() + ")"
+	}
+	if p.is('p', "(") {
+		p.next()
+		where := p.or()
+		p.expect(")")
+		return "(" + where + ")"
+	}
+	if p.token.kind != 'n' {
+		p.fail("expected property, found %q", p.token.value)
+	}
+	name := p.token.value
+	p.next()
+	if p.is('p', "(") {
+		return p.function(name)
+	}
+	column := p.column(name)
+	op := p.token.value
+	if p.token.kind != 'n' {
+		p.fail("expected operator, found %q", op)
+	}
+	p.next()
+	if op == "in" {
+		p.expect("(")
+		var values []interface{}
+		for {
+			values = append(values, p.literal())
+			if !p.is('p', ",") {
+				break
+			}
+			p.next()
+		}
+		p.expect(")")
+		p.args = append(p.args, values)
+		return column + " IN (?)"
+	}
+	sqlOp, ok := odataOps[op]
+	if !ok {
+		p.fail("unknown operator %q", op)
+	}
+	value := p.literal()
+	if value == nil {
+		switch op {
+		case "eq":
+			return column + " IS NULL"
+		case "ne":
+			return column + " IS NOT NULL"
+		}
+		p.fail("null is only compared with eq and ne")
+	}
+	p.args = append(p.args, value)
+	return column + " " + sqlOp + " ?"
+}
+
+// function parse the call of contains, startswith or endswith
+func (p *odataParser) function(name string) string {
+	p.expect("(")
+	if p.token.kind != 'n' {
+		p.fail("expected property, found %q", p.token.value)
+	}
+	column := p.column(p.token.value)
+	p.next()
+	p.expect(",")
+	value, ok := p.literal().(string)
+	if !ok {
+		p.fail("%s expects a string", name)
+	}
+	p.expect(")")
+	value = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+	switch name {
+	case "contains":
+		value = "%" + value + "%"
+	case "startswith":
+		value = value + "%"
+	case "endswith":
+		value = "%" + value
+	default:
+		p.fail("unknown function %s", name)
+	}
+	p.args = append(p.args, value)
+	return column + " LIKE ? ESCAPE '!'"
+}
+
+// column return the quoted column of the property
+func (p *odataParser) column(name string) string {
+	prop, ok := p.query.property(name)
+	if !ok || prop.relation {
+		p.fail("unknown property %s", name)
+	}
+	return p.scope.QuotedTableName() + "." + p.scope.Quote(prop.column)
+}
+
+// literal parse one value: string, number, date, true, false or null
+func (p *odataParser) literal() interface{} {
+	token := p.token
+	switch token.kind {
+	case 's':
+		p.next()
+		return token.value
+	case 'l':
+		p.next()
+		if i, err := strconv.ParseInt(token.value, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(token.value, 64); err == nil {
+			return f
+		}
+		return token.value
+	case 'n':
+		switch token.value {
+		case "true":
+			p.next()
+			return true
+		case "false":
+			p.next()
+			return false
+		case "null":
+			p.next()
+			return nil
+		}
+	}
+	p.fail("expected value, found %q", token.value)
+	return nil
+}
+
+// next read the next token
+func (p *odataParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.token = odataToken{pos: start}
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(' || c == ')' || c == ',':
+		p.pos++
+		p.token = odataToken{kind: 'p', value: string(c), pos: start}
+	case c == '\'':
+		var b strings.Builder
+		for p.pos++; ; p.pos++ {
+			if p.pos >= len(p.src) {
+				p.fail("unterminated string")
+			}
+			if p.src[p.pos] == '\'' {
+				if p.pos+1 < len(p.src) && p.src[p.pos+1] == '\'' {
+					p.pos++
+				} else {
+					break
+				}
+			}
+			b.WriteByte(p.src[p.pos])
+		}
+		p.pos++
+		p.token = odataToken{kind: 's', value: b.String(), pos: start}
+	case c == '-' || (c >= '0' && c <= '9'):
+		for p.pos++; p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || strings.IndexByte(".:+-", p.src[p.pos]) >= 0); p.pos++ {
+		}
+		p.token = odataToken{kind: 'l', value: p.src[start:p.pos], pos: start}
+	case isNameChar(c):
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.token = odataToken{kind: 'n', value: p.src[start:p.pos], pos: start}
+	default:
+		p.token = odataToken{kind: 'p', value: string(c), pos: start}
+		p.fail("unexpected character %q", c)
+	}
+}
+
+// The document $metadata in CSDL XML
+
+type csdlEdmx struct {
+	XMLName      xml.Name         `xml:"edmx:Edmx"`
+	Version      string           `xml:"Version,attr"`
+	Xmlns        string           `xml:"xmlns:edmx,attr"`
+	DataServices csdlDataServices `xml:"edmx:DataServices"`
+}
+
+type csdlDataServices struct {
+	Schema csdlSchema `xml:"Schema"`
+}
+
+type csdlSchema struct {
+	Namespace   string           `xml:"Namespace,attr"`
+	Xmlns       string           `xml:"xmlns,attr"`
+	EntityTypes []csdlEntityType `xml:"EntityType"`
+	Container   csdlContainer    `xml:"EntityContainer"`
+}
+
+type csdlEntityType struct {
+	Name       string           `xml:"Name,attr"`
+	Key        *csdlKey         `xml:"Key,omitempty"`
+	Properties []csdlProperty   `xml:"Property"`
+	Navigation []csdlNavigation `xml:"NavigationProperty"`
+}
+
+type csdlKey struct {
+	PropertyRefs []csdlPropertyRef `xml:"PropertyRef"`
+}
+
+type csdlPropertyRef struct {
+	Name string `xml:"Name,attr"`
+}
+
+type csdlProperty struct {
+	Name     string `xml:"Name,attr"`
+	Type     string `xml:"Type,attr"`
+	Nullable string `xml:"Nullable,attr,omitempty"`
+}
+
+type csdlNavigation struct {
+	Name string `xml:"Name,attr"`
+	Type string `xml:"Type,attr"`
+}
+
+type csdlContainer struct {
+	Name       string          `xml:"Name,attr"`
+	EntitySets []csdlEntitySet `xml:"EntitySet"`
+}
+
+type csdlEntitySet struct {
+	Name       string `xml:"Name,attr"`
+	EntityType string `xml:"EntityType,attr"`
+}
+
+// edmType return the primitive type of EDM of the type of one field
+func edmType(t reflect.Type) (string, bool) {
+	nullable := t.Kind() == reflect.Ptr
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "Edm.DateTimeOffset", nullable
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "Edm.Binary", true
+		}
+	case reflect.Bool:
+		return "Edm.Boolean", nullable
+	case reflect.Int8:
+		return "Edm.SByte", nullable
+	case reflect.Uint8:
+		return "Edm.Byte", nullable
+	case reflect.Int16:
+		return "Edm.Int16", nullable
+	case reflect.Int32, reflect.Uint16:
+		return "Edm.Int32", nullable
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "Edm.Int64", nullable
+	case reflect.Float32:
+		return "Edm.Single", nullable
+	case reflect.Float64:
+		return "Edm.Double", nullable
+	case reflect.String:
+		return "Edm.String", nullable
+	}
+	return "Edm.String", true
+}
+
+// ODataMetadata return the document $metadata of the entity and of the types
+// of its relations
+func ODataMetadata(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		roles := Roles(r.Context())
+		schema := csdlSchema{Namespace: odataNamespace, Xmlns: "http://docs.oasis-open.org/odata/ns/edm"}
+		root := baseType(reflect.TypeOf(elem))
+		seen := map[reflect.Type]bool{root: true}
+		for queue := []reflect.Type{root}; len(queue) > 0; queue = queue[1:] {
+			t := queue[0]
+			entityType := csdlEntityType{Name: t.Name()}
+			for _, prop := range resourceProperties(db, t, roles) {
+				if prop.relation {
+					related := baseType(prop.typ)
+					typeName := odataNamespace + "." + related.Name()
+					ft := prop.typ
+					for ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Slice {
+						typeName = "Collection(" + typeName + ")"
+					}
+					entityType.Navigation = append(entityType.Navigation, csdlNavigation{Name: prop.name, Type: typeName})
+					if !seen[related] {
+						seen[related] = true
+						queue = append(queue, related)
+					}
+					continue
+				}
+				typeName, nullable := edmType(prop.typ)
+				property := csdlProperty{Name: prop.name, Type: typeName}
+				if !nullable || prop.key {
+					property.Nullable = "false"
+				}
+				if prop.key {
+					if entityType.Key == nil {
+						entityType.Key = &csdlKey{}
+					}
+					entityType.Key.PropertyRefs = append(entityType.Key.PropertyRefs, csdlPropertyRef{Name: prop.name})
+				}
+				entityType.Properties = append(entityType.Properties, property)
+			}
+			schema.EntityTypes = append(schema.EntityTypes, entityType)
+		}
+		schema.Container = csdlContainer{Name: "Container", EntitySets: []csdlEntitySet{{
+			Name:       db.NewScope(reflect.New(root).Interface()).TableName(),
+			EntityType: odataNamespace + "." + root.Name(),
+		}}}
+		doc := csdlEdmx{Version: "4.0", Xmlns: "http://docs.oasis-open.org/odata/ns/edmx", DataServices: csdlDataServices{Schema: schema}}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Header().Set("OData-Version", "4.0")
+		fmt.Fprint(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			WriteError(w, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package gormcrud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// odataResponse is the list of the OData mode decoded by the tests
+type odataResponse struct {
+	Context string                   `json:"@odata.context"`
+	Count   *int                     `json:"@odata.count"`
+	Value   []map[string]interface{} `json:"value"`
+}
+
+func newODataMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	MapMux(r, db).
+		NewMap("/note", Note{}, []Note{}).OData().Full().
+		NewMap("/member", Member{}, []Member{}).OData().Full()
+	for _, note := range []Note{
+		{Title: "a", Words: 1, Tags: []Tag{{Name: "x"}}},
+		{Title: "b", Words: 2},
+		{Title: "ab", Words: 3},
+		{Title: "it's 100%", Words: 4},
+	} {
+		if err := db.Create(&note).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, r
+}
+
+// getOData run the query on the list and return the response
+func getOData(t *testing.T, r *mux.Router, path string, query string) odataResponse {
+	t.Helper()
+	w := serve(r, "GET", path+"?"+query, "")
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("OData-Version") != "4.0" || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("headers %v", w.Header())
+	}
+	var res odataResponse
+	decode(t, w, &res)
+	return res
+}
+
+// odataTitles return the titles of the values
+func odataTitles(res odataResponse) []string {
+	titles := []string{}
+	for _, value := range res.Value {
+		titles = append(titles, value["title"].(string))
+	}
+	return titles
+}
+
+func TestOData(t *testing.T) {
+	_, r := newODataMux(t)
+	for query, want := range map[string][]string{
+		"$filter=words gt 1":                                {"b", "ab", "it's 100%"},
+		"$filter=title eq 'a' or words ge 4":                {"a", "it's 100%"},
+		"$filter=words ge 2 and not (title eq 'b')":         {"ab", "it's 100%"},
+		"$filter=(words eq 1 or words eq 2) and words lt 2": {"a"},
+		"$filter=words ne 1 and words le 2":                 {"b"},
+		"$filter=words in (1, 3)":                           {"a", "ab"},
+		"$filter=title in ('b', 'ab')":                      {"b", "ab"},
+		"$filter=contains(title,'b')":                       {"b", "ab"},
+		"$filter=startswith(title, 'a')":                    {"a", "ab"},
+		"$filter=endswith(title,'b')":                       {"b", "ab"},
+		"$filter=contains(title,'0%')":                      {"it's 100%"},
+		"$filter=contains(title,'_')":                       {},
+		"$filter=title eq 'it''s 100%'":                     {"it's 100%"},
+		"$filter=deleted_at eq null":                        {"a", "b", "ab", "it's 100%"},
+		"$filter=deleted_at ne null":                        {},
+		"$orderby=words desc":                               {"it's 100%", "ab", "b", "a"},
+		"$orderby=title asc, words":                         {"a", "ab", "b", "it's 100%"},
+		"$top=2":                                            {"a", "b"},
+		"$top=2&$skip=1":                                    {"b", "ab"},
+		"$skip=3":                                           {"it's 100%"},
+		"$top=0":                                            {},
+	} {
+		res := getOData(t, r, "/note", url.PathEscape(query))
+		if got := odataTitles(res); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v", query, got)
+		}
+		if res.Context != "/note/$metadata#note" || res.Count != nil {
+			t.Errorf("%s: context %q count %v", query, res.Context, res.Count)
+		}
+	}
+
+	// the mode is off without the options, the page has it too
+	w := serve(r, "GET", "/note?title=a", "")
+	expectCode(t, w, http.StatusOK)
+	var notes []Note
+	decode(t, w, &notes)
+	if len(notes) != 4 || w.Header().Get("OData-Version") != "" {
+		t.Fatalf("list without OData %s", w.Body.String())
+	}
+	if res := getOData(t, r, "/note.page", "$top=1"); !reflect.DeepEqual(odataTitles(res), []string{"a"}) {
+		t.Fatalf("page %+v", res)
+	}
+	w = serve(r, "GET", "/note", "", "OData-MaxVersion", "4.0")
+	expectCode(t, w, http.StatusOK)
+	if w.Header().Get("OData-Version") != "4.0" {
+		t.Fatalf("OData-MaxVersion without the mode %v", w.Header())
+	}
+}
+
+func TestODataSelectExpandCount(t *testing.T) {
+	_, r := newODataMux(t)
+
+	res := getOData(t, r, "/note", "$select=title,words&$top=1")
+	if len(res.Value) != 1 || !reflect.DeepEqual(res.Value[0], map[string]interface{}{"title": "a", "words": 1.0}) {
+		t.Fatalf("select %+v", res.Value)
+	}
+	res = getOData(t, r, "/note", "$top=1")
+	if _, ok := res.Value[0]["tags"]; ok || res.Value[0]["created_at"] == nil {
+		t.Fatalf("relation without $expand %+v", res.Value)
+	}
+	res = getOData(t, r, "/note", "$expand=tags&$select=title&$top=1")
+	tags, _ := res.Value[0]["tags"].([]interface{})
+	if len(tags) != 1 || tags[0].(map[string]interface{})["name"] != "x" || len(res.Value[0]) != 2 {
+		t.Fatalf("expand %+v", res.Value)
+	}
+	if res = getOData(t, r, "/note", "$expand=*&$top=1"); res.Value[0]["tags"] == nil {
+		t.Fatalf("expand * %+v", res.Value)
+	}
+	res = getOData(t, r, "/note", url.PathEscape("$count=true&$filter=words gt 1&$top=1"))
+	if res.Count == nil || *res.Count != 3 || len(res.Value) != 1 {
+		t.Fatalf("count %+v", res)
+	}
+	if res = getOData(t, r, "/note", "$format=json&$count=false"); res.Count != nil || len(res.Value) != 4 {
+		t.Fatalf("count false %+v", res)
+	}
+}
+
+func TestODataFieldRules(t *testing.T) {
+	db, r := newODataMux(t)
+	db.Create(&Member{Login: "x", Password: "secret", Role: "admin", Note: "n"})
+
+	res := getOData(t, r, "/member", "$top=1")
+	if _, ok := res.Value[0]["password"]; ok || res.Value[0]["login"] != "x" {
+		t.Fatalf("writeonly field %+v", res.Value)
+	}
+	w := serve(withRoles(r, "guest"), "GET", "/member?"+url.PathEscape("$top=1"), "")
+	expectCode(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), `"note"`) {
+		t.Fatalf("hidden field %s", w.Body.String())
+	}
+	for _, query := range []string{"$filter=password eq 'secret'", "$orderby=password", "$select=password"} {
+		expectCode(t, serve(r, "GET", "/member?"+url.PathEscape(query), ""), http.StatusBadRequest)
+	}
+	expectCode(t, serve(withRoles(r, "guest"), "GET", "/member?"+url.PathEscape("$filter=note eq 'n'"), ""), http.StatusBadRequest)
+}
+
+func TestODataExpandTenant(t *testing.T) {
+	db := openTestDB(t, &Account{}, &Contract{})
+	db.Create(&Account{TenantID: 1, Name: "a"})
+	db.Create(&Account{TenantID: 2, Name: "b"})
+	db.Create(&Contract{TenantID: 1, Name: "own", AccountID: 1})
+	db.Create(&Contract{TenantID: 1, Name: "other", AccountID: 2})
+	policy := func(ctx context.Context, op Operation, entity interface{}) error {
+		if account, ok := entity.(*Account); ok && account.Name == "secret" {
+			return errors.New("secret account")
+		}
+		return nil
+	}
+	r := mux.NewRouter()
+	MapMux(r, db).TenantBy(TenantFromHeader("X-Tenant")).
+		NewMap("/contract", Contract{}, []Contract{}).Authorize(policy).OData().Full()
+
+	// the account of other tenant is not expanded
+	w := serve(r, "GET", "/contract?$expand=account", "", "X-Tenant", "1")
+	expectCode(t, w, http.StatusOK)
+	var res odataResponse
+	decode(t, w, &res)
+	if len(res.Value) != 2 || res.Value[1]["account"] != nil {
+		t.Fatalf("expand of tenant 1 %+v", res.Value)
+	}
+	if account, _ := res.Value[0]["account"].(map[string]interface{}); account["name"] != "a" {
+		t.Fatalf("expand of tenant 1 %+v", res.Value)
+	}
+
+	// the relations expanded are authorized
+	db.Model(&Account{}).Where("id = ?", 1).Update("name", "secret")
+	expectCode(t, serve(r, "GET", "/contract?$expand=account", "", "X-Tenant", "1"), http.StatusForbidden)
+	expectCode(t, serve(r, "GET", "/contract", "", "X-Tenant", "1"), http.StatusOK)
+}
+
+func TestODataErrors(t *testing.T) {
+	_, r := newODataMux(t)
+	for query, code := range map[string]int{
+		"$filter=other eq 1":         http.StatusBadRequest,
+		"$filter=title eq":           http.StatusBadRequest,
+		"$filter=title xx 'a'":       http.StatusBadRequest,
+		"$filter=title eq 'a":        http.StatusBadRequest,
+		"$filter=words gt null":      http.StatusBadRequest,
+		"$filter=(title eq 'a'":      http.StatusBadRequest,
+		"$filter=title eq 'a' words": http.StatusBadRequest,
+		"$filter=contains(title,1)":  http.StatusBadRequest,
+		"$filter=other(title,'a')":   http.StatusBadRequest,
+		"$filter=tags eq 1":          http.StatusBadRequest,
+		"$filter=title eq 'a' ; x":   http.StatusBadRequest,
+		"$orderby=title up":          http.StatusBadRequest,
+		"$orderby=tags":              http.StatusBadRequest,
+		"$orderby=":                  http.StatusBadRequest,
+		"$top=-1":                    http.StatusBadRequest,
+		"$skip=x":                    http.StatusBadRequest,
+		"$select=other":              http.StatusBadRequest,
+		"$expand=title":              http.StatusBadRequest,
+		"$expand=tags($top=1)":       http.StatusBadRequest,
+		"$count=maybe":               http.StatusBadRequest,
+		"$search=a":                  http.StatusBadRequest,
+		"$format=xml":                http.StatusNotAcceptable,
+	} {
+		w := serve(r, "GET", "/note?"+url.PathEscape(query), "")
+		expectCode(t, w, code)
+		var res struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		decode(t, w, &res)
+		if res.Error.Message == "" || res.Error.Code == "" {
+			t.Errorf("%s: %s", query, w.Body.String())
+		}
+	}
+}
+
+func TestODataMetadata(t *testing.T) {
+	_, r := newODataMux(t)
+	w := serve(r, "GET", "/note/$metadata", "")
+	expectCode(t, w, http.StatusOK)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<edmx:Edmx Version="4.0" xmlns:edmx="http://docs.oasis-open.org/odata/ns/edmx">`,
+		`<EntityType Name="Note">`,
+		`<PropertyRef Name="id"></PropertyRef>`,
+		`<Property Name="id" Type="Edm.Int64" Nullable="false"></Property>`,
+		`<Property Name="deleted_at" Type="Edm.DateTimeOffset"></Property>`,
+		`<Property Name="title" Type="Edm.String" Nullable="false"></Property>`,
+		`<NavigationProperty Name="tags" Type="Collection(Default.Tag)"></NavigationProperty>`,
+		`<EntityType Name="Tag">`,
+		`<EntitySet Name="note" EntityType="Default.Note"></EntitySet>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%s not in %s", want, body)
+		}
+	}
+	if w.Header().Get("Content-Type") != "application/xml" {
+		t.Fatalf("content type %v", w.Header())
+	}
+
+	w = serve(r, "GET", "/member/$metadata", "")
+	if strings.Contains(w.Body.String(), `"password"`) || !strings.Contains(w.Body.String(), `<Property Name="login"`) {
+		t.Fatalf("writeonly field in $metadata %s", w.Body.String())
+	}
+}
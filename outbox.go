@@ -0,0 +1,223 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// outboxKey is the key of the Outbox in the settings of gorm.DB
+const outboxKey = "gormcrud:outbox"
+
+// OutboxEvent is one event written in the transaction of the operation and
+// not yet published if PublishedAt is nil
+type OutboxEvent struct {
+	ID          uint       `gorm:"primary_key" json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EventID     string     `json:"event_id" gorm:"unique_index"`
+	Type        string     `json:"type"`
+	Payload     string     `json:"payload" sql:"type:text"`
+	PublishedAt *time.Time `json:"published_at" gorm:"index"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error" sql:"type:text"`
+	NextAttempt *time.Time `json:"next_attempt"`
+}
+
+// TableName is the table of the outbox
+func (OutboxEvent) TableName() string {
+	return "gormcrud_outbox"
+}
+
+// Publisher publish the events of the outbox. The delivery is at least once,
+// so the receivers use Event.ID to discard the duplicates.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// PublisherFunc is a function used as Publisher
+type PublisherFunc func(ctx context.Context, e Event) error
+
+// Publish call f(ctx, e)
+func (f PublisherFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// ChannelPublisher publish the events in the channel (in process)
+type ChannelPublisher chan Event
+
+// Publish send the event to the channel
+func (c ChannelPublisher) Publish(ctx context.Context, e Event) error {
+	select {
+	case c <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BusPublisher publish the events of the outbox in the bus (webhooks,
+// server-sent events, ...)
+func BusPublisher(bus *EventBus) Publisher {
+	return PublisherFunc(func(ctx context.Context, e Event) error {
+		bus.Publish(e)
+		return nil
+	})
+}
+
+// HTTPPublisher POST the events to URL, signed like the webhooks
+type HTTPPublisher struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// Publish POST the event, the status 2xx is success
+func (p HTTPPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gormcrud-Event", e.Type)
+	req.Header.Set("X-Gormcrud-Delivery", e.ID)
+	if p.Secret != "" {
+		req.Header.Set("X-Gormcrud-Signature", "sha256="+Sign(p.Secret, body))
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ErrorCrud{Message: resp.Status, Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// Outbox store the events in the table gormcrud_outbox in the transaction of
+// the operations and publish them in background (see Run). One event that
+// fails is tried again after Backoff, doubled on each attempt; after
+// MaxAttempts it stays in the table with its last error (dead letter) and the
+// next events are published.
+type Outbox struct {
+	DB          *gorm.DB
+	Publishers  []Publisher
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// NewOutbox migrate the table of the outbox and return the outbox
+func NewOutbox(db *gorm.DB, publishers ...Publisher) *Outbox {
+	db.AutoMigrate(&OutboxEvent{})
+	return &Outbox{DB: db, Publishers: publishers, Interval: time.Second, BatchSize: 100, MaxAttempts: 10, Backoff: time.Second}
+}
+
+// withOutbox set the outbox in the settings of db
+func withOutbox(db *gorm.DB, outbox *Outbox) *gorm.DB {
+	if outbox == nil {
+		return db
+	}
+	return db.Set(outboxKey, outbox)
+}
+
+// writeOutbox insert the event in the outbox with the db of the transaction
+func writeOutbox(db *gorm.DB, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return db.New().Create(&OutboxEvent{EventID: e.ID, Type: e.Type, Payload: string(payload)}).Error
+}
+
+// Run publish the pending events every Interval until ctx is done
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for {
+		if err := o.Dispatch(ctx); err != nil {
+			log.Printf("gormcrud: outbox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch publish one batch of pending events in order. One event is marked
+// as published when all the publishers return success. The batch stops at the
+// first event that fails or waits its backoff, so the events are never
+// published out of order; the error of the failed event is returned.
+func (o *Outbox) Dispatch(ctx context.Context) error {
+	var pending []OutboxEvent
+	db := o.DB.Where("published_at IS NULL")
+	if o.MaxAttempts > 0 {
+		db = db.Where("attempts < ?", o.MaxAttempts)
+	}
+	ret := db.Order("id").Limit(o.BatchSize).Find(&pending)
+	if ret.Error != nil {
+		return ret.Error
+	}
+	for _, row := range pending {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if row.NextAttempt != nil && time.Now().Before(*row.NextAttempt) {
+			return nil
+		}
+		var e Event
+		err := json.Unmarshal([]byte(row.Payload), &e)
+		for _, p := range o.Publishers {
+			if err != nil {
+				break
+			}
+			err = p.Publish(ctx, e)
+		}
+		if err != nil {
+			next := time.Now().Add(o.backoff(row.Attempts + 1))
+			o.DB.Model(&row).UpdateColumns(map[string]interface{}{
+				"attempts":     row.Attempts + 1,
+				"last_error":   err.Error(),
+				"next_attempt": next,
+			})
+			return fmt.Errorf("event %s: %v", row.EventID, err)
+		}
+		o.DB.Model(&row).UpdateColumns(map[string]interface{}{
+			"attempts":     row.Attempts + 1,
+			"published_at": time.Now(),
+			"last_error":   "",
+		})
+	}
+	return nil
+}
+
+// backoff return the wait after the attempt n, Backoff doubled on each
+// attempt up to one hour
+func (o *Outbox) backoff(n int) time.Duration {
+	wait := o.Backoff
+	for i := 1; i < n && wait < time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > time.Hour {
+		wait = time.Hour
+	}
+	return wait
+}
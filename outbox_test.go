@@ -0,0 +1,135 @@
+package gormcrud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func newOutboxMux(t *testing.T, publishers ...Publisher) (*gorm.DB, *mux.Router, *Outbox, *EventBus) {
+	db := openTestDB(t)
+	outbox := NewOutbox(db, publishers...)
+	bus := NewEventBus()
+	r := mux.NewRouter()
+	MapMux(r, db).PublishTo(bus).OutboxTo(outbox).NewMap("/note", Note{}, []Note{}).Full()
+	return db, r, outbox, bus
+}
+
+func TestOutboxOnlyDelivery(t *testing.T) {
+	var published []string
+	_, r, outbox, bus := newOutboxMux(t)
+	outbox.Publishers = []Publisher{BusPublisher(bus)}
+	bus.Subscribe(func(e Event) { published = append(published, e.Type) })
+
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	if len(published) != 0 {
+		t.Fatalf("published before the dispatch %v", published)
+	}
+	if err := outbox.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, []string{"note.create"}) {
+		t.Fatalf("published %v", published)
+	}
+	if err := outbox.Dispatch(context.Background()); err != nil || len(published) != 1 {
+		t.Fatalf("published again %v %v", published, err)
+	}
+}
+
+func TestOutboxRollback(t *testing.T) {
+	db, _, _, _ := newOutboxMux(t)
+	Transaction(withOutbox(db, &Outbox{DB: db}), func(tx *gorm.DB) error {
+		if err := recordEvent(tx, "create", &Note{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		return errors.New("rollback")
+	})
+	var count int
+	db.Model(&OutboxEvent{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d events of the rolled back transaction", count)
+	}
+}
+
+func TestOutboxOrderAndBackoff(t *testing.T) {
+	var published []string
+	fail := true
+	publisher := PublisherFunc(func(ctx context.Context, e Event) error {
+		if fail {
+			return errors.New("down")
+		}
+		published = append(published, e.EntityID)
+		return nil
+	})
+	db, r, outbox, _ := newOutboxMux(t, publisher)
+	outbox.Backoff = time.Hour
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+
+	if err := outbox.Dispatch(context.Background()); err == nil {
+		t.Fatal("error of the publisher not returned")
+	}
+	var rows []OutboxEvent
+	db.Order("id").Find(&rows)
+	if rows[0].Attempts != 1 || rows[0].LastError != "down" || rows[0].NextAttempt == nil || rows[1].Attempts != 0 {
+		t.Fatalf("the batch did not stop at the failure %+v", rows)
+	}
+
+	// the first event waits its backoff and the second is not published before
+	fail = false
+	if err := outbox.Dispatch(context.Background()); err != nil || len(published) != 0 {
+		t.Fatalf("published during the backoff %v %v", published, err)
+	}
+	db.Model(&OutboxEvent{}).UpdateColumn("next_attempt", time.Now().Add(-time.Second))
+	if err := outbox.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, []string{"1", "2"}) {
+		t.Fatalf("published %v", published)
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	var published []string
+	publisher := PublisherFunc(func(ctx context.Context, e Event) error {
+		if e.EntityID == "1" {
+			return errors.New("rejected")
+		}
+		published = append(published, e.EntityID)
+		return nil
+	})
+	db, r, outbox, _ := newOutboxMux(t, publisher)
+	outbox.MaxAttempts = 2
+	outbox.Backoff = 0
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+
+	outbox.Dispatch(context.Background())
+	outbox.Dispatch(context.Background())
+	if err := outbox.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, []string{"2"}) {
+		t.Fatalf("published %v", published)
+	}
+	var dead OutboxEvent
+	db.First(&dead, 1)
+	if dead.PublishedAt != nil || dead.Attempts != 2 || dead.LastError != "rejected" {
+		t.Fatalf("dead letter %+v", dead)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{Backoff: time.Second}
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 20: time.Hour} {
+		if got := o.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v", n, got)
+		}
+	}
+}
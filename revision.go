@@ -0,0 +1,197 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// versioningKey is the key of the versioning in the settings of gorm.DB
+const versioningKey = "gormcrud:versioning"
+
+// OpRevisions is the operation of read of the revisions
+const OpRevisions Operation = "revisions"
+
+// Revision is the full snapshot of one entity after one save
+type Revision struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Resource  string    `json:"resource" gorm:"unique_index:uix_gormcrud_revision_rev"`
+	EntityID  string    `json:"entity_id" gorm:"unique_index:uix_gormcrud_revision_rev"`
+	Rev       int       `json:"rev" gorm:"unique_index:uix_gormcrud_revision_rev"`
+	Principal string    `json:"principal"`
+	Snapshot  string    `json:"-" sql:"type:text"`
+}
+
+// TableName is the table of the revisions
+func (Revision) TableName() string {
+	return "gormcrud_revision"
+}
+
+// withVersioning set the versioning in the settings of db
+func withVersioning(db *gorm.DB, versioning bool) *gorm.DB {
+	if !versioning {
+		return db
+	}
+	return db.Set(versioningKey, true)
+}
+
+// writeRevision store the snapshot of the entity saved if the resource is
+// versioned. The last revision is read with lock where the dialect has
+// FOR UPDATE (the others lock the entity saved or the whole db) and the
+// unique index reject the same revision written by two saves.
+func writeRevision(db *gorm.DB, entity interface{}) error {
+	if _, ok := db.Get(versioningKey); !ok {
+		return nil
+	}
+	scope := db.NewScope(entity)
+	saved := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+	ret := db.New().Unscoped().Where(scope.PrimaryKey()+" = ?", scope.PrimaryKeyValue()).First(saved)
+	if ret.Error != nil {
+		return ret.Error
+	}
+	snapshot, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	revision := Revision{
+		Resource: scope.TableName(),
+		EntityID: fmt.Sprint(scope.PrimaryKeyValue()),
+	}
+	var last Revision
+	query := db.New().Where("resource = ? AND entity_id = ?", revision.Resource, revision.EntityID).Order("rev desc")
+	if name := db.Dialect().GetName(); name == "postgres" || name == "mysql" {
+		query = query.Set("gorm:query_option", "FOR UPDATE")
+	}
+	if ret := query.First(&last); ret.Error != nil && !ret.RecordNotFound() {
+		return ret.Error
+	}
+	revision.Rev = last.Rev + 1
+	revision.Snapshot = string(snapshot)
+	if principal := PrincipalFromDB(db); principal != nil {
+		revision.Principal = principal.Subject
+	}
+	return db.New().Create(&revision).Error
+}
+
+// loadVersioned load the entity of the request (also soft deleted) and check
+// the authorization of op, it writes the error and returns nil on failure
+func loadVersioned(w http.ResponseWriter, r *http.Request, db *gorm.DB, elem interface{}, id string, op Operation) interface{} {
+	if _, ok := db.Get(versioningKey); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorCrud{Message: "Revisions Not Found", Code: http.StatusNotFound})
+		return nil
+	}
+	entity := reflect.New(reflect.TypeOf(elem)).Interface()
+	if ScopeTenant(db.Unscoped(), entity).Where("id = ?", id).First(entity).RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound})
+		return nil
+	}
+	if err := authorize(r.Context(), db, op, entity); err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	return entity
+}
+
+// loadRevision return the revision rev of the entity
+func loadRevision(w http.ResponseWriter, db *gorm.DB, entity interface{}, id string, rev string) *Revision {
+	revision := &Revision{}
+	ret := db.New().Where("resource = ? AND entity_id = ? AND rev = ?", db.NewScope(entity).TableName(), id, rev).First(revision)
+	if ret.RowsAffected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorCrud{Message: "Revision Not Found", Code: http.StatusNotFound})
+		return nil
+	}
+	return revision
+}
+
+// Revisions return the list of revisions of the entity, the last first
+func Revisions(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := loadVersioned(w, r, db, elem, id, OpRevisions)
+		if entity == nil {
+			return
+		}
+		revisions := []Revision{}
+		db.New().Where("resource = ? AND entity_id = ?", db.NewScope(entity).TableName(), id).
+			Order("rev desc").Find(&revisions)
+		json.NewEncoder(w).Encode(revisions)
+	}
+}
+
+// GetRevision return the entity as it was in the revision of the path
+func GetRevision(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := loadVersioned(w, r, db, elem, id, OpRevisions)
+		if entity == nil {
+			return
+		}
+		revision := loadRevision(w, db, entity, id, PathParam(r, "rev"))
+		if revision == nil {
+			return
+		}
+		old := reflect.New(reflect.TypeOf(elem)).Interface()
+		if err := json.Unmarshal([]byte(revision.Snapshot), old); err != nil {
+			WriteError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(publicValue(r.Context(), old))
+	}
+}
+
+// Revert save the entity as it was in the revision of the path, with the
+// same validation of Save. The soft deleted entities are reverted too, with
+// deleted_at of the revision.
+func Revert(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		db := db.Set("gorm:auto_preload", true).
+			Set("gorm:association_autoupdate", false).
+			Set("gorm:association_autocreate", false)
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := loadVersioned(w, r, db, elem, id, OpSave)
+		if entity == nil {
+			return
+		}
+		revision := loadRevision(w, db, entity, id, PathParam(r, "rev"))
+		if revision == nil {
+			return
+		}
+		old := reflect.New(reflect.TypeOf(elem)).Interface()
+		if err := json.Unmarshal([]byte(revision.Snapshot), old); err != nil {
+			WriteError(w, err)
+			return
+		}
+		// the update of Save without Unscoped skip the soft deleted entity
+		// and then insert it again
+		ret, err := saveEntity(r, db.Unscoped(), old)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		ret.Value = publicValue(r.Context(), ret.Value)
+		json.NewEncoder(w).Encode(ret)
+	}
+}
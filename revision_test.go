@@ -0,0 +1,78 @@
+package gormcrud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func newVersionedMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t)
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/note", Note{}, []Note{}).Versioned().Full()
+	return db, r
+}
+
+func TestRevisions(t *testing.T) {
+	_, r := newVersionedMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"id":1,"title":"b"}`), http.StatusOK)
+
+	var revisions []Revision
+	decode(t, serve(r, "GET", "/note/1/revisions", ""), &revisions)
+	if len(revisions) != 2 || revisions[0].Rev != 2 || revisions[1].Rev != 1 {
+		t.Fatalf("revisions %+v", revisions)
+	}
+	var note Note
+	decode(t, serve(r, "GET", "/note/1/revisions/1", ""), &note)
+	if note.Title != "a" {
+		t.Fatalf("revision 1 %+v", note)
+	}
+	expectCode(t, serve(r, "GET", "/note/1/revisions/9", ""), http.StatusNotFound)
+	expectCode(t, serve(r, "GET", "/note/9/revisions", ""), http.StatusNotFound)
+
+	expectCode(t, serve(r, "POST", "/note/1/revisions/1/revert", ""), http.StatusOK)
+	decode(t, serve(r, "GET", "/note/1", ""), &note)
+	if note.Title != "a" {
+		t.Fatalf("reverted %+v", note)
+	}
+	decode(t, serve(r, "GET", "/note/1/revisions", ""), &revisions)
+	if len(revisions) != 3 || revisions[0].Rev != 3 {
+		t.Fatalf("revisions after revert %+v", revisions)
+	}
+}
+
+func TestRevertSoftDeleted(t *testing.T) {
+	db, r := newVersionedMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"id":1,"title":"b"}`), http.StatusOK)
+	expectCode(t, serve(r, "DELETE", "/note/1", ""), http.StatusOK)
+
+	expectCode(t, serve(r, "POST", "/note/1/revisions/1/revert", ""), http.StatusOK)
+	var notes []Note
+	db.Unscoped().Find(&notes)
+	if len(notes) != 1 || notes[0].Title != "a" || notes[0].DeletedAt != nil {
+		t.Fatalf("notes after revert %+v", notes)
+	}
+}
+
+func TestRevisionUnique(t *testing.T) {
+	db, _ := newVersionedMux(t)
+	if err := db.Create(&Revision{Resource: "note", EntityID: "1", Rev: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&Revision{Resource: "note", EntityID: "1", Rev: 1}).Error; err == nil {
+		t.Fatal("same revision written twice")
+	}
+}
+
+func TestRevisionsNotVersioned(t *testing.T) {
+	db := openTestDB(t)
+	db.Create(&Note{Title: "a"})
+	w := httptest.NewRecorder()
+	Revisions(db, Note{})(w, httptest.NewRequest("GET", "/note/1/revisions", nil), "1")
+	expectCode(t, w, http.StatusNotFound)
+}
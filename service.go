@@ -0,0 +1,203 @@
+package gormcrud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	"github.com/biezhi/gorm-paginator/pagination"
+	"github.com/jinzhu/gorm"
+)
+
+// Service is the pipeline of one resource for the protocols that are not the
+// routes of REST (gRPC, JSON-RPC, code): the same authentication, tenant,
+// authorization, hooks, validation, audit and events of the handlers. Each
+// call has the request with the context and the headers (see NewRequest).
+type Service struct {
+	db    *gorm.DB
+	elem  interface{}
+	authn Authenticator
+}
+
+// NewRequest return the request of one call that is not HTTP with the
+// context and the headers (for example the metadata of gRPC), it is used by
+// the authenticators and the tenant resolvers
+func NewRequest(ctx context.Context, header http.Header) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/", nil)
+	if header != nil {
+		r.Header = header
+	}
+	return r.WithContext(ctx)
+}
+
+// New return a new entity of the resource
+func (s *Service) New() interface{} {
+	return reflect.New(reflect.TypeOf(s.elem)).Interface()
+}
+
+// Resource return the name (the table) of the resource
+func (s *Service) Resource() string {
+	return s.db.NewScope(s.New()).TableName()
+}
+
+// request authenticate the request if it has not the principal yet and
+// return it with the db of the request
+func (s *Service) request(r *http.Request) (*http.Request, *gorm.DB, error) {
+	if s.authn != nil && PrincipalFromContext(r.Context()) == nil {
+		principal, err := s.authn.Authenticate(r)
+		if err == nil && principal == nil {
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			return r, nil, err
+		}
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+	}
+	db := s.db.Set("gorm:auto_preload", true).
+		Set("gorm:association_autoupdate", false).
+		Set("gorm:association_autocreate", false)
+	db, err := requestDB(db, r)
+	return r, db, err
+}
+
+// publicEntity return the copy of the entity without the fields hidden by the
+// tag crud for the roles of ctx
+func publicEntity(ctx context.Context, entity interface{}) interface{} {
+	raw, ok := publicValue(ctx, entity).(json.RawMessage)
+	if !ok {
+		return entity
+	}
+	public := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+	if json.Unmarshal(raw, public) != nil {
+		return entity
+	}
+	return public
+}
+
+// The errors of the methods of Service are ErrorCrud for the errors of the
+// pipeline (authorization, validation, not found) and the errors of the db.
+
+// Get return the entity with the id
+func (s *Service) Get(r *http.Request, id string) (interface{}, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	entity := s.New()
+	if ScopeTenant(db, entity).Where("id = ?", id).First(entity).RowsAffected == 0 {
+		return nil, ErrorCrud{Message: "Status Not Found", Code: http.StatusNotFound}
+	}
+	if err := authorize(r.Context(), db, OpGet, entity); err != nil {
+		return nil, err
+	}
+	if err := afterRead(r.Context(), db, entity); err != nil {
+		return nil, err
+	}
+	return publicEntity(r.Context(), entity), nil
+}
+
+// All return the entities with the filter of the lists, it is a pointer to
+// slice of the entities
+func (s *Service) All(r *http.Request, query url.Values) (interface{}, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	entities := reflect.New(reflect.SliceOf(reflect.TypeOf(s.elem))).Interface()
+	filter, err := ParseFilter(r.Context(), db, entities, query)
+	if err != nil {
+		return nil, err
+	}
+	db = filter.Apply(ScopeTenant(db, entities), entities)
+	if err := db.Find(entities).Error; err != nil {
+		return nil, err
+	}
+	if err := authorize(r.Context(), db, OpAll, entities); err != nil {
+		return nil, err
+	}
+	if err := afterRead(r.Context(), db, entities); err != nil {
+		return nil, err
+	}
+	return publicEntity(r.Context(), entities), nil
+}
+
+// Page return the page of the entities with the filter of the lists, the
+// records are a pointer to slice of the entities
+func (s *Service) Page(r *http.Request, page int, limit int, query url.Values) (*pagination.Paginator, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	entities := reflect.New(reflect.SliceOf(reflect.TypeOf(s.elem))).Interface()
+	filter, err := ParseFilter(r.Context(), db, entities, query)
+	if err != nil {
+		return nil, err
+	}
+	db = filter.Apply(ScopeTenant(db, entities), entities)
+	ret := pagination.Paging(&pagination.Param{
+		DB:      db,
+		Page:    page,
+		Limit:   limit,
+		OrderBy: []string{"id desc"},
+	}, entities)
+	if err := authorize(r.Context(), db, OpPage, entities); err != nil {
+		return nil, err
+	}
+	if err := afterRead(r.Context(), db, entities); err != nil {
+		return nil, err
+	}
+	ret.Records = publicEntity(r.Context(), entities)
+	return ret, nil
+}
+
+// Save create or update the entity, it must be a pointer to the entity of
+// the resource
+func (s *Service) Save(r *http.Request, entity interface{}) (interface{}, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	if reflect.TypeOf(entity) != reflect.TypeOf(s.New()) {
+		return nil, ErrorCrud{Message: "Invalid Entity", Code: http.StatusBadRequest}
+	}
+	if _, err := saveEntity(r, db, entity); err != nil {
+		return nil, toErrorCrud(err)
+	}
+	return publicEntity(r.Context(), entity), nil
+}
+
+// Delete delete the entity with the id, purge delete it permanently
+func (s *Service) Delete(r *http.Request, id string, purge bool) (interface{}, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	if purge {
+		db = db.Unscoped()
+	}
+	entity := s.New()
+	if err := deleteEntity(r, db, entity, id, purge); err != nil {
+		return nil, toErrorCrud(err)
+	}
+	return publicEntity(r.Context(), entity), nil
+}
+
+// Link link (op "link") or unlink (op "unlink") the entities of links (field
+// -> ids) to the entity with the id. If one of them fails none is applied and
+// the error 409 is returned with the status of each link.
+func (s *Service) Link(r *http.Request, id string, op string, links url.Values) (map[string]LinkStatusCrud, error) {
+	r, db, err := s.request(r)
+	if err != nil {
+		return nil, err
+	}
+	if op != string(OpLink) && op != string(OpUnlink) {
+		return nil, ErrorCrud{Message: "Invalid Operation " + op, Code: http.StatusBadRequest}
+	}
+	result, _, err := linkEntity(r, db, s.New(), id, op, links)
+	if err == errLinkRollback {
+		return result, ErrorCrud{Message: "Link Rollback", Code: http.StatusConflict}
+	}
+	return result, err
+}
@@ -0,0 +1,165 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OpEvents is the operation of the change feed
+const OpEvents Operation = "events"
+
+// feedBuffer is the number of events waiting for one slow client, when it is
+// full the stream is closed and the client resumes with Last-Event-ID
+const feedBuffer = 64
+
+// feedPing is the interval of the comments that keep the stream open
+const feedPing = 15 * time.Second
+
+// feedSubscription is one subscription to the events of one resource for one
+// request, with the filter, the tenant and the authorization of the request
+type feedSubscription struct {
+	db       *gorm.DB
+	r        *http.Request
+	elem     interface{}
+	resource string
+	filter   Filter
+	tenant   string
+}
+
+// newFeedSubscription return the subscription to the events of elem with the
+// filter of the query string
+func newFeedSubscription(db *gorm.DB, r *http.Request, elem interface{}, filter Filter) feedSubscription {
+	sub := feedSubscription{
+		db:       db,
+		r:        r,
+		elem:     elem,
+		resource: db.NewScope(reflect.New(reflect.TypeOf(elem)).Interface()).TableName(),
+		filter:   filter,
+	}
+	if tenant, ok := db.Get(tenantKey); ok {
+		sub.tenant = fmt.Sprint(tenant)
+	}
+	return sub
+}
+
+// visible return the event with the data for the request if the request can
+// receive it
+func (sub feedSubscription) visible(e Event) (Event, bool) {
+	if e.Resource != sub.resource || (sub.tenant != "" && e.Tenant != sub.tenant) || !sub.filter.Match(e.Data) {
+		return e, false
+	}
+	entity := reflect.New(reflect.TypeOf(sub.elem)).Interface()
+	if json.Unmarshal(e.Data, entity) != nil {
+		return e, false
+	}
+	if authorize(sub.r.Context(), sub.db, OpEvents, entity) != nil {
+		return e, false
+	}
+	e.Data, _ = json.Marshal(publicValue(sub.r.Context(), entity))
+	return e, true
+}
+
+// writeEvent write the event in the format of server-sent events
+func writeEvent(w http.ResponseWriter, e Event) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// Events stream the creations, updates, deletions, links and unlinks of the
+// entities as server-sent events. The query string is the filter of the
+// lists. With the header Last-Event-ID the events retained by the bus after
+// it are sent first (see EventBus.Retain), if it is not retained the event
+// "reset" is sent and the client must reload.
+func Events(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		w.Header().Set("Content-Type", "application/json")
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		value, ok := db.Get(busKey)
+		if !ok {
+			WriteError(w, ErrorCrud{Message: "Events Not Found", Code: http.StatusNotFound})
+			return
+		}
+		bus := value.(*EventBus)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteError(w, ErrorCrud{Message: "Streaming Not Supported", Code: http.StatusInternalServerError})
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		if err := authorize(r.Context(), db, OpEvents, entity); err != nil {
+			WriteError(w, err)
+			return
+		}
+		filter, err := ParseFilter(r.Context(), db, entity, r.URL.Query())
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		sub := newFeedSubscription(db, r, elem, filter)
+
+		events := make(chan Event, feedBuffer)
+		overflow := make(chan struct{})
+		var once sync.Once
+		unsubscribe := bus.Subscribe(func(e Event) {
+			select {
+			case events <- e:
+			default:
+				once.Do(func() { close(overflow) })
+			}
+		})
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		sent := map[string]bool{}
+		if last := r.Header.Get("Last-Event-ID"); last != "" {
+			missed, ok := bus.Since(last)
+			if !ok {
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			}
+			for _, e := range missed {
+				sent[e.ID] = true
+				if e, ok := sub.visible(e); ok {
+					writeEvent(w, e)
+				}
+			}
+		}
+		flusher.Flush()
+
+		ping := time.NewTicker(feedPing)
+		defer ping.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-overflow:
+				return
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case e := <-events:
+				if sent[e.ID] {
+					delete(sent, e.ID)
+					continue
+				}
+				e, ok := sub.visible(e)
+				if !ok {
+					continue
+				}
+				writeEvent(w, e)
+			}
+			flusher.Flush()
+		}
+	}
+}
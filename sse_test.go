@@ -0,0 +1,161 @@
+package gormcrud
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// feedEvent is one event read from the stream
+type feedEvent struct {
+	ID    string
+	Type  string
+	Event Event
+}
+
+// openFeed connect to the change feed of the server and return the channel
+// of the events read, it is closed at the end of the stream
+func openFeed(t *testing.T, url string, header ...string) (<-chan feedEvent, func()) {
+	req, _ := http.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d %v", resp.StatusCode, resp.Header)
+	}
+	events := make(chan feedEvent, 10)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		var e feedEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				e.ID = line[len("id: "):]
+			case strings.HasPrefix(line, "event: "):
+				e.Type = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[len("data: "):]), &e.Event)
+			case line == "" && e.Type != "":
+				events <- e
+				e = feedEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// nextEvent return the next event of the feed
+func nextEvent(t *testing.T, events <-chan feedEvent) feedEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+	}
+	return feedEvent{}
+}
+
+func newFeedServer(t *testing.T, bus *EventBus) (*httptest.Server, *mux.Router) {
+	db := openTestDB(t, &Member{})
+	r := mux.NewRouter()
+	m := MapMux(r, db)
+	if bus != nil {
+		m = m.PublishTo(bus)
+	}
+	m.NewMap("/note", Note{}, []Note{}).Events().Full().
+		NewMap("/member", Member{}, []Member{}).Events().Full()
+	return httptest.NewServer(r), r
+}
+
+func TestEvents(t *testing.T) {
+	srv, r := newFeedServer(t, NewEventBus())
+	defer srv.Close()
+	events, cancel := openFeed(t, srv.URL+"/note/events?title[like]=a%25")
+	defer cancel()
+
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/member", `{"login":"x","password":"p"}`), http.StatusOK)
+	expectCode(t, serve(r, "DELETE", "/note/2", ""), http.StatusOK)
+
+	e := nextEvent(t, events)
+	if e.Type != "note.create" || e.Event.EntityID != "2" || e.ID != e.Event.ID {
+		t.Fatalf("event %+v", e)
+	}
+	if e = nextEvent(t, events); e.Type != "note.delete" || e.Event.EntityID != "2" {
+		t.Fatalf("event %+v", e)
+	}
+}
+
+func TestEventsPublicFields(t *testing.T) {
+	srv, r := newFeedServer(t, NewEventBus())
+	defer srv.Close()
+	events, cancel := openFeed(t, srv.URL+"/member/events")
+	defer cancel()
+	expectCode(t, serve(r, "POST", "/member", `{"login":"x","password":"p"}`), http.StatusOK)
+	e := nextEvent(t, events)
+	if e.Type != "member.create" || strings.Contains(string(e.Event.Data), "password") {
+		t.Fatalf("event %+v %s", e, e.Event.Data)
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	bus := NewEventBus().Retain(2)
+	srv, r := newFeedServer(t, bus)
+	defer srv.Close()
+	for _, title := range []string{"a", "b", "c"} {
+		expectCode(t, serve(r, "POST", "/note", `{"title":"`+title+`"}`), http.StatusOK)
+	}
+	retained := bus.log[0].ID
+
+	events, cancel := openFeed(t, srv.URL+"/note/events", "Last-Event-ID", retained)
+	if e := nextEvent(t, events); e.Event.EntityID != "3" {
+		t.Fatalf("resumed %+v", e)
+	}
+	cancel()
+
+	events, cancel = openFeed(t, srv.URL+"/note/events", "Last-Event-ID", "lost")
+	defer cancel()
+	if e := nextEvent(t, events); e.Type != "reset" {
+		t.Fatalf("event %+v", e)
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	srv, _ := newFeedServer(t, nil)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/note/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("feed without bus %d", resp.StatusCode)
+	}
+
+	srv, _ = newFeedServer(t, NewEventBus())
+	defer srv.Close()
+	if resp, err = http.Get(srv.URL + "/note/events?other=1"); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid filter %d", resp.StatusCode)
+	}
+}
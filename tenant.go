@@ -0,0 +1,204 @@
+package gormcrud
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// tenantColumn is the column of the tenant in all the tables
+const tenantColumn = "tenant_id"
+
+// keys of the tenant in the settings of gorm.DB
+const (
+	tenantResolverKey = "gormcrud:tenant_resolver"
+	tenantKey         = "gormcrud:tenant"
+)
+
+// TenantResolver return the tenant of the request
+type TenantResolver func(r *http.Request) (interface{}, error)
+
+// ErrTenantNotFound is returned when the request is without tenant
+var ErrTenantNotFound = ErrorCrud{Message: "Tenant Not Found", Code: http.StatusUnauthorized}
+
+// TenantFromHeader return the resolver of the tenant from the header name
+func TenantFromHeader(name string) TenantResolver {
+	return func(r *http.Request) (interface{}, error) {
+		tenant := r.Header.Get(name)
+		if tenant == "" {
+			return nil, ErrTenantNotFound
+		}
+		return tenant, nil
+	}
+}
+
+// TenantFromContext return the resolver of the tenant from the value key of
+// the context of request (set by a middleware)
+func TenantFromContext(key interface{}) TenantResolver {
+	return func(r *http.Request) (interface{}, error) {
+		tenant := r.Context().Value(key)
+		if tenant == nil {
+			return nil, ErrTenantNotFound
+		}
+		return tenant, nil
+	}
+}
+
+// withTenant set the tenant resolver in the settings of db
+func withTenant(db *gorm.DB, resolver TenantResolver) *gorm.DB {
+	if resolver == nil {
+		return db
+	}
+	return db.Set(tenantResolverKey, resolver)
+}
+
+// resolveTenant resolve the tenant of the request and return db with the
+// tenant in the settings
+func resolveTenant(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	resolver, ok := db.Get(tenantResolverKey)
+	if !ok {
+		return db, nil
+	}
+	tenant, err := resolver.(TenantResolver)(r)
+	if err != nil {
+		return db, err
+	}
+	return db.Set(tenantKey, tenant), nil
+}
+
+// hasTenant return true if the entity have the column tenant_id
+func hasTenant(db *gorm.DB, entity interface{}) bool {
+	_, ok := db.NewScope(entity).FieldByName(tenantColumn)
+	return ok
+}
+
+// ScopeTenant add the condition of the tenant of the request to the query of
+// entity. The entities without column tenant_id are not filtered. The
+// relations preloaded by gorm:auto_preload are filtered by the tenant too.
+func ScopeTenant(db *gorm.DB, entity interface{}) *gorm.DB {
+	tenant, ok := db.Get(tenantKey)
+	if !ok {
+		return db
+	}
+	db = preloadTenant(db, entity)
+	if !hasTenant(db, entity) {
+		return db
+	}
+	table := db.NewScope(entity).QuotedTableName()
+	return db.Where(table+"."+tenantColumn+" = ?", tenant)
+}
+
+// forceTenant set the tenant of the request on the entity to write
+func forceTenant(db *gorm.DB, entity interface{}) error {
+	tenant, ok := db.Get(tenantKey)
+	if !ok {
+		return nil
+	}
+	field, ok := db.NewScope(entity).FieldByName(tenantColumn)
+	if !ok {
+		return nil
+	}
+	value := reflect.ValueOf(tenant)
+	fieldType := reflect.Indirect(field.Field).Type()
+	if _, isString := tenant.(string); !isString && fieldType.Kind() == reflect.String {
+		value = reflect.ValueOf(fmt.Sprint(tenant))
+	}
+	if s, isString := tenant.(string); isString && fieldType.Kind() != reflect.String {
+		switch fieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ErrTenantNotFound
+			}
+			value = reflect.ValueOf(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return ErrTenantNotFound
+			}
+			value = reflect.ValueOf(u)
+		}
+	}
+	return field.Set(value.Interface())
+}
+
+// otherTenant return true if the entity to update exists but it is of other
+// tenant, so for the request it does not exist
+func otherTenant(db *gorm.DB, entity interface{}) bool {
+	if _, ok := db.Get(tenantKey); !ok || !hasTenant(db, entity) {
+		return false
+	}
+	scope := db.NewScope(entity)
+	if scope.PrimaryKeyZero() {
+		return false
+	}
+	exist := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+	where := scope.QuotedTableName() + "." + scope.Quote(scope.PrimaryKey()) + " = ?"
+	if db.Unscoped().Where(where, scope.PrimaryKeyValue()).First(exist).RowsAffected == 0 {
+		return false
+	}
+	return ScopeTenant(db.Unscoped(), entity).Where(where, scope.PrimaryKeyValue()).First(exist).RowsAffected == 0
+}
+
+// preloadTenant replace gorm:auto_preload with the preload of each relation
+// of entity scoped by the tenant of db, as gorm:auto_preload the relations of
+// the relations are preloaded too
+func preloadTenant(db *gorm.DB, entity interface{}) *gorm.DB {
+	if preload, ok := db.Get("gorm:auto_preload"); !ok || preload != true {
+		return db
+	}
+	tenant, _ := db.Get(tenantKey)
+	db = db.Set("gorm:auto_preload", false)
+	for _, field := range db.NewScope(entity).GetStructFields() {
+		if field.Relationship == nil {
+			continue
+		}
+		if value, ok := field.TagSettingsGet("PRELOAD"); ok {
+			if preload, err := strconv.ParseBool(value); err == nil && !preload {
+				continue
+			}
+		}
+		related := reflect.New(baseType(field.Struct.Type)).Interface()
+		db = db.Preload(field.Name, func(preload *gorm.DB) *gorm.DB {
+			return ScopeTenant(preload.Set(tenantKey, tenant).Set("gorm:auto_preload", true), related)
+		})
+	}
+	return db
+}
+
+// otherTenantRelations return the error if one belongs to relation of the
+// entity to write references one entity of other tenant
+func otherTenantRelations(db *gorm.DB, entity interface{}) error {
+	if _, ok := db.Get(tenantKey); !ok {
+		return nil
+	}
+	scope := db.NewScope(entity)
+	for _, field := range scope.Fields() {
+		rel := field.Relationship
+		if rel == nil || rel.Kind != "belongs_to" {
+			continue
+		}
+		related := reflect.New(baseType(field.Struct.Type)).Interface()
+		if !hasTenant(db, related) {
+			continue
+		}
+		table := db.NewScope(related).QuotedTableName()
+		query := ScopeTenant(db.Unscoped().Set("gorm:auto_preload", false), related)
+		blank := true
+		for i, name := range rel.ForeignFieldNames {
+			foreign, ok := scope.FieldByName(name)
+			if !ok {
+				continue
+			}
+			blank = blank && foreign.IsBlank
+			query = query.Where(table+"."+scope.Quote(rel.AssociationForeignDBNames[i])+" = ?", foreign.Field.Interface())
+		}
+		if !blank && query.First(related).RowsAffected == 0 {
+			return ErrorCrud{Message: field.Name + " Not Found", Code: http.StatusBadRequest}
+		}
+	}
+	return nil
+}
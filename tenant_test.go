@@ -0,0 +1,126 @@
+package gormcrud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Account is of one tenant
+type Account struct {
+	ID       uint   `gorm:"primary_key" json:"id"`
+	TenantID uint   `json:"tenant_id"`
+	Name     string `json:"name"`
+}
+
+func newAccountMux(t *testing.T) (*gorm.DB, *mux.Router) {
+	db := openTestDB(t, &Account{})
+	db.Create(&Account{TenantID: 1, Name: "a"})
+	db.Create(&Account{TenantID: 2, Name: "b"})
+	r := mux.NewRouter()
+	MapMux(r, db).TenantBy(TenantFromHeader("X-Tenant")).
+		NewMap("/account", Account{}, []Account{}).Full().
+		NewMap("/note", Note{}, []Note{}).Full()
+	return db, r
+}
+
+func TestTenantScope(t *testing.T) {
+	_, r := newAccountMux(t)
+	expectCode(t, serve(r, "GET", "/account", ""), http.StatusUnauthorized)
+
+	var accounts []Account
+	decode(t, serve(r, "GET", "/account", "", "X-Tenant", "1"), &accounts)
+	if len(accounts) != 1 || accounts[0].Name != "a" {
+		t.Fatalf("accounts of tenant 1 %+v", accounts)
+	}
+	expectCode(t, serve(r, "GET", "/account/1", "", "X-Tenant", "1"), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/account/2", "", "X-Tenant", "1"), http.StatusNotFound)
+	expectCode(t, serve(r, "DELETE", "/account/2", "", "X-Tenant", "1"), http.StatusNotFound)
+	expectCode(t, serve(r, "GET", "/account/2/link?x=1", "", "X-Tenant", "1"), http.StatusNotFound)
+
+	// the entities without tenant_id are not filtered
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`, "X-Tenant", "1"), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/note/1", "", "X-Tenant", "2"), http.StatusOK)
+}
+
+func TestTenantWrite(t *testing.T) {
+	db, r := newAccountMux(t)
+	// the tenant of the body is replaced with the tenant of the request
+	var account Account
+	w := serve(r, "POST", "/account", `{"tenant_id":2,"name":"c"}`, "X-Tenant", "1")
+	expectCode(t, w, http.StatusOK)
+	db.Last(&account)
+	if account.TenantID != 1 {
+		t.Fatalf("tenant of the new account %d", account.TenantID)
+	}
+	// the account of other tenant can not be updated
+	expectCode(t, serve(r, "POST", "/account", `{"id":2,"name":"x"}`, "X-Tenant", "1"), http.StatusNotFound)
+	var other Account
+	db.First(&other, 2)
+	if other.Name != "b" || other.TenantID != 2 {
+		t.Fatalf("account of tenant 2 updated %+v", other)
+	}
+	expectCode(t, serve(r, "POST", "/account", `{"tenant_id":1,"name":"x"}`, "X-Tenant", "x"), http.StatusUnauthorized)
+}
+
+// Contract is of one tenant and references one account
+type Contract struct {
+	ID        uint     `gorm:"primary_key" json:"id"`
+	TenantID  uint     `json:"tenant_id"`
+	Name      string   `json:"name"`
+	AccountID uint     `json:"account_id"`
+	Account   *Account `json:"account"`
+}
+
+func TestTenantRelations(t *testing.T) {
+	db := openTestDB(t, &Account{}, &Contract{})
+	db.Create(&Account{TenantID: 1, Name: "a"})
+	db.Create(&Account{TenantID: 2, Name: "b"})
+	db.Create(&Contract{TenantID: 1, Name: "own", AccountID: 1})
+	db.Create(&Contract{TenantID: 1, Name: "other", AccountID: 2})
+	r := mux.NewRouter()
+	MapMux(r, db).TenantBy(TenantFromHeader("X-Tenant")).NewMap("/contract", Contract{}, []Contract{}).Full()
+
+	// the account of other tenant is not preloaded
+	var contracts []Contract
+	decode(t, serve(r, "GET", "/contract", "", "X-Tenant", "1"), &contracts)
+	if len(contracts) != 2 || contracts[0].Account == nil || contracts[0].Account.Name != "a" || contracts[1].Account != nil {
+		t.Fatalf("contracts of tenant 1 %+v", contracts)
+	}
+	var contract Contract
+	decode(t, serve(r, "GET", "/contract/2", "", "X-Tenant", "1"), &contract)
+	if contract.Name != "other" || contract.Account != nil {
+		t.Fatalf("contract with account of tenant 2 %+v", contract)
+	}
+
+	// the account of other tenant can not be referenced
+	expectCode(t, serve(r, "POST", "/contract", `{"name":"x","account_id":2}`, "X-Tenant", "1"), http.StatusBadRequest)
+	expectCode(t, serve(r, "POST", "/contract", `{"id":1,"name":"x","account_id":2}`, "X-Tenant", "1"), http.StatusBadRequest)
+	expectCode(t, serve(r, "POST", "/contract", `{"name":"x","account_id":9}`, "X-Tenant", "1"), http.StatusBadRequest)
+	expectCode(t, serve(r, "POST", "/contract", `{"name":"x","account_id":1}`, "X-Tenant", "1"), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/contract", `{"name":"y"}`, "X-Tenant", "1"), http.StatusOK)
+	var own Contract
+	db.First(&own, 1)
+	if own.AccountID != 1 || own.Name != "own" {
+		t.Fatalf("contract updated %+v", own)
+	}
+}
+
+func TestTenantResolvers(t *testing.T) {
+	type key struct{}
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := TenantFromContext(key{})(r); err != ErrTenantNotFound {
+		t.Fatalf("error %v", err)
+	}
+	tenant, err := TenantFromContext(key{})(r.WithContext(context.WithValue(r.Context(), key{}, 7)))
+	if err != nil || tenant != 7 {
+		t.Fatalf("tenant %v %v", tenant, err)
+	}
+	if _, err := TenantFromHeader("X-Tenant")(r); err != ErrTenantNotFound {
+		t.Fatalf("error %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build tools
+// +build tools
+
+package gormcrud
+
+// the packages of the code generated by GenerateProto and the plugins of
+// protoc, they are required by the module for the tests of the generated
+// server (see TestGenerateGRPCServe)
+import (
+	_ "google.golang.org/grpc"
+	_ "google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/metadata"
+	_ "google.golang.org/grpc/status"
+	_ "google.golang.org/grpc/test/bufconn"
+	_ "google.golang.org/protobuf/cmd/protoc-gen-go"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
@@ -0,0 +1,98 @@
+package gormcrud
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+// deletedAtField is the name of the field used by gorm for soft delete
+const deletedAtField = "DeletedAt"
+
+// isSoftDelete return true if gorm make soft delete for the entity type
+func isSoftDelete(t reflect.Type) bool {
+	t = baseType(t)
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := t.FieldByName(deletedAtField)
+	return ok
+}
+
+// Trashed apply the querystring trashed to the query of the entity:
+// trashed=with return also the soft deleted entities and trashed=only return
+// only the soft deleted entities. The other values and the entities without
+// soft delete are 400.
+func Trashed(db *gorm.DB, r *http.Request, entity interface{}) (*gorm.DB, error) {
+	trashed := r.URL.Query().Get("trashed")
+	if trashed == "" {
+		return db, nil
+	}
+	if trashed != "with" && trashed != "only" {
+		return nil, ErrorCrud{Message: "Invalid trashed " + trashed, Code: http.StatusBadRequest}
+	}
+	if !isSoftDelete(reflect.TypeOf(entity)) {
+		return nil, ErrorCrud{Message: "Entity without soft delete", Code: http.StatusBadRequest}
+	}
+	if trashed == "only" {
+		return db.Unscoped().Where(db.NewScope(entity).QuotedTableName() + ".deleted_at IS NOT NULL"), nil
+	}
+	return db.Unscoped(), nil
+}
+
+// Restore is operation for undelete one soft deleted entity
+func Restore(db *gorm.DB, elem interface{}) func(w http.ResponseWriter, r *http.Request, id string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		db := db.Set("gorm:auto_preload", true).
+			Set("gorm:association_autoupdate", false).
+			Set("gorm:association_autocreate", false)
+		w.Header().Set("Content-Type", "application/json")
+		if !isSoftDelete(reflect.TypeOf(elem)) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorCrud{Message: "Entity without soft delete", Code: http.StatusBadRequest})
+			return
+		}
+		db, err := requestDB(db, r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		entity := reflect.New(reflect.TypeOf(elem)).Interface()
+		err = Transaction(db, func(tx *gorm.DB) error {
+			trashed := tx.Unscoped().Where(tx.NewScope(entity).QuotedTableName() + ".deleted_at IS NOT NULL")
+			if err := loadEntity(trashed, entity, id, "Status Not Found"); err != nil {
+				return err
+			}
+			if err := authorize(r.Context(), tx, OpRestore, entity); err != nil {
+				return err
+			}
+			before := auditSnapshot(tx, entity)
+			if err := beforeSave(r.Context(), tx, entity, false); err != nil {
+				return err
+			}
+			columns := map[string]interface{}{"deleted_at": nil}
+			if hasUpdatedAt(reflect.TypeOf(elem)) {
+				// the validators of the lists must see the restore
+				columns["updated_at"] = gorm.NowFunc()
+			}
+			if ret := tx.Unscoped().Model(entity).UpdateColumns(columns); ret.Error != nil {
+				return ret.Error
+			}
+			if err := afterSave(r.Context(), tx, entity, false); err != nil {
+				return err
+			}
+			if err := writeAudit(tx, "restore", entity, before); err != nil {
+				return err
+			}
+			return recordEvent(tx, "restore", entity)
+		})
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		db.Where("id = ?", id).First(entity)
+		json.NewEncoder(w).Encode(publicValue(r.Context(), entity))
+	}
+}
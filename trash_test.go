@@ -0,0 +1,102 @@
+package gormcrud
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Memo has not soft delete
+type Memo struct {
+	ID   uint   `gorm:"primary_key" json:"id"`
+	Text string `json:"text"`
+}
+
+// Locked can not be purged
+type Locked struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+func (Locked) CrudValidatePurge(db *gorm.DB) error {
+	return errors.New("locked")
+}
+
+func TestTrashedRestorePurge(t *testing.T) {
+	db, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"b"}`), http.StatusOK)
+	expectCode(t, serve(r, "DELETE", "/note/1", ""), http.StatusOK)
+
+	count := func(url string) int {
+		var notes []Note
+		w := serve(r, "GET", url, "")
+		expectCode(t, w, http.StatusOK)
+		decode(t, w, &notes)
+		return len(notes)
+	}
+	if n := count("/note"); n != 1 {
+		t.Fatalf("%d notes", n)
+	}
+	if n := count("/note?trashed=with"); n != 2 {
+		t.Fatalf("%d notes with trashed", n)
+	}
+	if n := count("/note?trashed=only"); n != 1 {
+		t.Fatalf("%d notes only trashed", n)
+	}
+
+	expectCode(t, serve(r, "POST", "/note/2/restore", ""), http.StatusNotFound)
+	expectCode(t, serve(r, "POST", "/note/1/restore", ""), http.StatusOK)
+	if n := count("/note"); n != 2 {
+		t.Fatalf("%d notes after restore", n)
+	}
+
+	expectCode(t, serve(r, "DELETE", "/note/1?purge=true", ""), http.StatusOK)
+	var rows int
+	db.Unscoped().Model(&Note{}).Where("id = 1").Count(&rows)
+	if rows != 0 {
+		t.Fatal("purge left the row")
+	}
+}
+
+func TestTrashedInvalid(t *testing.T) {
+	db, r := newTestMux(t)
+	db.AutoMigrate(&Memo{})
+	MapMux(r, db).NewMap("/memo", Memo{}, []Memo{}).Full()
+
+	expectCode(t, serve(r, "GET", "/note?trashed=all", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "GET", "/memo", ""), http.StatusOK)
+	expectCode(t, serve(r, "GET", "/memo?trashed=only", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "GET", "/memo.page?page=1&limit=5&trashed=with", ""), http.StatusBadRequest)
+	expectCode(t, serve(r, "POST", "/memo/1/restore", ""), http.StatusBadRequest)
+}
+
+func TestAllQueryError(t *testing.T) {
+	db, r := newTestMux(t)
+	db.DropTable(&Note{})
+	expectCode(t, serve(r, "GET", "/note", ""), http.StatusInternalServerError)
+}
+
+func TestPurgeValidation(t *testing.T) {
+	db := openTestDB(t, &Locked{})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/locked", Locked{}, []Locked{}).Full()
+	db.Create(&Locked{})
+
+	expectCode(t, serve(r, "DELETE", "/locked/1", ""), http.StatusOK)
+	w := serve(r, "DELETE", "/locked/1?purge=true", "")
+	expectCode(t, w, http.StatusBadRequest)
+	var e ErrorCrud
+	if decode(t, w, &e); e.Message != "locked" {
+		t.Fatalf("purge without validation error %s", w.Body.String())
+	}
+	var rows int
+	db.Unscoped().Model(&Locked{}).Count(&rows)
+	if rows != 1 {
+		t.Fatal("purge not rejected")
+	}
+}
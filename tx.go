@@ -0,0 +1,66 @@
+package gormcrud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// validationError mark the errors of ValidateSave, ValidateDelete and
+// ValidatePurge, they are written with the status 400 or the code of the
+// ErrorCrud returned by the validation
+type validationError struct {
+	error
+}
+
+// errLinkRollback abort the transaction of Link when one of links fails
+var errLinkRollback = errors.New("link rollback")
+
+// Transaction run fn in one transaction. The transaction is rolled back if fn
+// return error or panic, and committed otherwise. The events of the
+// operations in fn are published after the commit.
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var pending *[]Event
+	if _, ok := db.Get(busKey); ok {
+		pending = &[]Event{}
+		tx = tx.Set(pendingKey, pending)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			err = ErrorCrud{Message: fmt.Sprint(r), Code: http.StatusInternalServerError}
+		}
+	}()
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+	if pending != nil {
+		bus, _ := db.Get(busKey)
+		for _, e := range *pending {
+			bus.(*EventBus).Publish(e)
+		}
+	}
+	return nil
+}
+
+// loadEntity read the entity with the id of the tenant in the transaction of
+// the operation, the operations that change one entity read it in their
+// transaction so the checks see the same row that is changed. message is the
+// message of the error 404.
+func loadEntity(tx *gorm.DB, entity interface{}, id string, message string) error {
+	ret := ScopeTenant(tx, entity).Where(tx.NewScope(entity).QuotedTableName()+".id = ?", id).First(entity)
+	if ret.RowsAffected == 0 {
+		return ErrorCrud{Message: message, Code: http.StatusNotFound}
+	}
+	return ret.Error
+}
@@ -0,0 +1,167 @@
+package gormcrud
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// recordReads record for each read of the table if it runs in a transaction
+func recordReads(db *gorm.DB, table string) *[]bool {
+	reads := &[]bool{}
+	db.Callback().Query().After("gorm:query").Register("test:reads", func(scope *gorm.Scope) {
+		if scope.TableName() == table {
+			_, inTx := scope.SQLDB().(*sql.Tx)
+			*reads = append(*reads, inTx)
+		}
+	})
+	return reads
+}
+
+func expectReadsInTx(t *testing.T, reads *[]bool) {
+	t.Helper()
+	if len(*reads) == 0 {
+		t.Fatal("no reads")
+	}
+	for i, inTx := range *reads {
+		if !inTx {
+			t.Fatalf("read %d outside the transaction", i)
+		}
+	}
+	*reads = nil
+}
+
+func TestTransaction(t *testing.T) {
+	db := openTestDB(t)
+	errAbort := errors.New("abort")
+	err := Transaction(db, func(tx *gorm.DB) error {
+		tx.Create(&Note{Title: "a"})
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("error %v", err)
+	}
+	err = Transaction(db, func(tx *gorm.DB) error {
+		tx.Create(&Note{Title: "b"})
+		panic("boom")
+	})
+	if errCrud, ok := err.(ErrorCrud); !ok || errCrud.Code != http.StatusInternalServerError {
+		t.Fatalf("error of panic %v", err)
+	}
+	if err := Transaction(db, func(tx *gorm.DB) error { return tx.Create(&Note{Title: "c"}).Error }); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	db.Model(&Note{}).Pluck("title", &titles)
+	if len(titles) != 1 || titles[0] != "c" {
+		t.Fatalf("rows %v", titles)
+	}
+}
+
+func TestWritesReadInTransaction(t *testing.T) {
+	db, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/tag", `{"name":"x"}`), http.StatusOK)
+	reads := recordReads(db, "note")
+
+	expectCode(t, serve(r, "GET", "/note/1/link?tags=1", ""), http.StatusOK)
+	expectReadsInTx(t, reads)
+	expectCode(t, serve(r, "DELETE", "/note/1", ""), http.StatusOK)
+	expectReadsInTx(t, reads)
+	expectCode(t, serve(r, "POST", "/note/1/restore", ""), http.StatusOK)
+	// the response of restore is read after the commit
+	*reads = (*reads)[:len(*reads)-1]
+	expectReadsInTx(t, reads)
+}
+
+func TestWritesNotFound(t *testing.T) {
+	_, r := newTestMux(t)
+	expectCode(t, serve(r, "DELETE", "/note/1", ""), http.StatusNotFound)
+	expectCode(t, serve(r, "GET", "/note/1/link?tags=1", ""), http.StatusNotFound)
+	expectCode(t, serve(r, "POST", "/note/1/restore", ""), http.StatusNotFound)
+}
+
+func TestLinkRollback(t *testing.T) {
+	db, r := newTestMux(t)
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+	expectCode(t, serve(r, "POST", "/tag", `{"name":"x"}`), http.StatusOK)
+
+	var result map[string]LinkStatusCrud
+	w := serve(r, "GET", "/note/1/link?tags=1&tags=9", "")
+	expectCode(t, w, http.StatusNotFound)
+	decode(t, w, &result)
+	if len(result) != 2 || result["tags1_1"].Status != "rollback" || result["tags1_9"].Status != "err" {
+		t.Fatalf("result %+v", result)
+	}
+	var note Note
+	db.Preload("Tags").First(&note, 1)
+	if len(note.Tags) != 0 {
+		t.Fatal("link not rolled back")
+	}
+
+	expectCode(t, serve(r, "GET", "/note/1/link?tags=1", ""), http.StatusOK)
+	expectCode(t, serve(r, "UNLINK", "/note/1?tags=1", ""), http.StatusOK)
+	db.Preload("Tags").First(&note, 1)
+	if len(note.Tags) != 0 {
+		t.Fatalf("tags after unlink %+v", note.Tags)
+	}
+}
+
+// Checked validates the title on save and on delete
+type Checked struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Title string `json:"title"`
+}
+
+func (c *Checked) CrudValidateSave(db *gorm.DB) error {
+	switch c.Title {
+	case "":
+		return errors.New("title required")
+	case "taken":
+		return ErrorCrud{Message: "title taken", Code: http.StatusConflict}
+	}
+	return nil
+}
+
+func (c *Checked) CrudValidateDelete(db *gorm.DB) error {
+	if c.Title == "keep" {
+		return errors.New("kept")
+	}
+	return nil
+}
+
+func TestValidationError(t *testing.T) {
+	db := openTestDB(t, &Checked{})
+	r := mux.NewRouter()
+	MapMux(r, db).NewMap("/checked", Checked{}, []Checked{}).Full()
+	for _, c := range []struct {
+		method, url, body string
+		code              int
+		message           string
+	}{
+		{"POST", "/checked", `{"title":""}`, http.StatusBadRequest, "title required"},
+		{"POST", "/checked", `{"title":"taken"}`, http.StatusConflict, "title taken"},
+		{"POST", "/checked", `{"title":"keep"}`, http.StatusOK, ""},
+		{"DELETE", "/checked/1", ``, http.StatusBadRequest, "kept"},
+	} {
+		w := serve(r, c.method, c.url, c.body)
+		expectCode(t, w, c.code)
+		if c.message == "" {
+			continue
+		}
+		var e ErrorCrud
+		decode(t, w, &e)
+		if e.Message != c.message || e.Code != c.code {
+			t.Errorf("%s %s: %s", c.method, c.body, w.Body.String())
+		}
+	}
+	var rows int
+	db.Model(&Checked{}).Count(&rows)
+	if rows != 1 {
+		t.Fatalf("%d rows after the validation errors", rows)
+	}
+}
@@ -0,0 +1,303 @@
+package gormcrud
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Webhook is one subscription to the events. Resource is the table of the
+// entities ("" for all) and Events is the list of operations separated with
+// comma ("" for all). It can be managed as resource with NewMap, with
+// TenantBy the webhook is of the tenant of the request and it only receives
+// the events of that tenant ("" for the events of all the tenants).
+type Webhook struct {
+	ID        uint      `gorm:"primary_key" json:"id" crud:"readonly"`
+	CreatedAt time.Time `json:"created_at" crud:"readonly"`
+	UpdatedAt time.Time `json:"updated_at" crud:"readonly"`
+	TenantID  string    `json:"tenant_id"`
+	Resource  string    `json:"resource"`
+	Events    string    `json:"events"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret" crud:"writeonly"`
+	Active    bool      `json:"active"`
+}
+
+// TableName is the table of the webhooks
+func (Webhook) TableName() string {
+	return "gormcrud_webhook"
+}
+
+// CrudValidateSave is Validate
+func (hook Webhook) CrudValidateSave(db *gorm.DB) error {
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return ErrorCrud{Message: "URL must be http or https", Code: http.StatusUnprocessableEntity}
+	}
+	host := target.Hostname()
+	if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && !isPublicIP(ip)) {
+		return ErrorCrud{Message: "URL must be a public host", Code: http.StatusUnprocessableEntity}
+	}
+	return nil
+}
+
+// matches return true if the webhook is subscribed to the event
+func (hook Webhook) matches(e Event) bool {
+	if !hook.Active || (hook.Resource != "" && hook.Resource != e.Resource) {
+		return false
+	}
+	if hook.TenantID != "" && hook.TenantID != e.Tenant {
+		return false
+	}
+	if hook.Events == "" {
+		return true
+	}
+	for _, op := range strings.Split(hook.Events, ",") {
+		if strings.TrimSpace(op) == e.Operation {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDispatcher POST the events to the webhooks configured in code and
+// to the webhooks of the table gormcrud_webhook. The body is signed with
+// HMAC-SHA256 of the secret in the header X-Gormcrud-Signature.
+//
+// The deliveries are made by Workers goroutines from a queue of QueueSize
+// deliveries, the events are dropped (and logged) when the queue is full.
+// The webhooks of the table are read again every CacheTTL. The client does
+// not connect to the private, loopback and link local addresses (also after
+// the resolution of the names and the redirects) unless AllowPrivate.
+type WebhookDispatcher struct {
+	DB           *gorm.DB
+	Hooks        []Webhook
+	Client       *http.Client
+	MaxRetries   int
+	Backoff      time.Duration
+	Workers      int
+	QueueSize    int
+	CacheTTL     time.Duration
+	AllowPrivate bool
+
+	start   sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	queue   chan webhookDelivery
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	cached  []Webhook
+	expires time.Time
+}
+
+// webhookDelivery is one event to deliver to one webhook
+type webhookDelivery struct {
+	hook  Webhook
+	event Event
+}
+
+// NewWebhookDispatcher is constructor of WebhookDispatcher. db is optional,
+// if it is not nil the table of the webhooks is migrated.
+func NewWebhookDispatcher(db *gorm.DB, hooks ...Webhook) *WebhookDispatcher {
+	if db != nil {
+		db.AutoMigrate(&Webhook{})
+	}
+	d := &WebhookDispatcher{
+		DB:         db,
+		Hooks:      hooks,
+		MaxRetries: 5,
+		Backoff:    time.Second,
+		Workers:    4,
+		QueueSize:  1000,
+		CacheTTL:   30 * time.Second,
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: d.checkAddress}
+	d.Client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	return d
+}
+
+// privateNetworks are the networks that are not public
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublicIP return true if the ip is not private, loopback, link local,
+// multicast or unspecified
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAddress is the control of the dialer of the client, it rejects the
+// connections to the addresses that are not public
+func (d *WebhookDispatcher) checkAddress(network string, address string, c syscall.RawConn) error {
+	if d.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook to not public address %s", host)
+	}
+	return nil
+}
+
+// Subscribe deliver the events of the bus to the webhooks
+func (d *WebhookDispatcher) Subscribe(bus *EventBus) func() {
+	return bus.Subscribe(d.Handle)
+}
+
+// run start the workers the first time
+func (d *WebhookDispatcher) run() {
+	d.start.Do(func() {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+		d.queue = make(chan webhookDelivery, d.QueueSize)
+		for i := 0; i < d.Workers; i++ {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				for {
+					select {
+					case <-d.ctx.Done():
+						return
+					case job := <-d.queue:
+						d.deliver(job.hook, job.event)
+					}
+				}
+			}()
+		}
+	})
+}
+
+// Handle queue the event for the webhooks subscribed
+func (d *WebhookDispatcher) Handle(e Event) {
+	d.run()
+	if d.ctx.Err() != nil {
+		return
+	}
+	for _, hook := range d.webhooks() {
+		if !hook.matches(e) {
+			continue
+		}
+		select {
+		case d.queue <- webhookDelivery{hook: hook, event: e}:
+		default:
+			log.Printf("gormcrud: webhook %s event %s: queue full", hook.URL, e.ID)
+		}
+	}
+}
+
+// Close stop the workers and wait the deliveries in progress, the
+// deliveries in the queue are dropped
+func (d *WebhookDispatcher) Close() error {
+	d.run()
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+// webhooks return the webhooks of code and of the table, read again after
+// CacheTTL
+func (d *WebhookDispatcher) webhooks() []Webhook {
+	hooks := append([]Webhook{}, d.Hooks...)
+	if d.DB == nil {
+		return hooks
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now := time.Now(); !now.Before(d.expires) {
+		var managed []Webhook
+		if err := d.DB.Where("active = ?", true).Find(&managed).Error; err != nil {
+			log.Printf("gormcrud: webhooks: %v", err)
+		} else {
+			d.cached, d.expires = managed, now.Add(d.CacheTTL)
+		}
+	}
+	return append(hooks, d.cached...)
+}
+
+// deliver POST the event with retries and exponential backoff
+func (d *WebhookDispatcher) deliver(hook Webhook, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	backoff := d.Backoff
+	for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-d.ctx.Done():
+				return
+			}
+			backoff *= 2
+		}
+		if err = d.post(hook, e, body); err == nil {
+			return
+		}
+	}
+	log.Printf("gormcrud: webhook %s event %s: %v", hook.URL, e.ID, err)
+}
+
+func (d *WebhookDispatcher) post(hook Webhook, e Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(d.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gormcrud-Event", e.Type)
+	req.Header.Set("X-Gormcrud-Delivery", e.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-Gormcrud-Signature", "sha256="+Sign(hook.Secret, body))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ErrorCrud{Message: resp.Status, Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// Sign return the hex HMAC-SHA256 of the body with the secret, the receiver
+// of the webhook can compare it with the header X-Gormcrud-Signature
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
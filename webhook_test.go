@@ -0,0 +1,209 @@
+package gormcrud
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	for _, u := range []string{"ftp://example.com", "http://", "http://localhost:8080/x", "http://127.0.0.1/x", "http://10.1.2.3", "http://[::1]/x", "http://169.254.169.254/latest"} {
+		if (Webhook{URL: u}).CrudValidateSave(nil) == nil {
+			t.Errorf("%s accepted", u)
+		}
+	}
+	for _, u := range []string{"https://example.com/hook", "http://8.8.8.8:8080"} {
+		if err := (Webhook{URL: u}).CrudValidateSave(nil); err != nil {
+			t.Errorf("%s: %v", u, err)
+		}
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	e := Event{Resource: "note", Operation: "update", Tenant: "1"}
+	cases := []struct {
+		hook  Webhook
+		match bool
+	}{
+		{Webhook{Active: true}, true},
+		{Webhook{}, false},
+		{Webhook{Active: true, Resource: "tag"}, false},
+		{Webhook{Active: true, Resource: "note", Events: "create, update"}, true},
+		{Webhook{Active: true, Events: "delete"}, false},
+		{Webhook{Active: true, TenantID: "1"}, true},
+		{Webhook{Active: true, TenantID: "2"}, false},
+	}
+	for _, c := range cases {
+		if c.hook.matches(e) != c.match {
+			t.Errorf("%+v matches %v", c.hook, !c.match)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db := openTestDB(t)
+	var calls int32
+	got := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first delivery fails and it is retried
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		got <- r
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	bus := NewEventBus()
+	d := NewWebhookDispatcher(db)
+	d.AllowPrivate = true
+	d.Backoff = time.Millisecond
+	defer d.Close()
+	d.Subscribe(bus)
+	// the webhook of the table is written without the validation of the url
+	db.Create(&Webhook{URL: srv.URL, Secret: "k", Active: true, Resource: "note"})
+	r := mux.NewRouter()
+	MapMux(r, db).PublishTo(bus).NewMap("/note", Note{}, []Note{}).Full()
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`), http.StatusOK)
+
+	select {
+	case req := <-got:
+		body := <-bodies
+		if req.Header.Get("X-Gormcrud-Event") != "note.create" || req.Header.Get("X-Gormcrud-Signature") != "sha256="+Sign("k", []byte(body)) {
+			t.Fatalf("headers %v", req.Header)
+		}
+		if !strings.Contains(body, `"title":"a"`) {
+			t.Fatalf("body %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+}
+
+func TestWebhookTenant(t *testing.T) {
+	db := openTestDB(t, &Webhook{})
+	paths := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer srv.Close()
+
+	bus := NewEventBus()
+	d := NewWebhookDispatcher(db)
+	d.AllowPrivate = true
+	d.CacheTTL = 0
+	defer d.Close()
+	d.Subscribe(bus)
+	r := mux.NewRouter()
+	MapMux(r, db).PublishTo(bus).TenantBy(TenantFromHeader("X-Tenant")).
+		NewMap("/webhook", Webhook{}, []Webhook{}).Full().
+		NewMap("/note", Note{}, []Note{}).Full()
+
+	// the webhook is of the tenant of the request
+	expectCode(t, serve(r, "POST", "/webhook", `{"url":"https://example.com/hook","tenant_id":"2"}`, "X-Tenant", "1"), http.StatusOK)
+	var hook Webhook
+	db.First(&hook)
+	if hook.TenantID != "1" {
+		t.Fatalf("tenant of the webhook %+v", hook)
+	}
+	db.Delete(&hook)
+
+	db.Create(&Webhook{URL: srv.URL + "/1", Active: true, Resource: "note", TenantID: "1"})
+	db.Create(&Webhook{URL: srv.URL + "/2", Active: true, Resource: "note", TenantID: "2"})
+	expectCode(t, serve(r, "POST", "/note", `{"title":"a"}`, "X-Tenant", "2"), http.StatusOK)
+	select {
+	case path := <-paths:
+		if path != "/2" {
+			t.Fatalf("event of tenant 2 delivered to %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	select {
+	case path := <-paths:
+		t.Fatalf("event of tenant 2 delivered to %s", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	d := NewWebhookDispatcher(nil)
+	d.run()
+	defer d.Close()
+	err := d.post(Webhook{URL: srv.URL}, Event{}, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Fatalf("error %v", err)
+	}
+	d.AllowPrivate = true
+	if err := d.post(Webhook{URL: srv.URL}, Event{}, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookQueue(t *testing.T) {
+	d := NewWebhookDispatcher(nil, Webhook{URL: "http://example.com", Active: true})
+	d.Workers = 0
+	d.QueueSize = 2
+	for i := 0; i < 5; i++ {
+		d.Handle(Event{})
+	}
+	if len(d.queue) != 2 {
+		t.Fatalf("queue %d", len(d.queue))
+	}
+	d.Close()
+	d.Handle(Event{})
+	if len(d.queue) != 2 {
+		t.Fatal("event queued after Close")
+	}
+}
+
+func TestWebhookClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d := NewWebhookDispatcher(nil, Webhook{URL: srv.URL, Active: true})
+	d.AllowPrivate = true
+	d.Backoff = time.Hour
+	d.Handle(Event{})
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waits the backoff")
+	}
+}
+
+func TestWebhookCache(t *testing.T) {
+	db := openTestDB(t)
+	d := NewWebhookDispatcher(db)
+	db.Create(&Webhook{URL: "http://example.com/1", Active: true})
+	if n := len(d.webhooks()); n != 1 {
+		t.Fatalf("%d webhooks", n)
+	}
+	db.Create(&Webhook{URL: "http://example.com/2", Active: true})
+	if n := len(d.webhooks()); n != 1 {
+		t.Fatalf("%d webhooks before CacheTTL", n)
+	}
+	d.expires = time.Time{}
+	if n := len(d.webhooks()); n != 2 {
+		t.Fatalf("%d webhooks after CacheTTL", n)
+	}
+}
@@ -0,0 +1,95 @@
+package gormcrud
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The websocket of the live endpoint, with the messages of text of the
+// client, the ping of the server and the read deadline extended by the pong.
+
+// maxMessage is the max size of one message of the client
+const maxMessage = 1 << 20
+
+// liveWriteWait is the max time to write one message to the client
+const liveWriteWait = 10 * time.Second
+
+// wsConn is one websocket connection of the server, the writes are
+// serialized
+type wsConn struct {
+	conn     *websocket.Conn
+	mu       sync.Mutex
+	pongWait time.Duration
+}
+
+// upgradeWebsocket do the handshake of the request, it writes the error and
+// returns nil on failure. The origin of the browsers is checked by
+// LiveHub.CheckOrigin, the same host by default.
+func (hub *LiveHub) upgradeWebsocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: hub.CheckOrigin,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			w.Header().Set("Content-Type", "application/json")
+			WriteError(w, ErrorCrud{Message: reason.Error(), Code: status})
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil
+	}
+	c := &wsConn{conn: conn, pongWait: hub.PongWait}
+	conn.SetReadLimit(maxMessage)
+	c.extendDeadline()
+	conn.SetPongHandler(func(string) error {
+		c.extendDeadline()
+		return nil
+	})
+	return c
+}
+
+// extendDeadline set the read deadline after the wait of the next pong
+func (c *wsConn) extendDeadline() {
+	if c.pongWait > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	}
+}
+
+// writeText write one message of text
+func (c *wsConn) writeText(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// ping write the frame ping, the client answers with pong
+func (c *wsConn) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait))
+}
+
+// close write the frame close with the status and close the connection
+func (c *wsConn) close(status int, reason string) {
+	c.mu.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(status, reason), time.Now().Add(liveWriteWait))
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// readMessage return the next message of text of the client, the ping and
+// the close of the client are answered by the connection
+func (c *wsConn) readMessage() ([]byte, error) {
+	op, message, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if op != websocket.TextMessage {
+		c.close(websocket.CloseUnsupportedData, "binary messages not supported")
+		return nil, websocket.ErrCloseSent
+	}
+	return message, nil
+}